@@ -0,0 +1,205 @@
+package auth_test
+
+import (
+	"app/auth"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zeebo/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func signHS256(t *testing.T, secret string, claims auth.Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	assert.NoError(t, err)
+	return token
+}
+
+func validClaims(subject string, roles ...string) auth.Claims {
+	return auth.Claims{
+		Roles: roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestHS256Verifier(t *testing.T) {
+	secret := "top-secret"
+	verifier, err := auth.NewHS256Verifier([]byte(secret), auth.VerifierOptions{AdminRole: "admin"})
+	assert.NoError(t, err)
+
+	expired := validClaims("1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name         string
+		token        string
+		expectations func(t *testing.T, identity auth.Identity, err error)
+	}{
+		{
+			name:  "valid_user_token",
+			token: signHS256(t, secret, validClaims("1")),
+			expectations: func(t *testing.T, identity auth.Identity, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, auth.Identity{UserId: "1"}, identity)
+			},
+		},
+		{
+			name:  "valid_admin_token",
+			token: signHS256(t, secret, validClaims("7", "admin")),
+			expectations: func(t *testing.T, identity auth.Identity, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, auth.Identity{UserId: "7", Admin: true}, identity)
+			},
+		},
+		{
+			name:  "wrong_secret",
+			token: signHS256(t, "another-secret", validClaims("1")),
+			expectations: func(t *testing.T, identity auth.Identity, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			name:  "expired_token",
+			token: signHS256(t, secret, expired),
+			expectations: func(t *testing.T, identity auth.Identity, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			name:  "missing_subject",
+			token: signHS256(t, secret, validClaims("")),
+			expectations: func(t *testing.T, identity auth.Identity, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := verifier.Verify(test.token)
+			test.expectations(t, identity, err)
+		})
+	}
+}
+
+func TestRS256VerifierWithJWKSFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(jwks)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	keys, err := auth.LoadJWKSFile(path)
+	assert.NoError(t, err)
+	verifier, err := auth.NewRS256Verifier(keys, auth.VerifierOptions{})
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims("3"))
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	identity, err := verifier.Verify(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.Identity{UserId: "3"}, identity)
+
+	token.Header["kid"] = "unknown"
+	signed, err = token.SignedString(key)
+	assert.NoError(t, err)
+	_, err = verifier.Verify(signed)
+	assert.Error(t, err)
+
+	hsToken := signHS256(t, "secret", validClaims("3"))
+	_, err = verifier.Verify(hsToken)
+	assert.Error(t, err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	secret := "top-secret"
+	verifier, err := auth.NewHS256Verifier([]byte(secret), auth.VerifierOptions{})
+	assert.NoError(t, err)
+	interceptor := auth.UnaryServerInterceptor(verifier)
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+
+	var captured auth.Identity
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		identity, ok := auth.FromContext(ctx)
+		assert.True(t, ok)
+		captured = identity
+		return "ok", nil
+	}
+
+	tests := []struct {
+		name         string
+		md           metadata.MD
+		expectedCode codes.Code
+	}{
+		{
+			name:         "valid_token",
+			md:           metadata.Pairs("authorization", "Bearer "+signHS256(t, secret, validClaims("5"))),
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "missing_metadata",
+			md:           metadata.MD{},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "not_a_bearer_token",
+			md:           metadata.Pairs("authorization", "Basic dXNlcjpwYXNz"),
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "invalid_token",
+			md:           metadata.Pairs("authorization", "Bearer not-a-jwt"),
+			expectedCode: codes.Unauthenticated,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), test.md)
+			_, err := interceptor(ctx, nil, info, handler)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+		})
+	}
+	assert.Equal(t, auth.Identity{UserId: "5"}, captured)
+}
+
+func TestAuthorize(t *testing.T) {
+	assert.Equal(t, codes.Unauthenticated, status.Code(auth.Authorize(context.Background(), "1")))
+
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: "1"})
+	assert.NoError(t, auth.Authorize(ctx, "1"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(auth.Authorize(ctx, "2")))
+
+	admin := auth.NewContext(context.Background(), auth.Identity{UserId: "9", Admin: true})
+	assert.NoError(t, auth.Authorize(admin, "2"))
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is the authenticated caller of an RPC
+type Identity struct {
+	UserId string
+	Admin  bool
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the given identity
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity stored in ctx by the auth interceptor, if any
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// Authorize checks that the caller is allowed to act as the given user, either because it is that user or because it is an admin
+func Authorize(ctx context.Context, userId string) error {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing caller identity")
+	}
+
+	if identity.Admin || identity.UserId == userId {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "caller %s is not allowed to act on behalf of user %s", identity.UserId, userId)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const authorizationHeader = "authorization"
+
+// UnaryServerInterceptor authenticates every call with the bearer token from the "authorization" metadata
+// and stores the resulting identity in the request context
+func UnaryServerInterceptor(verifier Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token, err := bearerToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		identity, err := verifier.Verify(token)
+		if err != nil {
+			log.Printf("Rejected call to %s: %s", info.FullMethod, err)
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return handler(NewContext(ctx, identity), req)
+	}
+}
+
+// InsecureUnaryServerInterceptor treats every caller as an admin. It must only be used for local development.
+func InsecureUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(NewContext(ctx, Identity{Admin: true}), req)
+	}
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return "", status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", status.Error(codes.Unauthenticated, "authorization metadata must be a bearer token")
+	}
+
+	return strings.TrimSpace(token), nil
+}
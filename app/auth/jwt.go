@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the JWT claims accepted by the server. The subject holds the user ID.
+type Claims struct {
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier turns a bearer token into the identity of the caller
+type Verifier interface {
+	Verify(token string) (Identity, error)
+}
+
+type JWTVerifier struct {
+	parser    *jwt.Parser
+	keyFunc   jwt.Keyfunc
+	adminRole string
+}
+
+type VerifierOptions struct {
+	Issuer    string
+	Audience  string
+	AdminRole string
+}
+
+// NewHS256Verifier creates a verifier for tokens signed with a shared secret
+func NewHS256Verifier(secret []byte, opts VerifierOptions) (*JWTVerifier, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("HS256 secret must not be empty")
+	}
+
+	return newJWTVerifier(jwt.SigningMethodHS256.Alg(), func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, opts), nil
+}
+
+// NewRS256Verifier creates a verifier for tokens signed with one of the given RSA keys.
+// Keys are looked up by the token "kid" header; tokens without a kid use the key stored under "".
+func NewRS256Verifier(keys map[string]*rsa.PublicKey, opts VerifierOptions) (*JWTVerifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("RS256 requires at least one public key")
+	}
+
+	return newJWTVerifier(jwt.SigningMethodRS256.Alg(), func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}, opts), nil
+}
+
+func newJWTVerifier(algorithm string, keyFunc jwt.Keyfunc, opts VerifierOptions) *JWTVerifier {
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{algorithm}),
+		jwt.WithExpirationRequired(),
+	}
+	if opts.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(opts.Audience))
+	}
+
+	return &JWTVerifier{
+		parser:    jwt.NewParser(parserOptions...),
+		keyFunc:   keyFunc,
+		adminRole: opts.AdminRole,
+	}
+}
+
+// Verify validates the token signature and claims and returns the identity it carries
+func (v *JWTVerifier) Verify(token string) (Identity, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return Identity{}, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, errors.New("invalid token: missing subject")
+	}
+
+	return Identity{
+		UserId: claims.Subject,
+		Admin:  v.adminRole != "" && slices.Contains(claims.Roles, v.adminRole),
+	}, nil
+}
+
+// LoadRSAPublicKeyFile reads a PEM encoded RSA public key
+func LoadRSAPublicKeyFile(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key: %w", err)
+	}
+
+	key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key: %w", err)
+	}
+
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadJWKSFile reads the RSA signing keys of a JSON Web Key Set file, indexed by key ID
+func LoadJWKSFile(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") || (jwk.Alg != "" && jwk.Alg != "RS256") {
+			continue
+		}
+
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in JWKS file: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS file does not contain any RS256 signing key")
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
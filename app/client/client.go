@@ -8,16 +8,45 @@ import (
 	"time"
 
 	pb "app/explore_service_protos"
+	"app/tlsconfig"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 func main() {
-	conn, err := grpc.Dial("localhost:9000", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	var command, actorId, recipientId, page, token string
+	var address, caFile, certFile, keyFile, serverName string
+	var like, useTLS bool
+	flag.StringVar(&command, "function", "", "-function=ListLikedYou, ListNewLikedYou, CountLikedYou and PutDecision")
+	flag.StringVar(&actorId, "actor", "1", "-actor=1 | id to call specific actor user")
+	flag.StringVar(&recipientId, "recipient", "1", "-recipient=1 | id to call specific recipient user")
+	flag.BoolVar(&like, "like", true, "-like=false | Can only be used on PutDecision")
+	flag.StringVar(&page, "page", "1", "-page=1 | page number to paginate likes")
+	flag.StringVar(&token, "token", "", "-token=<jwt> | bearer token identifying the caller")
+	flag.StringVar(&address, "addr", "localhost:9000", "-addr=localhost:9000 | address of the gRPC server")
+	flag.BoolVar(&useTLS, "tls", false, "-tls=true | connect using TLS")
+	flag.StringVar(&caFile, "ca-cert", "", "-ca-cert=ca.pem | CA used to verify the server, defaults to the system roots")
+	flag.StringVar(&certFile, "cert", "", "-cert=client.pem | client certificate for mutual TLS")
+	flag.StringVar(&keyFile, "key", "", "-key=client-key.pem | client key for mutual TLS")
+	flag.StringVar(&serverName, "server-name", "", "-server-name=localhost | overrides the name checked against the server certificate")
+	flag.Parse()
+
+	transportCredentials := insecure.NewCredentials()
+	if useTLS || caFile != "" || certFile != "" {
+		tlsConfig, err := tlsconfig.Client(caFile, certFile, keyFile, serverName)
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+		transportCredentials = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(transportCredentials))
 
 	if err != nil {
-		log.Fatalf("failed to connect to gRPC server at localhost:9000 : %v", err)
+		log.Fatalf("failed to connect to gRPC server at %s : %v", address, err)
 	}
 
 	defer conn.Close()
@@ -27,14 +56,9 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	var command, actorId, recipientId, page string
-	var like bool
-	flag.StringVar(&command, "function", "", "-function=ListLikedYou, ListNewLikedYou, CountLikedYou and PutDecision")
-	flag.StringVar(&actorId, "actor", "1", "-actor=1 | id to call specific actor user")
-	flag.StringVar(&recipientId, "recipient", "1", "-recipient=1 | id to call specific recipient user")
-	flag.BoolVar(&like, "like", true, "-like=false | Can only be used on PutDecision")
-	flag.StringVar(&page, "page", "1", "-page=1 | page number to paginate likes")
-	flag.Parse()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
 
 	switch command {
 	case "ListLikedYou":
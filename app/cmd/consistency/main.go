@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"app/config"
+	"app/consistency"
+	"app/database/sqlite"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+)
+
+// consistency scans users and decisions in batches and reports, or repairs with -dry-run=false, the rows breaking
+// the invariants of the writers. With -state the progress is saved after every batch and an interrupted run is
+// resumed from it.
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var reportPath, statePath, checks string
+	checker := consistency.Checker{}
+	flag.StringVar(&cfg.Storage, "storage", cfg.Storage, "-storage=mysql|sqlite | backend storing users and decisions")
+	flag.IntVar(&checker.BatchSize, "batch-size", cfg.Reconcile.BatchSize, "-batch-size=500 | rows read per query, defaults to RECONCILE_BATCH_SIZE")
+	flag.BoolVar(&checker.DryRun, "dry-run", true, "-dry-run=false | repair the issues found instead of only reporting them")
+	flag.IntVar(&checker.MaxIssues, "max-issues", 1000, "-max-issues=1000 | issues listed in the report, every issue is counted")
+	flag.StringVar(&checks, "checks", "", "-checks=counters,new_passes | checks to run, defaults to all of "+joinChecks(consistency.Checks))
+	flag.StringVar(&reportPath, "report", "", "-report=report.json | file the JSON report is written to, defaults to stdout")
+	flag.StringVar(&statePath, "state", "", "-state=consistency.state.json | file the progress is saved to and resumed from")
+	flag.Parse()
+
+	if checks != "" {
+		for _, check := range strings.Split(checks, ",") {
+			checker.Checks = append(checker.Checks, consistency.Check(strings.TrimSpace(check)))
+		}
+	}
+
+	checker.DB, err = open(cfg)
+	if err != nil {
+		log.Fatalf("unable to open the %s database: %v", cfg.Storage, err)
+	}
+	defer checker.DB.Close()
+
+	report, err := loadState(statePath, checker.DryRun)
+	if err != nil {
+		log.Fatalf("unable to load the state: %v", err)
+	}
+	if report.Cursor != (consistency.Cursor{Phase: consistency.PhaseDecisions}) {
+		log.Printf("Resuming from %s after id %d", report.Cursor.Phase, report.Cursor.AfterId)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = checker.Run(ctx, report, func(report *consistency.Report) error {
+		if statePath == "" {
+			return nil
+		}
+		return writeJSON(statePath, report)
+	})
+	if err != nil {
+		log.Fatalf("consistency check stopped at %s after id %d: %v", report.Cursor.Phase, report.Cursor.AfterId, err)
+	}
+
+	if reportPath == "" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = writeJSON(reportPath, report)
+	}
+	if err != nil {
+		log.Fatalf("unable to write the report: %v", err)
+	}
+
+	// the next run starts over once the report is written
+	if statePath != "" {
+		if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("unable to remove the state: %v", err)
+		}
+	}
+}
+
+// open connects to the database of the selected storage, the checks only support the MySQL dialect and SQLite
+func open(cfg config.Config) (*sql.DB, error) {
+	switch cfg.Storage {
+	case config.StorageMySQL:
+		return sql.Open("mysql", cfg.MySQL.DSN())
+	case config.StorageSQLite:
+		return sqlite.Open(cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unsupported storage %q", cfg.Storage)
+	}
+}
+
+// loadState resumes the report saved in path, or starts a new one when there is none
+func loadState(path string, dryRun bool) (*consistency.Report, error) {
+	if path == "" {
+		return consistency.NewReport(dryRun), nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return consistency.NewReport(dryRun), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report consistency.Report
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, fmt.Errorf("invalid state %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// writeJSON replaces path atomically so an interruption never leaves a truncated file
+func writeJSON(path string, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(content, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func joinChecks(checks []consistency.Check) string {
+	names := make([]string, 0, len(checks))
+	for _, check := range checks {
+		names = append(names, string(check))
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"app/config"
+	"app/database"
+	"app/database/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: go run ./cmd/migrate [flags] <command> [argument]
+
+Commands:
+  up [N]       apply all or N pending migrations, with their backfills
+  down [N]     roll back all or N applied migrations
+  goto V       migrate up or down to version V
+  backfill     complete the backfills of the applied migrations, after an interruption
+  version      print the current version
+  force V      set the version to V and clear the dirty flag, without running any migration
+  status       list the migrations and the backfills, applied or pending
+  create NAME  scaffold the up and down files of the next migration
+
+Flags:
+`
+
+// sourceDirs holds the migrations of every driver, relative to the app directory, where create adds them
+var sourceDirs = map[string]string{
+	config.StorageMySQL:    "database/migrations/mysql",
+	config.StoragePostgres: "database/migrations/postgres",
+	config.StorageSQLite:   "database/migrations/sqlite",
+}
+
+var commands = map[string]bool{"up": true, "down": true, "goto": true, "backfill": true, "version": true, "force": true, "status": true, "create": true}
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// migrate runs the schema migrations of the storage against the database configured like the server, or -dsn
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	driverName := cfg.Storage
+	if _, ok := sourceDirs[driverName]; !ok {
+		driverName = config.StorageMySQL
+	}
+	var dsn, dir string
+	var options migrations.BackfillOptions
+	var maxLag time.Duration
+	flag.StringVar(&driverName, "driver", driverName, "-driver=mysql|postgres|sqlite | database to migrate, defaults to STORAGE")
+	flag.StringVar(&dsn, "dsn", "", "-dsn=... | connection string or sqlite file, defaults to the configuration of the driver")
+	flag.StringVar(&dir, "path", "", "-path=database/migrations/mysql | migrations directory, defaults to the migrations embedded for the driver")
+	flag.IntVar(&options.BatchSize, "batch-size", migrations.DefaultBackfillBatchSize, "-batch-size=1000 | rows updated per backfill chunk")
+	flag.DurationVar(&options.Pause, "pause", 0, "-pause=100ms | wait between two backfill chunks")
+	flag.DurationVar(&maxLag, "max-lag", cfg.MySQL.ReplicaMaxLag, "-max-lag=5s | pause the MySQL backfills while a replica of MYSQL_REPLICAS is further behind")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, argument := flag.Arg(0), flag.Arg(1)
+	if !commands[command] {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, ok := sourceDirs[driverName]; !ok {
+		log.Fatalf("unsupported driver %q", driverName)
+	}
+
+	if command == "create" {
+		if dir == "" {
+			dir = sourceDirs[driverName]
+		}
+		if err := create(dir, argument); err != nil {
+			log.Fatalf("create failed: %v", err)
+		}
+		return
+	}
+
+	if dsn == "" {
+		switch driverName {
+		case config.StorageMySQL:
+			dsn = cfg.MySQL.DSN()
+		case config.StoragePostgres:
+			dsn = cfg.Postgres.DSN()
+		case config.StorageSQLite:
+			dsn = cfg.SQLitePath
+		}
+	}
+	m, err := open(driverName, dsn, dir)
+	if err != nil {
+		log.Fatalf("unable to open the %s migrations: %v", driverName, err)
+	}
+	defer m.Close()
+
+	db, err := migrations.OpenDB(driverName, dsn)
+	if err != nil {
+		log.Fatalf("unable to open the %s database: %v", driverName, err)
+	}
+	defer db.Close()
+	if driverName == config.StorageMySQL && len(cfg.MySQL.Replicas) > 0 {
+		replicas, err := openReplicas(cfg.MySQL.ReplicaDSNs())
+		if err != nil {
+			log.Fatalf("unable to open the replicas: %v", err)
+		}
+		options.Throttle = migrations.WaitForReplicas(replicas, database.MySQLReplicaLag, maxLag, time.Second)
+	}
+	backfiller := migrations.Backfiller{DB: db, DriverName: driverName, Backfills: migrations.Backfills(driverName), Options: options}
+
+	// an interrupted backfill resumes from its last chunk on the next up or backfill
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, m, backfiller, dir, command, argument); err != nil {
+		log.Fatalf("%s failed: %v", command, err)
+	}
+}
+
+func openReplicas(dsns []string) ([]*sql.DB, error) {
+	var replicas []*sql.DB
+	for _, dsn := range dsns {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas, nil
+}
+
+// open applies the embedded migrations, or those of dir when set
+func open(driverName string, dsn string, dir string) (*migrate.Migrate, error) {
+	if dir == "" {
+		return migrations.Open(driverName, dsn)
+	}
+	files, err := source.Open("file://" + filepath.ToSlash(dir))
+	if err != nil {
+		return nil, err
+	}
+	return migrations.OpenWithSource(driverName, dsn, "file", files)
+}
+
+func run(ctx context.Context, m *migrate.Migrate, backfiller migrations.Backfiller, dir string, command string, argument string) error {
+	var err error
+	switch command {
+	case "up":
+		n := -1
+		if argument != "" {
+			if n, err = positive(argument); err != nil {
+				return err
+			}
+		}
+		err = migrations.Up(ctx, m, backfiller, n)
+	case "down":
+		if argument == "" {
+			err = m.Down()
+		} else {
+			var n int
+			if n, err = positive(argument); err != nil {
+				return err
+			}
+			err = m.Steps(-n)
+		}
+		if err == nil {
+			err = reset(ctx, m, backfiller)
+		}
+	case "goto":
+		var version int
+		if version, err = positive(argument); err != nil {
+			return err
+		}
+		err = migrateTo(ctx, m, backfiller, dir, uint(version))
+	case "backfill":
+		version, dirty, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			fmt.Println("no migration applied")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return migrate.ErrDirty{Version: int(version)}
+		}
+		return backfiller.Run(ctx, version)
+	case "force":
+		var version int
+		if version, err = strconv.Atoi(argument); err != nil || version < -1 {
+			return fmt.Errorf("invalid version %q", argument)
+		}
+		err = m.Force(version)
+	case "version":
+		version, dirty, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			fmt.Println("no migration applied")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d%s\n", version, dirtySuffix(dirty))
+		return nil
+	case "status":
+		return status(ctx, m, backfiller, dir)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no change")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("no migration applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("at version %d%s\n", version, dirtySuffix(dirty))
+	return nil
+}
+
+// migrateTo steps up to version with the backfills, or rolls back to it
+func migrateTo(ctx context.Context, m *migrate.Migrate, backfiller migrations.Backfiller, dir string, version uint) error {
+	files, err := openSource(backfiller.DriverName, dir)
+	if err != nil {
+		return err
+	}
+	defer files.Close()
+	if _, _, err := files.ReadUp(version); err != nil {
+		return fmt.Errorf("no migration %d: %w", version, err)
+	}
+
+	current, _, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) || err == nil && current < version {
+		return migrations.UpTo(ctx, m, backfiller, version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.Migrate(version); err != nil {
+		return err
+	}
+	return reset(ctx, m, backfiller)
+}
+
+// reset forgets the backfills of the migrations rolled back
+func reset(ctx context.Context, m *migrate.Migrate, backfiller migrations.Backfiller) error {
+	version, _, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return backfiller.Reset(ctx, 0)
+	}
+	if err != nil {
+		return err
+	}
+	return backfiller.Reset(ctx, version)
+}
+
+// openSource opens the embedded migrations of driverName, or those of dir when set
+func openSource(driverName string, dir string) (source.Driver, error) {
+	if dir == "" {
+		return migrations.Source(driverName)
+	}
+	return source.Open("file://" + filepath.ToSlash(dir))
+}
+
+// status lists the migrations, those up to the current version being applied, then the backfills
+func status(ctx context.Context, m *migrate.Migrate, backfiller migrations.Backfiller, dir string) error {
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	applied := err == nil
+
+	files, err := openSource(backfiller.DriverName, dir)
+	if err != nil {
+		return err
+	}
+	defer files.Close()
+
+	version, err := files.First()
+	for err == nil {
+		state := "pending"
+		if applied && version <= current {
+			state = "applied"
+			if version == current && dirty {
+				state = "dirty"
+			}
+		}
+		fmt.Printf("%6d  %-8s %s\n", version, state, identifier(files, version))
+		version, err = files.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	backfills, err := backfiller.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, backfill := range backfills {
+		state := "pending"
+		switch {
+		case backfill.Done:
+			state = "done"
+		case backfill.Started:
+			state = fmt.Sprintf("at key %d", backfill.LastKey)
+		}
+		fmt.Printf("%6d  backfill %s: %s, %d rows updated\n", backfill.Version, backfill.Name, state, backfill.Rows)
+	}
+	return nil
+}
+
+func identifier(files source.Driver, version uint) string {
+	body, identifier, err := files.ReadUp(version)
+	if err != nil {
+		return ""
+	}
+	body.Close()
+	return identifier
+}
+
+// create writes empty up and down files for the version following the last one of dir
+func create(dir string, name string) error {
+	if !migrationName.MatchString(name) {
+		return fmt.Errorf("invalid name %q, use lowercase letters, digits and underscores", name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var last uint64
+	for _, entry := range entries {
+		var version uint64
+		if _, err := fmt.Sscanf(entry.Name(), "%d_", &version); err == nil && version > last {
+			last = version
+		}
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%d_%s.%s.sql", last+1, name, direction))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+func positive(argument string) (int, error) {
+	n, err := strconv.Atoi(argument)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number %q", argument)
+	}
+	return n, nil
+}
+
+func dirtySuffix(dirty bool) string {
+	if dirty {
+		return " (dirty)"
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"app/gateway"
+)
+
+// openapi writes the OpenAPI document of the REST gateway, generated from the proto definitions
+func main() {
+	var out string
+	flag.StringVar(&out, "out", "openapi.json", "-out=openapi.json | file the document is written to")
+	flag.Parse()
+
+	document, err := gateway.OpenAPI()
+	if err != nil {
+		log.Fatalf("unable to generate OpenAPI document: %v", err)
+	}
+
+	if err := os.WriteFile(out, append(document, '\n'), 0o644); err != nil {
+		log.Fatalf("unable to write %s: %v", out, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"app/config"
+	"app/database"
+	"app/reshard"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+)
+
+// reshard moves a range of buckets of MYSQL_SHARD_MAP to another shard of MYSQL_SHARDS, then prints the shard map
+// to restart the servers with. The servers and jobs writing to the shards must be stopped during the move, an
+// interrupted move is resumed by running it again.
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var buckets string
+	resharder := reshard.Resharder{}
+	flag.StringVar(&buckets, "buckets", "", "-buckets=512-767 | range of buckets moved, on a single shard")
+	flag.IntVar(&resharder.To, "to", -1, "-to=1 | index of the shard the buckets move to, 0 is MYSQL_HOST and the others follow MYSQL_SHARDS")
+	flag.IntVar(&resharder.BatchSize, "batch-size", cfg.Reconcile.BatchSize, fmt.Sprintf("-batch-size=500 | rows moved per transaction, at most %d, defaults to RECONCILE_BATCH_SIZE", database.MaxBulkRows))
+	flag.Parse()
+
+	if len(cfg.MySQL.Shards) == 0 {
+		log.Fatalf("MYSQL_SHARDS is not set")
+	}
+	if resharder.Range, err = database.ParseBucketRange(buckets); err != nil {
+		log.Fatalf("invalid -buckets: %v", err)
+	}
+	dsns := cfg.MySQL.ShardDSNs()
+	if resharder.Buckets, err = database.ParseShardMap(cfg.MySQL.ShardMap, len(dsns)); err != nil {
+		log.Fatalf("invalid MYSQL_SHARD_MAP: %v", err)
+	}
+	resharder.Options = cfg.MySQL.QueryOptions()
+	for index, dsn := range dsns {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			log.Fatalf("unable to open shard %d: %v", index, err)
+		}
+		defer db.Close()
+		resharder.Shards = append(resharder.Shards, db)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := resharder.Run(ctx)
+	if err != nil {
+		log.Fatalf("resharding failed, run it again to resume: %v", err)
+	}
+	log.Printf("Moved buckets %s from shard %d to shard %d: %d decisions, %d sent likes", resharder.Range, summary.From, resharder.To, summary.Decisions, summary.SentLikes)
+	log.Printf("Recomputed the counters of %d users", summary.Repaired)
+	fmt.Printf("MYSQL_SHARD_MAP=%s\n", summary.Buckets)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"app/config"
+	"app/database"
+	"app/database/sqlite"
+	"app/seed"
+
+	"github.com/joho/godotenv"
+)
+
+// seed generates synthetic users and decisions after the last user of the database, then recomputes the counters
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var options seed.Options
+	flag.StringVar(&cfg.Storage, "storage", cfg.Storage, "-storage=mysql|sqlite | backend storing users and decisions")
+	flag.IntVar(&options.Users, "users", 1000, "-users=1000 | users generated")
+	flag.Float64Var(&options.FemaleRatio, "female-ratio", 0.5, "-female-ratio=0.5 | share of users with the f gender, the others are m")
+	flag.Float64Var(&options.ActiveRatio, "active-ratio", 0.9, "-active-ratio=0.9 | share of active users, only they make and receive decisions")
+	flag.IntVar(&options.Decisions, "decisions", 20, "-decisions=20 | mean decisions made by an active user")
+	flag.Float64Var(&options.Exponent, "exponent", 1.5, "-exponent=1.5 | power law shape of the popularity and activity, lower concentrates the decisions on fewer users")
+	flag.Float64Var(&options.LikeRatio, "like-ratio", 0.3, "-like-ratio=0.3 | probability of liking the least popular users")
+	flag.Float64Var(&options.NewRatio, "new-ratio", 0.3, "-new-ratio=0.3 | share of likes not viewed yet")
+	flag.Uint64Var(&options.Seed, "seed", 1, "-seed=1 | the same seed generates the same data")
+	flag.IntVar(&options.BatchSize, "batch-size", 1000, fmt.Sprintf("-batch-size=1000 | rows per insert, at most %d", database.MaxBulkRows))
+	flag.Parse()
+
+	seeder := seed.Seeder{Options: options}
+	db, err := open(cfg, &seeder)
+	if err != nil {
+		log.Fatalf("unable to open the %s database: %v", cfg.Storage, err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := seeder.Run(ctx)
+	if err != nil {
+		log.Fatalf("seed failed: %v", err)
+	}
+	log.Printf("Added users %d to %d: %d women, %d active", summary.FirstUserId, summary.LastUserId, summary.Female, summary.Active)
+	log.Printf("Added %d decisions: %d likes, %d matches", summary.Decisions, summary.Likes, summary.Matches)
+	log.Printf("Recomputed the counters of %d users", summary.Repaired)
+}
+
+// open connects to the database of the selected storage and sets the writers of the seeder
+func open(cfg config.Config, seeder *seed.Seeder) (*sql.DB, error) {
+	switch cfg.Storage {
+	case config.StorageMySQL:
+		db, err := sql.Open("mysql", cfg.MySQL.DSN())
+		if err != nil {
+			return nil, err
+		}
+		options := cfg.MySQL.QueryOptions()
+		bulk, err := database.NewDatabaseBulkWriter(db, options)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		writer, err := database.NewDatabaseWriter(db, options)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		seeder.Writer, seeder.Reconciler = bulk, writer
+		return db, nil
+	case config.StorageSQLite:
+		db, err := sqlite.Open(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		writer := sqlite.NewDatabaseWriter(db)
+		seeder.Writer, seeder.Reconciler = writer, writer
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage %q", cfg.Storage)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"app/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joho/godotenv"
+)
+
+// token mints HS256 tokens signed with AUTH_JWT_SECRET so the client can be used against a local server
+func main() {
+	godotenv.Load()
+
+	var subject, secret, issuer, audience string
+	var admin bool
+	var ttl time.Duration
+	flag.StringVar(&subject, "user", "1", "-user=1 | id of the user the token is issued to")
+	flag.StringVar(&secret, "secret", os.Getenv("AUTH_JWT_SECRET"), "-secret=... | HS256 secret, defaults to AUTH_JWT_SECRET")
+	flag.StringVar(&issuer, "issuer", os.Getenv("AUTH_JWT_ISSUER"), "-issuer=... | iss claim, defaults to AUTH_JWT_ISSUER")
+	flag.StringVar(&audience, "audience", os.Getenv("AUTH_JWT_AUDIENCE"), "-audience=... | aud claim, defaults to AUTH_JWT_AUDIENCE")
+	flag.BoolVar(&admin, "admin", false, "-admin=true | grant the admin role")
+	flag.DurationVar(&ttl, "ttl", time.Hour, "-ttl=1h | token lifetime")
+	flag.Parse()
+
+	if secret == "" {
+		log.Fatalf("a secret is required, use -secret or AUTH_JWT_SECRET")
+	}
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	if audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+	if admin {
+		role := os.Getenv("AUTH_ADMIN_ROLE")
+		if role == "" {
+			role = "admin"
+		}
+		claims.Roles = []string{role}
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		log.Fatalf("unable to sign token: %v", err)
+	}
+	fmt.Println(token)
+}
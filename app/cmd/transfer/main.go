@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"app/config"
+	"app/database"
+	"app/database/sqlite"
+	"app/transfer"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: go run ./cmd/transfer [flags] <command>
+
+Commands:
+  import  upsert the users of -users, then the decisions of -decisions, and recompute the counters
+  export  write every user to -users and every decision, archived ones included, to -decisions
+
+The format of a file follows its extension, .csv or .jsonl.
+
+Flags:
+`
+
+// storage is the database the files are imported into or exported from
+type storage interface {
+	database.BulkImporter
+	database.BulkExporter
+	database.CounterReconciler
+}
+
+// transfer imports or exports the users and decisions of the database configured like the server
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var usersPath, decisionsPath, rejectedPath string
+	var batchSize int
+	flag.StringVar(&cfg.Storage, "storage", cfg.Storage, "-storage=mysql|sqlite | backend storing users and decisions")
+	flag.StringVar(&usersPath, "users", "", "-users=users.csv | file of the users, skipped when empty")
+	flag.StringVar(&decisionsPath, "decisions", "", "-decisions=decisions.jsonl | file of the decisions, skipped when empty")
+	flag.StringVar(&rejectedPath, "rejected", "rejected.jsonl", "-rejected=rejected.jsonl | file the rows left out of an import are reported to, as JSON lines")
+	flag.IntVar(&batchSize, "batch-size", 1000, fmt.Sprintf("-batch-size=1000 | rows per upsert or read, at most %d", database.MaxBulkRows))
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (flag.Arg(0) != "import" && flag.Arg(0) != "export") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if usersPath == "" && decisionsPath == "" {
+		log.Fatalf("set -users, -decisions or both")
+	}
+
+	db, store, err := open(cfg)
+	if err != nil {
+		log.Fatalf("unable to open the %s database: %v", cfg.Storage, err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if flag.Arg(0) == "import" {
+		runImport(ctx, store, usersPath, decisionsPath, rejectedPath, batchSize)
+	} else {
+		runExport(ctx, store, usersPath, decisionsPath, batchSize)
+	}
+}
+
+func runImport(ctx context.Context, store storage, usersPath string, decisionsPath string, rejectedPath string, batchSize int) {
+	var sources [2]transfer.Source
+	for i, path := range []string{usersPath, decisionsPath} {
+		if path == "" {
+			continue
+		}
+		format, err := transfer.FormatOf(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("unable to open %s: %v", path, err)
+		}
+		defer file.Close()
+		sources[i] = transfer.Source{Name: path, Reader: bufio.NewReader(file), Format: format}
+	}
+
+	rejected, err := os.Create(rejectedPath)
+	if err != nil {
+		log.Fatalf("unable to create %s: %v", rejectedPath, err)
+	}
+	defer rejected.Close()
+	report := bufio.NewWriter(rejected)
+
+	importer := transfer.Importer{Writer: store, Reconciler: store, BatchSize: batchSize, Rejected: report}
+	summary, err := importer.Import(ctx, sources[0], sources[1])
+	if flushErr := report.Flush(); flushErr != nil {
+		log.Printf("unable to write %s: %v", rejectedPath, flushErr)
+	}
+	if err != nil {
+		log.Fatalf("import failed after %d users and %d decisions, run it again to resume: %v", summary.Users, summary.Decisions, err)
+	}
+	log.Printf("Imported %d users and %d decisions, rejected %d rows, see %s", summary.Users, summary.Decisions, summary.Rejected, rejectedPath)
+	log.Printf("Recomputed the counters of %d users", summary.Repaired)
+}
+
+func runExport(ctx context.Context, store storage, usersPath string, decisionsPath string, batchSize int) {
+	var destinations [2]transfer.Destination
+	var writers []*bufio.Writer
+	for i, path := range []string{usersPath, decisionsPath} {
+		if path == "" {
+			continue
+		}
+		format, err := transfer.FormatOf(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("unable to create %s: %v", path, err)
+		}
+		defer file.Close()
+		writer := bufio.NewWriter(file)
+		writers = append(writers, writer)
+		destinations[i] = transfer.Destination{Writer: writer, Format: format}
+	}
+
+	summary, err := transfer.Exporter{Reader: store, BatchSize: batchSize}.Export(ctx, destinations[0], destinations[1])
+	if err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	for _, writer := range writers {
+		if err := writer.Flush(); err != nil {
+			log.Fatalf("unable to write the export: %v", err)
+		}
+	}
+	log.Printf("Exported %d users and %d decisions", summary.Users, summary.Decisions)
+}
+
+// open connects to the database of the selected storage
+func open(cfg config.Config) (io.Closer, storage, error) {
+	switch cfg.Storage {
+	case config.StorageMySQL:
+		if len(cfg.MySQL.Shards) > 0 {
+			return nil, nil, fmt.Errorf("MYSQL_SHARDS is set, sharded databases are not supported")
+		}
+		db, err := sql.Open("mysql", cfg.MySQL.DSN())
+		if err != nil {
+			return nil, nil, err
+		}
+		options := cfg.MySQL.QueryOptions()
+		bulk, err := database.NewDatabaseBulkWriter(db, options)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		writer, err := database.NewDatabaseWriter(db, options)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return db, mysqlStorage{DatabaseBulkWriter: bulk, DatabaseWriter: writer}, nil
+	case config.StorageSQLite:
+		db, err := sqlite.Open(cfg.SQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, sqlite.NewDatabaseWriter(db), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported storage %q", cfg.Storage)
+	}
+}
+
+// mysqlStorage reconciles the counters with the writer of the server
+type mysqlStorage struct {
+	database.DatabaseBulkWriter
+	database.DatabaseWriter
+}
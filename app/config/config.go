@@ -0,0 +1,519 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/database"
+	"app/interceptors"
+	"app/ratelimit"
+)
+
+const (
+	StorageMySQL    = "mysql"
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+const (
+	CacheMemory = "memory"
+	CacheRedis  = "redis"
+)
+
+// Config holds the server configuration read from the environment (and the .env file when present)
+type Config struct {
+	ListenPort string
+	// HTTPListenPort enables the REST/JSON gateway when set
+	HTTPListenPort string
+	// Storage selects the backend of users and decisions: mysql, postgres, sqlite or memory
+	Storage  string
+	MySQL    MySQLConfig
+	Postgres PostgresConfig
+	// SQLitePath is the database file of the sqlite storage, created and migrated on start
+	SQLitePath string
+	// MigrateOnStart applies the pending MySQL or PostgreSQL migrations before serving
+	MigrateOnStart bool
+	// MigrateLockTimeout is how long a server waits for another one migrating MySQL
+	MigrateLockTimeout time.Duration
+	Cache              CacheConfig
+	Reconcile          ReconcileConfig
+	Retention          RetentionConfig
+	Auth               AuthConfig
+	TLS                TLSConfig
+	RateLimits         ratelimit.Limits
+	Timeouts           interceptors.Timeouts
+}
+
+type MySQLConfig struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Database string
+	// Replicas are the host:port addresses of read replicas sharing the credentials and the database of the primary
+	Replicas []string
+	// ReplicaMaxLag is the replication lag above which a replica stops serving reads
+	ReplicaMaxLag        time.Duration
+	ReplicaCheckInterval time.Duration
+	// Shards are the host:port addresses of the shards after the primary, which is shard 0, sharing its credentials
+	// and its database. The decisions are sharded when there are some.
+	Shards []string
+	// ShardMap assigns the buckets of the users to the shards, e.g. 0-511:0,512-1023:1
+	ShardMap string
+	// SlowQueryThreshold is the duration above which a query is logged, 0 disables the log
+	SlowQueryThreshold time.Duration
+	// RetryAttempts is the number of runs of a query or a transaction failing with a transient error
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+type PostgresConfig struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Database string
+	SSLMode  string
+	// RetryAttempts is the number of runs of a transaction failing with a transient error
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+type CacheConfig struct {
+	// Store enables the read-through cache of users and likes pages: memory (per process LRU) or redis. Empty disables it.
+	Store string
+	TTL   time.Duration
+	// Pages is the number of likes pages cached per user
+	Pages int
+	// Size is the maximum number of entries of the memory store
+	Size int
+	// LoadTimeout bounds a database read shared by concurrent misses
+	LoadTimeout time.Duration
+	Redis       RedisConfig
+}
+
+type ReconcileConfig struct {
+	// Interval between two repairs of the user counters, 0 disables the job
+	Interval  time.Duration
+	BatchSize int
+}
+
+type RetentionConfig struct {
+	// Interval between two archivals of the decisions, 0 disables the job
+	Interval  time.Duration
+	BatchSize int
+	// Pause between two batches
+	Pause time.Duration
+	// PassAge is the age of the passes archived, 0 keeps them
+	PassAge time.Duration
+	// InactiveLikeAge is how long an inactive user was not active before their viewed likes are archived, 0 keeps them
+	InactiveLikeAge time.Duration
+}
+
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+type AuthConfig struct {
+	// Disabled turns authentication off and treats every caller as an admin. Local development only.
+	Disabled bool
+	// Algorithm is the JWT signing algorithm accepted by the server: HS256 or RS256
+	Algorithm     string
+	Secret        string
+	PublicKeyFile string
+	JWKSFile      string
+	Issuer        string
+	Audience      string
+	AdminRole     string
+}
+
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS: clients must present a certificate signed by this CA
+	ClientCAFile string
+}
+
+// Enabled reports whether the server should serve TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// DSN returns the go-sql-driver connection string for the configured MySQL database
+func (c MySQLConfig) DSN() string {
+	return c.dsn(net.JoinHostPort(c.Host, c.Port))
+}
+
+// QueryOptions returns the options of the MySQL queries
+func (c MySQLConfig) QueryOptions() database.QueryOptions {
+	return database.QueryOptions{
+		SlowQueryThreshold: c.SlowQueryThreshold,
+		Retry: database.RetryPolicy{
+			MaxAttempts: c.RetryAttempts,
+			BaseDelay:   c.RetryBaseDelay,
+			MaxDelay:    c.RetryMaxDelay,
+		},
+	}
+}
+
+// ShardDSNs returns the go-sql-driver connection strings of every shard, in the order of their index. Their
+// sessions allocate the auto-increment IDs from the index of the shard plus one in steps of the number of shards,
+// so the shards insert disjoint decision IDs, while the users keep the IDs inserted through DSN.
+func (c MySQLConfig) ShardDSNs() []string {
+	addrs := append([]string{net.JoinHostPort(c.Host, c.Port)}, c.Shards...)
+	dsns := make([]string, 0, len(addrs))
+	for index, addr := range addrs {
+		dsns = append(dsns, fmt.Sprintf("%s&auto_increment_increment=%d&auto_increment_offset=%d", c.dsn(addr), len(addrs), index+1))
+	}
+	return dsns
+}
+
+// ReplicaDSNs returns the go-sql-driver connection strings of the replicas
+func (c MySQLConfig) ReplicaDSNs() []string {
+	dsns := make([]string, 0, len(c.Replicas))
+	for _, addr := range c.Replicas {
+		dsns = append(dsns, c.dsn(addr))
+	}
+	return dsns
+}
+
+func (c MySQLConfig) dsn(addr string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?multiStatements=true",
+		c.User,
+		c.Password,
+		addr,
+		c.Database,
+	)
+}
+
+// RetryPolicy returns the retries of the PostgreSQL transactions
+func (c PostgresConfig) RetryPolicy() database.RetryPolicy {
+	return database.RetryPolicy{
+		MaxAttempts: c.RetryAttempts,
+		BaseDelay:   c.RetryBaseDelay,
+		MaxDelay:    c.RetryMaxDelay,
+	}
+}
+
+// DSN returns the pgx connection URL for the configured PostgreSQL database
+func (c PostgresConfig) DSN() string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.Database,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return dsn.String()
+}
+
+// Load reads the configuration from the environment
+func Load() (Config, error) {
+	cfg := Config{
+		ListenPort:     os.Getenv("LISTEN_PORT"),
+		HTTPListenPort: os.Getenv("HTTP_LISTEN_PORT"),
+		Storage:        getEnv("STORAGE", StorageMySQL),
+		MySQL: MySQLConfig{
+			User:     os.Getenv("MYSQL_USER"),
+			Password: os.Getenv("MYSQL_PASSWORD"),
+			Host:     os.Getenv("MYSQL_HOST"),
+			Port:     os.Getenv("MYSQL_PORT"),
+			Database: os.Getenv("MYSQL_DATABASE"),
+			Replicas: getList("MYSQL_REPLICAS"),
+			Shards:   getList("MYSQL_SHARDS"),
+			ShardMap: os.Getenv("MYSQL_SHARD_MAP"),
+		},
+		Postgres: PostgresConfig{
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: os.Getenv("POSTGRES_PASSWORD"),
+			Host:     os.Getenv("POSTGRES_HOST"),
+			Port:     getEnv("POSTGRES_PORT", "5432"),
+			Database: os.Getenv("POSTGRES_DB"),
+			SSLMode:  getEnv("POSTGRES_SSLMODE", "disable"),
+		},
+		SQLitePath: getEnv("SQLITE_PATH", "muzzapp.db"),
+		Cache: CacheConfig{
+			Store: os.Getenv("CACHE_STORE"),
+			Redis: RedisConfig{
+				Addr:     getEnv("REDIS_ADDR", "127.0.0.1:6379"),
+				Password: os.Getenv("REDIS_PASSWORD"),
+			},
+		},
+		Auth: AuthConfig{
+			Algorithm:     getEnv("AUTH_JWT_ALGORITHM", "HS256"),
+			Secret:        os.Getenv("AUTH_JWT_SECRET"),
+			PublicKeyFile: os.Getenv("AUTH_JWT_PUBLIC_KEY_FILE"),
+			JWKSFile:      os.Getenv("AUTH_JWKS_FILE"),
+			Issuer:        os.Getenv("AUTH_JWT_ISSUER"),
+			Audience:      os.Getenv("AUTH_JWT_AUDIENCE"),
+			AdminRole:     getEnv("AUTH_ADMIN_ROLE", "admin"),
+		},
+		TLS: TLSConfig{
+			CertFile:     os.Getenv("TLS_CERT_FILE"),
+			KeyFile:      os.Getenv("TLS_KEY_FILE"),
+			ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		},
+	}
+
+	var err error
+	if cfg.Auth.Disabled, err = getBool("AUTH_DISABLED", false); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.MigrateOnStart, err = getBool("MIGRATE_ON_START", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.MigrateLockTimeout, err = getDuration("MIGRATE_LOCK_TIMEOUT", time.Minute); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.RateLimits, err = getRateLimits("RATE_LIMIT_DEFAULT", "RATE_LIMITS"); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Timeouts, err = getTimeouts("RPC_TIMEOUT_DEFAULT", "RPC_TIMEOUTS", 5*time.Second); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Cache.TTL, err = getDuration("CACHE_TTL", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.Cache.Pages, err = getInt("CACHE_PAGES", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.Cache.Size, err = getInt("CACHE_SIZE", 10000); err != nil {
+		return Config{}, err
+	}
+	if cfg.Cache.LoadTimeout, err = getDuration("CACHE_LOAD_TIMEOUT", 5*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.Cache.Redis.DB, err = getInt("REDIS_DB", 0); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.MySQL.ReplicaMaxLag, err = getDuration("MYSQL_REPLICA_MAX_LAG", 5*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.MySQL.ReplicaCheckInterval, err = getDuration("MYSQL_REPLICA_CHECK_INTERVAL", 5*time.Second); err != nil {
+		return Config{}, err
+	}
+	if len(cfg.MySQL.Replicas) > 0 && cfg.MySQL.ReplicaCheckInterval == 0 {
+		return Config{}, fmt.Errorf("MYSQL_REPLICA_CHECK_INTERVAL must be positive")
+	}
+	if len(cfg.MySQL.Shards) > 0 && len(cfg.MySQL.Replicas) > 0 {
+		return Config{}, fmt.Errorf("MYSQL_REPLICAS cannot be used with MYSQL_SHARDS, the shards are read from their primary")
+	}
+	if len(cfg.MySQL.Shards) > 0 && cfg.MySQL.ShardMap == "" {
+		return Config{}, fmt.Errorf("MYSQL_SHARD_MAP is required with MYSQL_SHARDS")
+	}
+	if cfg.MySQL.SlowQueryThreshold, err = getDuration("MYSQL_SLOW_QUERY_THRESHOLD", 200*time.Millisecond); err != nil {
+		return Config{}, err
+	}
+	if cfg.MySQL.RetryAttempts, err = getInt("MYSQL_RETRY_ATTEMPTS", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.MySQL.RetryBaseDelay, err = getDuration("MYSQL_RETRY_BASE_DELAY", 20*time.Millisecond); err != nil {
+		return Config{}, err
+	}
+	if cfg.MySQL.RetryMaxDelay, err = getDuration("MYSQL_RETRY_MAX_DELAY", time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.Postgres.RetryAttempts, err = getInt("POSTGRES_RETRY_ATTEMPTS", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.Postgres.RetryBaseDelay, err = getDuration("POSTGRES_RETRY_BASE_DELAY", 20*time.Millisecond); err != nil {
+		return Config{}, err
+	}
+	if cfg.Postgres.RetryMaxDelay, err = getDuration("POSTGRES_RETRY_MAX_DELAY", time.Second); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Reconcile.Interval, err = getDuration("RECONCILE_INTERVAL", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.Reconcile.BatchSize, err = getInt("RECONCILE_BATCH_SIZE", 500); err != nil {
+		return Config{}, err
+	}
+	if cfg.Reconcile.BatchSize < 1 {
+		return Config{}, fmt.Errorf("RECONCILE_BATCH_SIZE must be positive")
+	}
+
+	if cfg.Retention.Interval, err = getDuration("RETENTION_INTERVAL", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.Retention.BatchSize, err = getInt("RETENTION_BATCH_SIZE", 500); err != nil {
+		return Config{}, err
+	}
+	if cfg.Retention.BatchSize < 1 {
+		return Config{}, fmt.Errorf("RETENTION_BATCH_SIZE must be positive")
+	}
+	if cfg.Retention.Pause, err = getDuration("RETENTION_PAUSE", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.Retention.PassAge, err = getDuration("RETENTION_PASS_AGE", 180*24*time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.Retention.InactiveLikeAge, err = getDuration("RETENTION_INACTIVE_LIKE_AGE", 0); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.TLS.ClientCAFile != "" && !cfg.TLS.Enabled() {
+		return Config{}, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	return cfg, nil
+}
+
+func getEnv(key string, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getList splits a comma separated value, ignoring empty items
+func getList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getBool(key string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getInt(key string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+	return parsed, nil
+}
+
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+	return parsed, nil
+}
+
+// getMethodMap parses values such as "PutDecision=5:10,ListLikedYou=20:40" into a map indexed by method name
+func getMethodMap(key string) (map[string]string, error) {
+	values := map[string]string{}
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return values, nil
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		method, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || strings.TrimSpace(method) == "" {
+			return nil, fmt.Errorf("invalid entry %q for %s, expected Method=value", entry, key)
+		}
+		values[strings.TrimSpace(method)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// parseLimit parses a token bucket written as "rate:burst", the rate being in calls per second
+func parseLimit(value string) (ratelimit.Limit, error) {
+	rate, burst, found := strings.Cut(value, ":")
+	if !found {
+		return ratelimit.Limit{}, fmt.Errorf("invalid rate limit %q, expected rate:burst", value)
+	}
+
+	parsedRate, err := strconv.ParseFloat(rate, 64)
+	if err != nil || parsedRate < 0 {
+		return ratelimit.Limit{}, fmt.Errorf("invalid rate in %q", value)
+	}
+	parsedBurst, err := strconv.Atoi(burst)
+	if err != nil || parsedBurst < 1 {
+		return ratelimit.Limit{}, fmt.Errorf("invalid burst in %q", value)
+	}
+
+	return ratelimit.Limit{Rate: parsedRate, Burst: parsedBurst}, nil
+}
+
+func getRateLimits(defaultKey string, methodsKey string) (ratelimit.Limits, error) {
+	limits := ratelimit.Limits{Methods: map[string]ratelimit.Limit{}}
+
+	if value := strings.TrimSpace(os.Getenv(defaultKey)); value != "" {
+		limit, err := parseLimit(value)
+		if err != nil {
+			return ratelimit.Limits{}, fmt.Errorf("invalid value for %s: %w", defaultKey, err)
+		}
+		limits.Default = &limit
+	}
+
+	methods, err := getMethodMap(methodsKey)
+	if err != nil {
+		return ratelimit.Limits{}, err
+	}
+	for method, value := range methods {
+		limit, err := parseLimit(value)
+		if err != nil {
+			return ratelimit.Limits{}, fmt.Errorf("invalid value for %s: %w", methodsKey, err)
+		}
+		limits.Methods[method] = limit
+	}
+
+	return limits, nil
+}
+
+func getTimeouts(defaultKey string, methodsKey string, fallback time.Duration) (interceptors.Timeouts, error) {
+	timeouts := interceptors.Timeouts{Default: fallback, Methods: map[string]time.Duration{}}
+
+	if value := strings.TrimSpace(os.Getenv(defaultKey)); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return interceptors.Timeouts{}, fmt.Errorf("invalid value for %s: %q", defaultKey, value)
+		}
+		timeouts.Default = parsed
+	}
+
+	methods, err := getMethodMap(methodsKey)
+	if err != nil {
+		return interceptors.Timeouts{}, err
+	}
+	for method, value := range methods {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return interceptors.Timeouts{}, fmt.Errorf("invalid value for %s: %q", methodsKey, value)
+		}
+		timeouts.Methods[method] = parsed
+	}
+
+	return timeouts, nil
+}
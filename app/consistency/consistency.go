@@ -0,0 +1,455 @@
+// Package consistency finds, and optionally repairs, rows of users and decisions breaking the invariants the
+// writers maintain, e.g. after a manual data fix, a legacy import or a write that bypassed the database package.
+// The queries are shared by MySQL and SQLite.
+package consistency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"app/database"
+)
+
+// Check names an invariant verified by the Checker
+type Check string
+
+const (
+	// CheckCounters compares users.likes, new_likes and matches with the decisions, archived or not, repaired by
+	// recomputing them
+	CheckCounters Check = "counters"
+	// CheckSelfDecisions finds users deciding on themselves, repaired by deleting the decision
+	CheckSelfDecisions Check = "self_decisions"
+	// CheckMissingUsers finds decisions whose actor or recipient does not exist, repaired by deleting the decision
+	CheckMissingUsers Check = "missing_users"
+	// CheckInactiveUsers finds decisions whose actor or recipient is inactive. They are only reported as the
+	// user may be reactivated.
+	CheckInactiveUsers Check = "inactive_users"
+	// CheckNewPasses finds passes flagged as new, repaired by clearing is_new
+	CheckNewPasses Check = "new_passes"
+)
+
+// Checks lists every check in the order they are reported
+var Checks = []Check{CheckCounters, CheckSelfDecisions, CheckMissingUsers, CheckInactiveUsers, CheckNewPasses}
+
+// Phase is the table being scanned. Decisions are scanned first so the counters are checked after the
+// decisions they count were repaired.
+type Phase string
+
+const (
+	PhaseDecisions Phase = "decisions"
+	PhaseUsers     Phase = "users"
+	PhaseDone      Phase = "done"
+)
+
+// Cursor is the position of the scan: every row of Phase with an ID up to AfterId was checked
+type Cursor struct {
+	Phase   Phase `json:"phase"`
+	AfterId uint  `json:"after_id"`
+}
+
+type Counters struct {
+	Likes    uint `json:"likes"`
+	NewLikes uint `json:"new_likes"`
+	Matches  uint `json:"matches"`
+}
+
+// Issue is a row breaking a check. Decision issues carry the decision, counter issues the user.
+type Issue struct {
+	Check       Check     `json:"check"`
+	DecisionId  uint      `json:"decision_id,omitempty"`
+	ActorId     uint      `json:"actor_id,omitempty"`
+	RecipientId uint      `json:"recipient_id,omitempty"`
+	UserId      uint      `json:"user_id,omitempty"`
+	Stored      *Counters `json:"stored,omitempty"`
+	Actual      *Counters `json:"actual,omitempty"`
+	Repaired    bool      `json:"repaired"`
+}
+
+type Summary struct {
+	Found    int `json:"found"`
+	Repaired int `json:"repaired"`
+}
+
+// Report is the outcome of a run. It is also the state of an interrupted run: running the Checker again
+// with the same report resumes the scan from Cursor.
+type Report struct {
+	DryRun     bool              `json:"dry_run"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Cursor     Cursor            `json:"cursor"`
+	Scanned    map[Phase]int     `json:"scanned"`
+	Checks     map[Check]Summary `json:"checks"`
+	// Issues holds the first issues found, up to the MaxIssues of the Checker
+	Issues []Issue `json:"issues"`
+}
+
+// Checker scans users and decisions in batches of BatchSize rows. Nothing is written when DryRun is set.
+type Checker struct {
+	DB        *sql.DB
+	BatchSize int
+	DryRun    bool
+	// Checks restricts the checks run, every check is run when empty
+	Checks    []Check
+	MaxIssues int
+}
+
+const scanDecisionsQuery = `
+SELECT
+	d.id,
+	COALESCE(d.actor_id, 0),
+	COALESCE(d.recipient_id, 0),
+	COALESCE(d.liked, 0),
+	d.is_new,
+	a.id IS NULL,
+	COALESCE(a.is_active, 0),
+	r.id IS NULL,
+	COALESCE(r.is_active, 0)
+FROM decisions d
+LEFT JOIN users a ON a.id = d.actor_id
+LEFT JOIN users r ON r.id = d.recipient_id
+WHERE d.id > ?
+ORDER BY d.id
+LIMIT ?;
+`
+
+const deleteDecisionQuery = `
+DELETE FROM decisions
+WHERE id = ?;
+`
+
+const clearNewPassQuery = `
+UPDATE decisions
+SET is_new = 0
+WHERE id = ?
+AND liked = 0
+AND is_new = 1;
+`
+
+// scanUsersQuery reads the stored and the actual counters in one statement so they come from the same snapshot.
+// The actual counters are those the writers reconcile the users with.
+const scanUsersQuery = `
+SELECT
+	u.id,
+	u.likes,
+	u.new_likes,
+	u.matches,
+	` + database.LikesOfUser + `,
+	` + database.NewLikesOfUser + `,
+	` + database.MatchesOfUser + `
+FROM users u
+WHERE u.id > ?
+ORDER BY u.id
+LIMIT ?;
+`
+
+// repairCountersQuery only applies when the counters were not updated since they were read, a concurrent
+// decision leaves the user to the next run
+const repairCountersQuery = `
+UPDATE users
+SET likes = ?,
+	new_likes = ?,
+	matches = ?
+WHERE id = ?
+AND likes = ?
+AND new_likes = ?
+AND matches = ?;
+`
+
+// NewReport starts the report of a run from the first row
+func NewReport(dryRun bool) *Report {
+	return &Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Cursor:    Cursor{Phase: PhaseDecisions},
+		Scanned:   map[Phase]int{},
+		Checks:    map[Check]Summary{},
+		Issues:    []Issue{},
+	}
+}
+
+// Run scans from report.Cursor until every row was checked, recording the issues in report.
+// checkpoint, when not nil, is called with the report after every batch so an interrupted run can be resumed.
+func (c Checker) Run(ctx context.Context, report *Report, checkpoint func(report *Report) error) error {
+	if report.DryRun != c.DryRun {
+		return fmt.Errorf("the report was recorded with dry-run=%t", report.DryRun)
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("invalid batch size %d", c.BatchSize)
+	}
+	for _, check := range c.Checks {
+		if !isCheck(check) {
+			return fmt.Errorf("unknown check %q", check)
+		}
+	}
+
+	for report.Cursor.Phase != PhaseDone {
+		var lastId uint
+		var scanned int
+		var err error
+		switch report.Cursor.Phase {
+		case PhaseDecisions:
+			lastId, scanned, err = c.checkDecisions(ctx, report)
+		case PhaseUsers:
+			lastId, scanned, err = c.checkUsers(ctx, report)
+		default:
+			return fmt.Errorf("unknown phase %q", report.Cursor.Phase)
+		}
+		if err != nil {
+			return err
+		}
+
+		report.Scanned[report.Cursor.Phase] += scanned
+		if lastId == 0 {
+			report.Cursor = nextPhase(report.Cursor.Phase)
+		} else {
+			report.Cursor.AfterId = lastId
+		}
+		if report.Cursor.Phase == PhaseDone {
+			finishedAt := time.Now()
+			report.FinishedAt = &finishedAt
+		}
+
+		if checkpoint != nil {
+			if err := checkpoint(report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isCheck(check Check) bool {
+	for _, known := range Checks {
+		if check == known {
+			return true
+		}
+	}
+	return false
+}
+
+func nextPhase(phase Phase) Cursor {
+	if phase == PhaseDecisions {
+		return Cursor{Phase: PhaseUsers}
+	}
+	return Cursor{Phase: PhaseDone}
+}
+
+func (c Checker) enabled(check Check) bool {
+	if len(c.Checks) == 0 {
+		return true
+	}
+	for _, enabled := range c.Checks {
+		if check == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts an issue and keeps it in the report while there is room
+func (c Checker) record(report *Report, issue Issue) {
+	summary := report.Checks[issue.Check]
+	summary.Found++
+	if issue.Repaired {
+		summary.Repaired++
+	}
+	report.Checks[issue.Check] = summary
+
+	if len(report.Issues) < c.MaxIssues {
+		report.Issues = append(report.Issues, issue)
+	}
+}
+
+type decisionRow struct {
+	id               uint
+	actorId          uint
+	recipientId      uint
+	liked            bool
+	isNew            bool
+	actorMissing     bool
+	actorActive      bool
+	recipientMissing bool
+	recipientActive  bool
+}
+
+// checkDecisions checks the batch of decisions after the cursor, returning the ID of the last one or 0 when none is left
+func (c Checker) checkDecisions(ctx context.Context, report *Report) (uint, int, error) {
+	rows, err := c.DB.QueryContext(ctx, scanDecisionsQuery, report.Cursor.AfterId, c.BatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to scan decisions: %w", err)
+	}
+	var batch []decisionRow
+	for rows.Next() {
+		var row decisionRow
+		if err := rows.Scan(
+			&row.id,
+			&row.actorId,
+			&row.recipientId,
+			&row.liked,
+			&row.isNew,
+			&row.actorMissing,
+			&row.actorActive,
+			&row.recipientMissing,
+			&row.recipientActive,
+		); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("unable to scan decisions: %w", err)
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("unable to scan decisions: %w", err)
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	for _, row := range batch {
+		var found []Check
+		if c.enabled(CheckSelfDecisions) && row.actorId != 0 && row.actorId == row.recipientId {
+			found = append(found, CheckSelfDecisions)
+		}
+		if c.enabled(CheckMissingUsers) && (row.actorMissing || row.recipientMissing) {
+			found = append(found, CheckMissingUsers)
+		}
+		if c.enabled(CheckInactiveUsers) && ((!row.actorMissing && !row.actorActive) || (!row.recipientMissing && !row.recipientActive)) {
+			found = append(found, CheckInactiveUsers)
+		}
+		if c.enabled(CheckNewPasses) && !row.liked && row.isNew {
+			found = append(found, CheckNewPasses)
+		}
+		if len(found) == 0 {
+			continue
+		}
+
+		repaired, err := c.repairDecision(ctx, row, found)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, check := range found {
+			c.record(report, Issue{
+				Check:       check,
+				DecisionId:  row.id,
+				ActorId:     row.actorId,
+				RecipientId: row.recipientId,
+				Repaired:    repaired && check != CheckInactiveUsers,
+			})
+		}
+	}
+
+	return batch[len(batch)-1].id, len(batch), nil
+}
+
+// repairDecision deletes the decision when it should not exist, or clears the is_new flag of a pass,
+// reporting whether the row was changed
+func (c Checker) repairDecision(ctx context.Context, row decisionRow, found []Check) (bool, error) {
+	if c.DryRun {
+		return false, nil
+	}
+
+	query := ""
+	for _, check := range found {
+		switch check {
+		case CheckSelfDecisions, CheckMissingUsers:
+			query = deleteDecisionQuery
+		case CheckNewPasses:
+			if query == "" {
+				query = clearNewPassQuery
+			}
+		}
+	}
+	if query == "" {
+		return false, nil
+	}
+
+	result, err := c.DB.ExecContext(ctx, query, row.id)
+	if err != nil {
+		return false, fmt.Errorf("unable to repair decision %d: %w", row.id, err)
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to repair decision %d: %w", row.id, err)
+	}
+	return changed > 0, nil
+}
+
+// checkUsers checks the counters of the batch of users after the cursor, returning the ID of the last one or 0 when none is left
+func (c Checker) checkUsers(ctx context.Context, report *Report) (uint, int, error) {
+	if !c.enabled(CheckCounters) {
+		return 0, 0, nil
+	}
+
+	type userRow struct {
+		id             uint
+		stored, actual Counters
+	}
+	rows, err := c.DB.QueryContext(ctx, scanUsersQuery, report.Cursor.AfterId, c.BatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to scan users: %w", err)
+	}
+	var batch []userRow
+	for rows.Next() {
+		var row userRow
+		if err := rows.Scan(
+			&row.id,
+			&row.stored.Likes,
+			&row.stored.NewLikes,
+			&row.stored.Matches,
+			&row.actual.Likes,
+			&row.actual.NewLikes,
+			&row.actual.Matches,
+		); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("unable to scan users: %w", err)
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("unable to scan users: %w", err)
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil
+	}
+
+	for _, row := range batch {
+		if row.stored == row.actual {
+			continue
+		}
+
+		repaired := false
+		if !c.DryRun {
+			result, err := c.DB.ExecContext(ctx, repairCountersQuery,
+				row.actual.Likes,
+				row.actual.NewLikes,
+				row.actual.Matches,
+				row.id,
+				row.stored.Likes,
+				row.stored.NewLikes,
+				row.stored.Matches,
+			)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unable to repair the counters of user %d: %w", row.id, err)
+			}
+			changed, err := result.RowsAffected()
+			if err != nil {
+				return 0, 0, fmt.Errorf("unable to repair the counters of user %d: %w", row.id, err)
+			}
+			repaired = changed > 0
+		}
+
+		stored, actual := row.stored, row.actual
+		c.record(report, Issue{
+			Check:    CheckCounters,
+			UserId:   row.id,
+			Stored:   &stored,
+			Actual:   &actual,
+			Repaired: repaired,
+		})
+	}
+
+	return batch[len(batch)-1].id, len(batch), nil
+}
@@ -0,0 +1,206 @@
+package consistency_test
+
+import (
+	"app/consistency"
+	"app/database"
+	"app/database/sqlite"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+// newInconsistentDB creates users 1 to 4, user 4 being inactive, and a decision breaking each check
+func newInconsistentDB(t *testing.T) *sql.DB {
+	ctx := context.Background()
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "muzzapp.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	users := []database.UserModel{
+		{Name: "user 1", Gender: "f", IsAactive: true, Likes: 5},
+		{Name: "user 2", Gender: "m", IsAactive: true},
+		{Name: "user 3", Gender: "f", IsAactive: true},
+		{Name: "user 4", Gender: "m"},
+	}
+	for _, user := range users {
+		_, err := sqlite.AddUser(ctx, db, user)
+		assert.NoError(t, err)
+	}
+
+	decisions := []struct {
+		actorId, recipientId string
+		liked, isNew         bool
+	}{
+		{"2", "1", true, true},  // 1: consistent
+		{"1", "1", true, true},  // 2: self-decision
+		{"3", "1", false, true}, // 3: new pass
+		{"4", "2", true, true},  // 4: inactive actor
+	}
+	for _, decision := range decisions {
+		_, err := sqlite.AddDecision(ctx, db, decision.actorId, decision.recipientId, decision.liked, decision.isNew)
+		assert.NoError(t, err)
+	}
+
+	// 5: missing recipient, only possible when the foreign keys were not enforced
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF")
+	assert.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "INSERT INTO decisions (actor_id, recipient_id, liked, is_new) VALUES (2, 99, 1, 1)")
+	assert.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	assert.NoError(t, err)
+
+	return db
+}
+
+func countersOf(t *testing.T, db *sql.DB, id int) consistency.Counters {
+	var counters consistency.Counters
+	err := db.QueryRow("SELECT likes, new_likes, matches FROM users WHERE id = ?", id).
+		Scan(&counters.Likes, &counters.NewLikes, &counters.Matches)
+	assert.NoError(t, err)
+	return counters
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		dryRun   bool
+		checks   []consistency.Check
+		expected map[consistency.Check]consistency.Summary
+	}{
+		{
+			name:   "dry_run",
+			dryRun: true,
+			expected: map[consistency.Check]consistency.Summary{
+				// user 1 stores 5 likes for its self-like and the like of user 2, user 2 misses the like of user 4
+				consistency.CheckCounters:      {Found: 2},
+				consistency.CheckSelfDecisions: {Found: 1},
+				consistency.CheckMissingUsers:  {Found: 1},
+				consistency.CheckInactiveUsers: {Found: 1},
+				consistency.CheckNewPasses:     {Found: 1},
+			},
+		},
+		{
+			name: "repair",
+			expected: map[consistency.Check]consistency.Summary{
+				consistency.CheckCounters:      {Found: 2, Repaired: 2},
+				consistency.CheckSelfDecisions: {Found: 1, Repaired: 1},
+				consistency.CheckMissingUsers:  {Found: 1, Repaired: 1},
+				consistency.CheckInactiveUsers: {Found: 1},
+				consistency.CheckNewPasses:     {Found: 1, Repaired: 1},
+			},
+		},
+		{
+			name:   "selected_checks",
+			checks: []consistency.Check{consistency.CheckNewPasses},
+			expected: map[consistency.Check]consistency.Summary{
+				consistency.CheckNewPasses: {Found: 1, Repaired: 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newInconsistentDB(t)
+			checker := consistency.Checker{DB: db, BatchSize: 2, DryRun: test.dryRun, Checks: test.checks, MaxIssues: 100}
+
+			report := consistency.NewReport(test.dryRun)
+			assert.NoError(t, checker.Run(context.Background(), report, nil))
+			assert.Equal(t, test.expected, report.Checks)
+			assert.Equal(t, consistency.Cursor{Phase: consistency.PhaseDone}, report.Cursor)
+			assert.NotNil(t, report.FinishedAt)
+			assert.Equal(t, 5, report.Scanned[consistency.PhaseDecisions])
+
+			// a second run finds what was left
+			again := consistency.NewReport(test.dryRun)
+			assert.NoError(t, checker.Run(context.Background(), again, nil))
+			for check, summary := range test.expected {
+				if summary.Repaired == 0 {
+					assert.Equal(t, summary.Found, again.Checks[check].Found)
+				} else {
+					assert.Equal(t, 0, again.Checks[check].Found)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRepairsCounters(t *testing.T) {
+	db := newInconsistentDB(t)
+	checker := consistency.Checker{DB: db, BatchSize: 10, MaxIssues: 100}
+
+	assert.NoError(t, checker.Run(context.Background(), consistency.NewReport(false), nil))
+
+	// the self-like and the decision on the missing user are deleted before the counters are recomputed
+	assert.Equal(t, consistency.Counters{Likes: 1, NewLikes: 1}, countersOf(t, db, 1))
+	assert.Equal(t, consistency.Counters{Likes: 1, NewLikes: 1}, countersOf(t, db, 2))
+
+	var decisions int
+	assert.NoError(t, db.QueryRow("SELECT count(*) FROM decisions").Scan(&decisions))
+	assert.Equal(t, 3, decisions)
+}
+
+func TestRunResumesFromTheCursor(t *testing.T) {
+	db := newInconsistentDB(t)
+	checker := consistency.Checker{DB: db, BatchSize: 2, DryRun: true, MaxIssues: 100}
+	interrupted := errors.New("interrupted")
+
+	// the state is saved as JSON after every batch, the run is interrupted after the second one
+	var state []byte
+	batches := 0
+	report := consistency.NewReport(true)
+	err := checker.Run(context.Background(), report, func(report *consistency.Report) error {
+		var err error
+		state, err = json.Marshal(report)
+		assert.NoError(t, err)
+		batches++
+		if batches == 2 {
+			return interrupted
+		}
+		return nil
+	})
+	assert.Equal(t, interrupted, err)
+
+	var resumed consistency.Report
+	assert.NoError(t, json.Unmarshal(state, &resumed))
+	assert.Equal(t, consistency.Cursor{Phase: consistency.PhaseDecisions, AfterId: 4}, resumed.Cursor)
+	assert.Nil(t, resumed.FinishedAt)
+
+	assert.NoError(t, checker.Run(context.Background(), &resumed, nil))
+	complete := consistency.NewReport(true)
+	assert.NoError(t, checker.Run(context.Background(), complete, nil))
+	assert.Equal(t, complete.Checks, resumed.Checks)
+	assert.Equal(t, complete.Scanned, resumed.Scanned)
+	assert.Equal(t, complete.Issues, resumed.Issues)
+}
+
+func TestRunKeepsAtMostMaxIssues(t *testing.T) {
+	db := newInconsistentDB(t)
+	checker := consistency.Checker{DB: db, BatchSize: 10, DryRun: true, MaxIssues: 2}
+
+	report := consistency.NewReport(true)
+	assert.NoError(t, checker.Run(context.Background(), report, nil))
+	assert.Equal(t, 2, len(report.Issues))
+	assert.Equal(t, consistency.Issue{Check: consistency.CheckSelfDecisions, DecisionId: 2, ActorId: 1, RecipientId: 1}, report.Issues[0])
+	assert.Equal(t, 6, report.Checks[consistency.CheckSelfDecisions].Found+report.Checks[consistency.CheckNewPasses].Found+
+		report.Checks[consistency.CheckInactiveUsers].Found+report.Checks[consistency.CheckMissingUsers].Found+
+		report.Checks[consistency.CheckCounters].Found)
+}
+
+func TestRunRejectsInvalidOptions(t *testing.T) {
+	db := newInconsistentDB(t)
+
+	err := consistency.Checker{DB: db, BatchSize: 0}.Run(context.Background(), consistency.NewReport(false), nil)
+	assert.Error(t, err)
+	err = consistency.Checker{DB: db, BatchSize: 1, Checks: []consistency.Check{"unknown"}}.Run(context.Background(), consistency.NewReport(false), nil)
+	assert.Error(t, err)
+	// a dry run cannot be resumed as a repair
+	err = consistency.Checker{DB: db, BatchSize: 1}.Run(context.Background(), consistency.NewReport(true), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DecisionArchiver moves the decisions the likes RPCs no longer need from decisions to decisions_archive, keeping
+// their ID. The readers still count and list the archived likes and report the matches they make, and a new
+// decision between two users moves their archived decisions back first.
+type DecisionArchiver interface {
+	// ArchiveDecisions archives the decisions selected by policy among up to limit decisions with an ID greater than
+	// afterId. It returns the last decision ID of the batch, 0 once there are no decisions left, and the number of
+	// decisions archived.
+	ArchiveDecisions(ctx context.Context, policy ArchivePolicy, afterId uint, limit int) (lastId uint, archived int, err error)
+}
+
+// ArchivePolicy selects the decisions to archive by unix timestamps, a rule with a zero timestamp archives nothing
+type ArchivePolicy struct {
+	// PassesBefore archives the passes last updated before it
+	PassesBefore uint64
+	// InactiveLikesBefore archives the viewed likes of the inactive users last active before it, or created before
+	// it when their last activity is unknown. New likes are kept until their recipient views them.
+	InactiveLikesBefore uint64
+}
+
+const readDecisionsBatchQuery = `
+SELECT id
+FROM decisions
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
+`
+
+// lockArchivedUsersQuery locks the users of the decisions of a batch in ID order, like lockUsersQuery, so no
+// decision between two of them is written or restored while the batch is moved
+const lockArchivedUsersQuery = `
+SELECT id
+FROM users
+WHERE id IN (
+	SELECT actor_id
+	FROM decisions
+	WHERE id > ?
+	AND id <= ?
+	UNION
+	SELECT recipient_id
+	FROM decisions
+	WHERE id > ?
+	AND id <= ?
+)
+ORDER BY id
+FOR UPDATE;
+`
+
+// archiveDecisionsQuery copies the decisions of a batch selected by the policy. A pair of users already archived is
+// left in decisions, which is read first.
+const archiveDecisionsQuery = `
+INSERT IGNORE INTO decisions_archive (
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+)
+SELECT
+	d.id,
+	d.actor_id,
+	d.recipient_id,
+	d.liked,
+	d.is_new,
+	d.created_at,
+	d.updated_at
+FROM decisions d
+JOIN users a ON a.id = d.actor_id
+WHERE d.id > ?
+AND d.id <= ?
+AND (
+	(d.liked = 0 AND d.updated_at < FROM_UNIXTIME(?))
+	OR (d.liked = 1
+		AND d.is_new = 0
+		AND a.is_active = 0
+		AND COALESCE(a.last_active_at, a.created_at) < FROM_UNIXTIME(?))
+);
+`
+
+// deleteArchivedDecisionsQuery deletes the decisions of a batch copied to the archive
+const deleteArchivedDecisionsQuery = `
+DELETE d
+FROM decisions d
+JOIN decisions_archive a ON a.id = d.id
+WHERE d.id > ?
+AND d.id <= ?;
+`
+
+// ArchiveDecisions moves a batch of decisions to decisions_archive in one transaction, holding the locks of their
+// users so a concurrent decision cannot leave a pair in both tables
+func (w DatabaseWriter) ArchiveDecisions(ctx context.Context, policy ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	var lastId uint
+	var archived int64
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		lastId = 0
+		rows, err := w.statements.query(ctx, tx, readDecisionsBatchQuery, afterId, limit)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := rows.Scan(&lastId); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || lastId == 0 {
+			return err
+		}
+
+		locked, err := w.statements.query(ctx, tx, lockArchivedUsersQuery, afterId, lastId, afterId, lastId)
+		if err != nil {
+			return err
+		}
+		for locked.Next() {
+		}
+		locked.Close()
+		if err := locked.Err(); err != nil {
+			return err
+		}
+
+		if _, err := w.statements.exec(ctx, tx, archiveDecisionsQuery,
+			afterId,
+			lastId,
+			policy.PassesBefore,
+			policy.InactiveLikesBefore,
+		); err != nil {
+			return err
+		}
+		result, err := w.statements.exec(ctx, tx, deleteArchivedDecisionsQuery, afterId, lastId)
+		if err != nil {
+			return err
+		}
+		archived, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to archive decisions: %w", err)
+	}
+
+	return lastId, int(archived), nil
+}
@@ -0,0 +1,450 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// BulkWriter loads users and decisions in batches, to seed or import data. Users are inserted with their ID and
+// counters as given, and decisions do not update the counters, so they must be reconciled once the load is done.
+type BulkWriter interface {
+	// LastUserId returns the highest user ID, 0 when there are no users
+	LastUserId(ctx context.Context) (uint, error)
+	// InsertUsers inserts the users in a single transaction
+	InsertUsers(ctx context.Context, users []UserModel) error
+	// InsertDecisions inserts the decisions in a single transaction, with their is_new flag as given
+	InsertDecisions(ctx context.Context, decisions []BulkDecision) error
+}
+
+// BulkImporter loads users and decisions exported from another database, updating the rows already there. Like the
+// BulkWriter, it leaves the counters to be reconciled once the import is done.
+type BulkImporter interface {
+	// UpsertUsers inserts the users with their ID, or updates the name, gender, activity and profile of the existing
+	// ones, in a single transaction
+	UpsertUsers(ctx context.Context, users []UserModel) error
+	// UpsertDecisions inserts the decisions, or updates the decision of their pair of users, in a single transaction.
+	// The archived decision of a pair is replaced.
+	UpsertDecisions(ctx context.Context, decisions []BulkDecision) error
+	// FindUserIds returns the IDs of the existing users among at most MaxBulkRows IDs, inactive ones included
+	FindUserIds(ctx context.Context, ids []uint) ([]uint, error)
+}
+
+// BulkExporter reads every user and decision in ID order, to export them
+type BulkExporter interface {
+	// ExportUsers reads up to limit users with an ID greater than afterId, inactive ones included
+	ExportUsers(ctx context.Context, afterId uint, limit int) ([]UserModel, error)
+	// ExportDecisions reads up to limit decisions with an ID greater than afterId, archived ones included
+	ExportDecisions(ctx context.Context, afterId uint, limit int) ([]BulkDecision, error)
+}
+
+// BulkDecision is a decision written by a BulkWriter or a BulkImporter
+type BulkDecision struct {
+	// Id is set by ExportDecisions, the writers allocate it
+	Id          uint
+	ActorId     uint
+	RecipientId uint
+	Liked       bool
+	IsNew       bool
+	// CreatedAt and UpdatedAt are unix timestamps, the time of the write when 0
+	CreatedAt uint64
+	UpdatedAt uint64
+}
+
+// TimestampArgs returns the arguments of the created_at and updated_at columns, nil when they are 0
+func (d BulkDecision) TimestampArgs() []any {
+	args := []any{nil, nil}
+	if d.CreatedAt > 0 {
+		args[0] = d.CreatedAt
+	}
+	if d.UpdatedAt > 0 {
+		args[1] = d.UpdatedAt
+	}
+	return args
+}
+
+// MaxBulkRows bounds the rows of a bulk insert, so the 13 placeholders of a user row stay under the limit of a MySQL
+// (65535) and of a SQLite (32766) statement
+const MaxBulkRows = 2500
+
+// DatabaseBulkWriter runs the bulk inserts of MySQL, one multi-row INSERT per batch
+type DatabaseBulkWriter struct {
+	db         *sql.DB
+	statements *statements
+	retry      RetryPolicy
+}
+
+func NewDatabaseBulkWriter(db *sql.DB, options QueryOptions) (DatabaseBulkWriter, error) {
+	statements, err := prepareStatements(context.Background(), db, options, lastUserIdQuery)
+	if err != nil {
+		return DatabaseBulkWriter{}, err
+	}
+	return DatabaseBulkWriter{db: db, statements: statements, retry: options.Retry}, nil
+}
+
+const lastUserIdQuery = `
+SELECT id
+FROM users
+ORDER BY id DESC
+LIMIT 1;
+`
+
+const insertUsersQuery = `
+INSERT INTO users (
+	id,
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	is_active,
+	birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	last_active_at
+) VALUES %s;
+`
+
+// upsertUsersQuery leaves the counters of the existing users to the reconciliation
+const upsertUsersQuery = `
+INSERT INTO users (
+	id,
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	is_active,
+	birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	last_active_at
+) VALUES %s
+ON DUPLICATE KEY UPDATE
+	name = VALUES(name),
+	gender = VALUES(gender),
+	is_active = VALUES(is_active),
+	birth_date = VALUES(birth_date),
+	latitude = VALUES(latitude),
+	longitude = VALUES(longitude),
+	bio = VALUES(bio),
+	photo_count = VALUES(photo_count),
+	last_active_at = VALUES(last_active_at);
+`
+
+const insertDecisionsQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+) VALUES %s;
+`
+
+// upsertDecisionsQuery keeps the ID of the existing decisions
+const upsertDecisionsQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+) VALUES %s
+ON DUPLICATE KEY UPDATE
+	liked = VALUES(liked),
+	is_new = VALUES(is_new),
+	created_at = VALUES(created_at),
+	updated_at = VALUES(updated_at);
+`
+
+// deleteArchivedPairsQuery deletes the archived decisions of the pairs of users of %s, the PairConditions of a batch
+const deleteArchivedPairsQuery = `
+DELETE FROM decisions_archive
+WHERE %s;
+`
+
+// PairConditions returns the conditions matching the decisions of pairs pairs of actor and recipient placeholders
+func PairConditions(pairs int) string {
+	return strings.TrimSuffix(strings.Repeat("(actor_id = ? AND recipient_id = ?)\nOR ", pairs), "\nOR ")
+}
+
+// readUserIdsQuery reads the existing users of %s, a list of placeholders
+const readUserIdsQuery = `
+SELECT id
+FROM users
+WHERE id IN (%s);
+`
+
+const exportUsersQuery = `
+SELECT` + userColumns + `
+FROM users
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
+`
+
+// exportDecisionsQuery merges the decisions and the archive, each read up to the end of the batch
+const exportDecisionsQuery = `
+SELECT *
+FROM (
+	(SELECT
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		UNIX_TIMESTAMP(created_at) as created_at,
+		UNIX_TIMESTAMP(updated_at) as updated_at
+	FROM decisions
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?)
+	UNION ALL
+	(SELECT
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		COALESCE(UNIX_TIMESTAMP(created_at), 0) as created_at,
+		COALESCE(UNIX_TIMESTAMP(updated_at), 0) as updated_at
+	FROM decisions_archive
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?)
+) decisions
+ORDER BY id
+LIMIT ?;
+`
+
+// insertUsersRow converts the unix timestamp of last_active_at
+const insertUsersRow = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))"
+
+// insertDecisionsRow converts the unix timestamps of created_at and updated_at, the current time when NULL
+const insertDecisionsRow = "(?, ?, ?, ?, COALESCE(FROM_UNIXTIME(?), CURRENT_TIMESTAMP), COALESCE(FROM_UNIXTIME(?), CURRENT_TIMESTAMP))"
+
+// BulkValues returns the VALUES list of a multi-row INSERT of rows rows of columns placeholders
+func BulkValues(rows int, columns int) string {
+	return BulkRows(rows, "("+strings.TrimSuffix(strings.Repeat("?, ", columns), ", ")+")")
+}
+
+// BulkRows returns the VALUES list of a multi-row INSERT repeating row rows times
+func BulkRows(rows int, row string) string {
+	return strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ")
+}
+
+func (w DatabaseBulkWriter) LastUserId(ctx context.Context) (uint, error) {
+	var id uint
+	err := w.retry.Do(ctx, func() error {
+		return w.statements.queryRow(ctx, nil, lastUserIdQuery, nil, &id)
+	})
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the last user id: %w", err)
+	}
+	return id, nil
+}
+
+func (w DatabaseBulkWriter) InsertUsers(ctx context.Context, users []UserModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+	if len(users) > MaxBulkRows {
+		return fmt.Errorf("unable to insert users: %d rows exceed the maximum of %d", len(users), MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(users)*13)
+	for _, user := range users {
+		args = append(args, user.Id, user.Name, user.Likes, user.NewLikes, user.Matches, user.Gender, user.IsAactive)
+		args = append(args, user.ProfileArgs()...)
+	}
+	// a batch size is prepared once, and only the last batch of a load differs
+	query := fmt.Sprintf(insertUsersQuery, BulkRows(len(users), insertUsersRow))
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		return w.statements.execAffecting(ctx, tx, int64(len(users)), query, args...)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert users: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseBulkWriter) InsertDecisions(ctx context.Context, decisions []BulkDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	if len(decisions) > MaxBulkRows {
+		return fmt.Errorf("unable to insert decisions: %d rows exceed the maximum of %d", len(decisions), MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(decisions)*6)
+	for _, decision := range decisions {
+		args = append(args, decision.ActorId, decision.RecipientId, decision.Liked, decision.IsNew)
+		args = append(args, decision.TimestampArgs()...)
+	}
+	query := fmt.Sprintf(insertDecisionsQuery, BulkRows(len(decisions), insertDecisionsRow))
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		return w.statements.execAffecting(ctx, tx, int64(len(decisions)), query, args...)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert decisions: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseBulkWriter) UpsertUsers(ctx context.Context, users []UserModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+	if len(users) > MaxBulkRows {
+		return fmt.Errorf("unable to upsert users: %d rows exceed the maximum of %d", len(users), MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(users)*13)
+	for _, user := range users {
+		args = append(args, user.Id, user.Name, 0, 0, 0, user.Gender, user.IsAactive)
+		args = append(args, user.ProfileArgs()...)
+	}
+	query := fmt.Sprintf(upsertUsersQuery, BulkRows(len(users), insertUsersRow))
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		_, err := w.statements.exec(ctx, tx, query, args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upsert users: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseBulkWriter) UpsertDecisions(ctx context.Context, decisions []BulkDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	if len(decisions) > MaxBulkRows {
+		return fmt.Errorf("unable to upsert decisions: %d rows exceed the maximum of %d", len(decisions), MaxBulkRows)
+	}
+
+	pairs := make([]any, 0, len(decisions)*2)
+	args := make([]any, 0, len(decisions)*6)
+	for _, decision := range decisions {
+		pairs = append(pairs, decision.ActorId, decision.RecipientId)
+		args = append(args, decision.ActorId, decision.RecipientId, decision.Liked, decision.IsNew)
+		args = append(args, decision.TimestampArgs()...)
+	}
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		if _, err := w.statements.exec(ctx, tx, fmt.Sprintf(deleteArchivedPairsQuery, PairConditions(len(decisions))), pairs...); err != nil {
+			return err
+		}
+		_, err := w.statements.exec(ctx, tx, fmt.Sprintf(upsertDecisionsQuery, BulkRows(len(decisions), insertDecisionsRow)), args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upsert decisions: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseBulkWriter) FindUserIds(ctx context.Context, ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > MaxBulkRows {
+		return nil, fmt.Errorf("unable to read user ids: %d ids exceed the maximum of %d", len(ids), MaxBulkRows)
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf(readUserIdsQuery, strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "))
+	var found []uint
+	err := w.retry.Do(ctx, func() error {
+		var err error
+		found, err = scanIds(w.statements.query(ctx, nil, query, args...))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read user ids: %w", err)
+	}
+	return found, nil
+}
+
+// scanIds reads a column of IDs
+func scanIds(rows *sql.Rows, err error) ([]uint, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (w DatabaseBulkWriter) ExportUsers(ctx context.Context, afterId uint, limit int) ([]UserModel, error) {
+	var users []UserModel
+	err := w.retry.Do(ctx, func() error {
+		var err error
+		users, err = ScanUsers(w.statements.query(ctx, nil, exportUsersQuery, afterId, limit))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to export users: %w", err)
+	}
+	return users, nil
+}
+
+func (w DatabaseBulkWriter) ExportDecisions(ctx context.Context, afterId uint, limit int) ([]BulkDecision, error) {
+	var decisions []BulkDecision
+	err := w.retry.Do(ctx, func() error {
+		var err error
+		decisions, err = ScanBulkDecisions(w.statements.query(ctx, nil, exportDecisionsQuery, afterId, limit, afterId, limit, limit))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to export decisions: %w", err)
+	}
+	return decisions, nil
+}
+
+// ScanBulkDecisions reads the id, actor_id, recipient_id, liked, is_new, created_at and updated_at columns of
+// decisions, the timestamps being unix timestamps
+func ScanBulkDecisions(rows *sql.Rows, err error) ([]BulkDecision, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []BulkDecision
+	for rows.Next() {
+		var d BulkDecision
+		if err := rows.Scan(&d.Id, &d.ActorId, &d.RecipientId, &d.Liked, &d.IsNew, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
+
+// Interface guards
+var (
+	_ BulkWriter   = (*DatabaseBulkWriter)(nil)
+	_ BulkImporter = (*DatabaseBulkWriter)(nil)
+	_ BulkExporter = (*DatabaseBulkWriter)(nil)
+)
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"app/database"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Options configures the caching decorators
+type Options struct {
+	// TTL bounds how long a value is served from the cache, and so how stale it may get when an invalidation is lost
+	TTL time.Duration
+	// Pages is the number of likes pages cached per recipient, later pages are always read from the database
+	Pages int
+	// LoadTimeout bounds a database read shared by concurrent misses, which outlives the caller that started it.
+	// 0 disables it.
+	LoadTimeout time.Duration
+}
+
+func userKey(userId string) string {
+	return "user:" + userId
+}
+
+func likesKey(recipientId string, page int) string {
+	return fmt.Sprintf("likes:%s:%d", recipientId, page)
+}
+
+// recipientKeys returns every key cached for a recipient
+func recipientKeys(recipientId string, pages int) []string {
+	keys := []string{userKey(recipientId)}
+	for page := 1; page <= pages; page++ {
+		keys = append(keys, likesKey(recipientId, page))
+	}
+	return keys
+}
+
+// Reader is a read-through cache in front of a database.Reader. It caches users (and so their likes count)
+// and the first pages of likes. New likes and matches are always read from the database: new likes are
+// marked as viewed on every read and matches must reflect the decision that was just written.
+type Reader struct {
+	database.Reader
+	store   Store
+	options Options
+	group   singleflight.Group
+}
+
+func NewReader(reader database.Reader, store Store, options Options) *Reader {
+	return &Reader{Reader: reader, store: store, options: options}
+}
+
+// GetUserById returns the cached user, reading it from the database on a miss
+func (r *Reader) GetUserById(ctx context.Context, userId string) (database.UserModel, error) {
+	return readThrough(ctx, r, userKey(userId), func(ctx context.Context) (database.UserModel, error) {
+		return r.Reader.GetUserById(ctx, userId)
+	})
+}
+
+// FindLikesByRecipientIdPaginated returns the cached likes page, reading it from the database on a miss
+func (r *Reader) FindLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]database.DecisionModel, error) {
+	if page < 1 || page > r.options.Pages {
+		return r.Reader.FindLikesByRecipientIdPaginated(ctx, recipientId, page)
+	}
+	return readThrough(ctx, r, likesKey(recipientId, page), func(ctx context.Context) ([]database.DecisionModel, error) {
+		return r.Reader.FindLikesByRecipientIdPaginated(ctx, recipientId, page)
+	})
+}
+
+// readThrough serves key from the cache, concurrent misses on the same key share a single database read.
+// The shared read is not cancelled with the caller that started it, each caller stops waiting when its own
+// ctx is done. Errors of the cache store are logged and the database is read instead.
+func readThrough[T any](ctx context.Context, r *Reader, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	var value T
+
+	cached, ok, err := r.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("Error reading %s from cache: %s", key, err)
+	}
+	if ok && json.Unmarshal(cached, &value) == nil {
+		return value, nil
+	}
+
+	loaded := r.group.DoChan(key, func() (interface{}, error) {
+		ctx := context.WithoutCancel(ctx)
+		if r.options.LoadTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.options.LoadTimeout)
+			defer cancel()
+		}
+
+		value, err := load(ctx)
+		if err != nil {
+			return value, err
+		}
+
+		encoded, err := json.Marshal(value)
+		if err == nil {
+			err = r.store.Set(ctx, key, encoded, r.options.TTL)
+		}
+		if err != nil {
+			log.Printf("Error writing %s to cache: %s", key, err)
+		}
+		return value, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return value, ctx.Err()
+	case result := <-loaded:
+		if result.Err != nil {
+			return value, result.Err
+		}
+		return result.Val.(T), nil
+	}
+}
+
+// Writer invalidates the cached values of a recipient after every write touching their decisions.
+// Counters repaired by a database.CounterReconciler are only seen once the cached users expire.
+type Writer struct {
+	writer  database.Writer
+	store   Store
+	options Options
+}
+
+func NewWriter(writer database.Writer, store Store, options Options) *Writer {
+	return &Writer{writer: writer, store: store, options: options}
+}
+
+// InsertOrUpdateDecision also invalidates the actor, whose matches counter may change
+func (w *Writer) InsertOrUpdateDecision(ctx context.Context, entry database.PutDecisionEntry) error {
+	keys := append(recipientKeys(entry.RecipientId, w.options.Pages), userKey(entry.ActorId))
+	defer w.invalidate(ctx, entry.RecipientId, keys...)
+	return w.writer.InsertOrUpdateDecision(ctx, entry)
+}
+
+// UpdateLikesAsViewed also invalidates the cached likes pages of the recipient, as the databases refresh the
+// updated_at timestamp of the viewed likes along with their is_new flag
+func (w *Writer) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []database.DecisionModel) error {
+	if len(likes) > 0 {
+		defer w.invalidate(ctx, RecipientId, recipientKeys(RecipientId, w.options.Pages)...)
+	}
+	return w.writer.UpdateLikesAsViewed(ctx, RecipientId, likes)
+}
+
+// invalidate runs even when the write failed, as the database may have applied part of it,
+// and even when the caller went away once the write is done
+func (w *Writer) invalidate(ctx context.Context, recipientId string, keys ...string) {
+	if err := w.store.Delete(context.WithoutCancel(ctx), keys...); err != nil {
+		log.Printf("Error invalidating cache of user %s: %s", recipientId, err)
+	}
+}
+
+// Interface guards
+var (
+	_ database.Reader = (*Reader)(nil)
+	_ database.Writer = (*Writer)(nil)
+)
@@ -0,0 +1,324 @@
+package cache_test
+
+import (
+	"app/database"
+	"app/database/cache"
+	"app/database/memory"
+	"app/database/mocks"
+	"app/database/storagetest"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/mock"
+	"github.com/zeebo/assert"
+)
+
+var options = cache.Options{TTL: time.Minute, Pages: 2}
+
+func newRedisStore(t *testing.T) (*cache.RedisStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return cache.NewRedisStore(client, "explore:"), server
+}
+
+func TestConformance(t *testing.T) {
+	stores := map[string]func(t *testing.T) cache.Store{
+		"lru": func(t *testing.T) cache.Store { return cache.NewLRUStore(100) },
+		"redis": func(t *testing.T) cache.Store {
+			store, _ := newRedisStore(t)
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+				backend := memory.NewStore()
+				store := newStore(t)
+				return storagetest.Backend{
+					Reader:     cache.NewReader(backend, store, options),
+					Writer:     cache.NewWriter(backend, store, options),
+					Reconciler: backend,
+					Archiver:   backend,
+					AddUser: func(ctx context.Context, user database.UserModel) (string, error) {
+						return backend.AddUser(user)
+					},
+				}
+			})
+		})
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store := cache.NewLRUStoreWithClock(100, func() time.Time { return now })
+
+	likes := []database.DecisionModel{{Id: "1", ActorId: 2, RecipientId: 1, Liked: true}}
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").Return(database.UserModel{Id: "1", Likes: 1}, nil).Twice()
+	mockReader.Mock.On("FindLikesByRecipientIdPaginated", mock.Anything, "1", 1).Return(likes, nil).Once()
+	mockReader.Mock.On("FindLikesByRecipientIdPaginated", mock.Anything, "1", 3).Return([]database.DecisionModel(nil), nil).Twice()
+	mockReader.Mock.On("GetIsMatch", mock.Anything, "1", "2").Return(true, nil).Twice()
+
+	reader := cache.NewReader(mockReader, store, options)
+
+	for i := 0; i < 3; i++ {
+		user, err := reader.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1), user.Likes)
+
+		page, err := reader.FindLikesByRecipientIdPaginated(ctx, "1", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, likes, page)
+	}
+
+	// pages beyond Options.Pages and matches are not cached
+	for i := 0; i < 2; i++ {
+		_, err := reader.FindLikesByRecipientIdPaginated(ctx, "1", 3)
+		assert.NoError(t, err)
+		isMatch, err := reader.GetIsMatch(ctx, "1", "2")
+		assert.NoError(t, err)
+		assert.True(t, isMatch)
+	}
+
+	now = now.Add(options.TTL)
+	_, err := reader.GetUserById(ctx, "1")
+	assert.NoError(t, err)
+}
+
+func TestReadErrorsAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").Return(database.UserModel{}, errors.New("connection refused")).Once()
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").Return(database.UserModel{Id: "1"}, nil).Once()
+
+	reader := cache.NewReader(mockReader, cache.NewLRUStore(10), options)
+
+	_, err := reader.GetUserById(ctx, "1")
+	assert.Error(t, err)
+	user, err := reader.GetUserById(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", user.Id)
+}
+
+func TestWritesInvalidateRecipient(t *testing.T) {
+	ctx := context.Background()
+	store := cache.NewLRUStore(100)
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, mock.Anything).Return(func(ctx context.Context, userId string) (database.UserModel, error) {
+		return database.UserModel{Id: userId, NewLikes: 1}, nil
+	})
+	mockReader.Mock.On("FindLikesByRecipientIdPaginated", mock.Anything, mock.Anything, mock.Anything).Return([]database.DecisionModel(nil), nil)
+	mockWriter := mocks.NewWriter(t)
+	mockWriter.Mock.On("InsertOrUpdateDecision", ctx, mock.Anything).Return(nil)
+	mockWriter.Mock.On("UpdateLikesAsViewed", ctx, "1", mock.Anything).Return(errors.New("deadlock")).Once()
+	mockWriter.Mock.On("UpdateLikesAsViewed", ctx, "1", mock.Anything).Return(nil)
+
+	reader := cache.NewReader(mockReader, store, options)
+	writer := cache.NewWriter(mockWriter, store, options)
+
+	warm := func() {
+		for _, userId := range []string{"1", "2"} {
+			_, err := reader.GetUserById(ctx, userId)
+			assert.NoError(t, err)
+			for page := 1; page <= options.Pages; page++ {
+				_, err = reader.FindLikesByRecipientIdPaginated(ctx, userId, page)
+				assert.NoError(t, err)
+			}
+		}
+		assert.Equal(t, 6, store.Len())
+	}
+
+	// the actor's matches may change too, but not their likes
+	warm()
+	assert.NoError(t, writer.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: "2", RecipientId: "1", Like: true}))
+	assert.Equal(t, 2, store.Len())
+
+	// viewed likes refresh the timestamps of the likes pages of the recipient, even when the write fails
+	likes := []database.DecisionModel{{Id: "1", ActorId: 2, RecipientId: 1, Liked: true}}
+	warm()
+	assert.Error(t, writer.UpdateLikesAsViewed(ctx, "1", likes))
+	assert.Equal(t, 3, store.Len())
+	warm()
+	assert.NoError(t, writer.UpdateLikesAsViewed(ctx, "1", likes))
+	assert.Equal(t, 3, store.Len())
+
+	// nothing changes when no like is given
+	warm()
+	assert.NoError(t, writer.UpdateLikesAsViewed(ctx, "1", nil))
+	assert.Equal(t, 6, store.Len())
+}
+
+func TestViewedLikesHaveFreshTimestamps(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	backend := memory.NewStoreWithClock(func() time.Time { return now })
+	for _, userId := range []string{"1", "2"} {
+		_, err := backend.AddUser(database.UserModel{Id: userId})
+		assert.NoError(t, err)
+	}
+	_, err := backend.AddDecision("2", "1", true, true)
+	assert.NoError(t, err)
+
+	store := cache.NewLRUStore(100)
+	reader := cache.NewReader(backend, store, options)
+	writer := cache.NewWriter(backend, store, options)
+
+	likes, err := reader.FindLikesByRecipientIdPaginated(ctx, "1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(likes))
+	assert.Equal(t, uint64(now.Unix()), likes[0].Updated_at)
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, writer.UpdateLikesAsViewed(ctx, "1", likes))
+
+	likes, err = reader.FindLikesByRecipientIdPaginated(ctx, "1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(likes))
+	assert.Equal(t, uint64(now.Unix()), likes[0].Updated_at)
+}
+
+func TestConcurrentMissesShareOneRead(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").
+		Run(func(mock.Arguments) { <-release }).
+		Return(database.UserModel{Id: "1", Likes: 5}, nil).
+		Once()
+
+	reader := cache.NewReader(mockReader, cache.NewLRUStore(10), options)
+
+	const callers = 20
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			user, err := reader.GetUserById(ctx, "1")
+			assert.NoError(t, err)
+			assert.Equal(t, uint(5), user.Likes)
+		}()
+	}
+
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+}
+
+func TestCancelledCallerDoesNotCancelSharedRead(t *testing.T) {
+	release := make(chan struct{})
+	first, cancel := context.WithCancel(context.Background())
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").
+		Run(func(args mock.Arguments) {
+			<-release
+			assert.NoError(t, args.Get(0).(context.Context).Err())
+		}).
+		Return(database.UserModel{Id: "1", Likes: 5}, nil).
+		Once()
+
+	reader := cache.NewReader(mockReader, cache.NewLRUStore(10), options)
+
+	var done sync.WaitGroup
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		_, err := reader.GetUserById(first, "1")
+		assert.Equal(t, context.Canceled, err)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the second caller waits for the read started by the first one, which goes away
+	second := make(chan database.UserModel)
+	go func() {
+		user, err := reader.GetUserById(context.Background(), "1")
+		assert.NoError(t, err)
+		second <- user
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	done.Wait()
+
+	close(release)
+	assert.Equal(t, uint(5), (<-second).Likes)
+	user, err := reader.GetUserById(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), user.Likes)
+}
+
+func TestLRUStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store := cache.NewLRUStore(2)
+
+	assert.NoError(t, store.Set(ctx, "a", []byte("1"), time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", []byte("2"), time.Minute))
+	_, ok, _ := store.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.NoError(t, store.Set(ctx, "c", []byte("3"), time.Minute))
+
+	_, ok, _ = store.Get(ctx, "b")
+	assert.False(t, ok)
+	value, ok, _ := store.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(value))
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	store, server := newRedisStore(t)
+
+	_, ok, err := store.Get(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Set(ctx, "user:1", []byte("cached"), time.Minute))
+	assert.True(t, server.Exists("explore:user:1"))
+	value, ok, err := store.Get(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "cached", string(value))
+
+	server.FastForward(time.Minute)
+	_, ok, err = store.Get(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Set(ctx, "likes:1:1", []byte("[]"), time.Minute))
+	assert.NoError(t, store.Delete(ctx, "likes:1:1", "likes:1:2"))
+	assert.False(t, server.Exists("explore:likes:1:1"))
+
+	server.Close()
+	_, _, err = store.Get(ctx, "user:1")
+	assert.Error(t, err)
+}
+
+func TestStoreErrorsFallBackToTheDatabase(t *testing.T) {
+	ctx := context.Background()
+	store, server := newRedisStore(t)
+	server.Close()
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", mock.Anything, "1").Return(database.UserModel{Id: "1"}, nil).Twice()
+
+	reader := cache.NewReader(mockReader, store, options)
+	for i := 0; i < 2; i++ {
+		user, err := reader.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", user.Id)
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps the cached values. LRUStore is local to the process; RedisStore is shared by every server replica,
+// so an invalidation done by one replica is seen by all of them.
+type Store interface {
+	// Get returns the value stored under key, ok is false on a miss or when the value expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// LRUStore is a thread-safe in-process Store holding at most capacity entries, evicting the least recently used
+type LRUStore struct {
+	now      func() time.Time
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewLRUStore(capacity int) *LRUStore {
+	return NewLRUStoreWithClock(capacity, time.Now)
+}
+
+// NewLRUStoreWithClock creates a LRUStore reading the time from now, for tests
+func NewLRUStoreWithClock(capacity int, now func() time.Time) *LRUStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRUStore{
+		now:      now,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *LRUStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := element.Value.(*entry)
+	if !s.now().Before(e.expiresAt) {
+		s.remove(element)
+		return nil, false, nil
+	}
+
+	s.order.MoveToFront(element)
+	return e.value, true, nil
+}
+
+func (s *LRUStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := s.now().Add(ttl)
+	if element, ok := s.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.value = value
+		e.expiresAt = expiresAt
+		s.order.MoveToFront(element)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *LRUStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := s.entries[key]; ok {
+			s.remove(element)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, expired ones included until they are read or evicted
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *LRUStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*entry).key)
+}
+
+// Interface guards
+var (
+	_ Store = (*LRUStore)(nil)
+)
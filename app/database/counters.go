@@ -0,0 +1,42 @@
+package database
+
+import "context"
+
+// CounterReconciler repairs the denormalised counters of the users (likes, new_likes and matches)
+// when they drifted from the decisions they summarise
+type CounterReconciler interface {
+	// ReconcileCounters recomputes the counters of up to limit users with an ID greater than afterUserId.
+	// It returns the last user ID of the batch, 0 once there are no users left, and the number of users repaired.
+	ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (lastUserId uint, repaired int, err error)
+}
+
+// DecisionState is the stored state of a decision before it is written
+type DecisionState struct {
+	Exists bool
+	Liked  bool
+	IsNew  bool
+}
+
+// CounterDeltas returns how the counters change when a decision in the previous state is set to liked:
+// the likes and new likes of the recipient, and the matches of both users. reverseLiked tells whether the
+// recipient likes the actor back, it must be false for a self decision.
+func CounterDeltas(previous DecisionState, liked bool, reverseLiked bool) (likes int, newLikes int, matches int) {
+	if previous.Exists && previous.Liked == liked || !previous.Exists && !liked {
+		return 0, 0, 0
+	}
+
+	delta := 1
+	if !liked {
+		delta = -1
+	}
+
+	likes = delta
+	// is_new only flags likes: a decision turning into a like is new, a like turning into a pass stops being new
+	if liked || previous.IsNew {
+		newLikes = delta
+	}
+	if reverseLiked {
+		matches = delta
+	}
+	return likes, newLikes, matches
+}
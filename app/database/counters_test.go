@@ -0,0 +1,38 @@
+package database_test
+
+import (
+	"app/database"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestCounterDeltas(t *testing.T) {
+	tests := []struct {
+		name         string
+		previous     database.DecisionState
+		liked        bool
+		reverseLiked bool
+		likes        int
+		newLikes     int
+		matches      int
+	}{
+		{name: "new_like", liked: true, likes: 1, newLikes: 1},
+		{name: "new_pass", liked: false},
+		{name: "new_like_back", liked: true, reverseLiked: true, likes: 1, newLikes: 1, matches: 1},
+		{name: "same_like", previous: database.DecisionState{Exists: true, Liked: true, IsNew: true}, liked: true, reverseLiked: true},
+		{name: "same_pass", previous: database.DecisionState{Exists: true}, liked: false},
+		{name: "unviewed_like_to_pass", previous: database.DecisionState{Exists: true, Liked: true, IsNew: true}, liked: false, likes: -1, newLikes: -1},
+		{name: "viewed_like_to_pass", previous: database.DecisionState{Exists: true, Liked: true}, liked: false, reverseLiked: true, likes: -1, matches: -1},
+		{name: "pass_to_like", previous: database.DecisionState{Exists: true}, liked: true, reverseLiked: true, likes: 1, newLikes: 1, matches: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			likes, newLikes, matches := database.CounterDeltas(test.previous, test.liked, test.reverseLiked)
+			assert.Equal(t, test.likes, likes)
+			assert.Equal(t, test.newLikes, newLikes)
+			assert.Equal(t, test.matches, matches)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+// The statements of the reader and the writer, for the query plan tests
+var (
+	ReadDecisionsWithLikeByRecipientIdPaginated    = readDecisionsWithLikeByRecipientIdPaginated
+	ReadNewDecisionsWithLikeByRecipientIdPaginated = readNewDecisionsWithLikeByRecipientIdPaginated
+	ReadActiveUsersById                            = readActiveUsersById
+	ReadActiveUsersByIds                           = readActiveUsersByIds
+	ReadActiveUsersFiltered                        = readActiveUsersFiltered
+	ReadGetMatchByActorIdAndRecipientId            = readGetMatchByActorIdAndRecipientId
+	LockUsersQuery                                 = lockUsersQuery
+	ReadDecisionStateQuery                         = readDecisionStateQuery
+	ReadReverseLikeQuery                           = readReverseLikeQuery
+	UpdateRecipientCountersQuery                   = updateRecipientCountersQuery
+	UpdateMatchesQuery                             = updateMatchesQuery
+	UpdateDecisionLikeQuery                        = updateDecisionLikeQuery
+	UpdateNewLikesQuery                            = updateNewLikesQuery
+	LockUsersBatchQuery                            = lockUsersBatchQuery
+	ReconcileCountersQuery                         = reconcileCountersQuery
+	RestoreDecisionsQuery                          = restoreDecisionsQuery
+	DeleteRestoredDecisionsQuery                   = deleteRestoredDecisionsQuery
+	ReadDecisionsBatchQuery                        = readDecisionsBatchQuery
+	LockArchivedUsersQuery                         = lockArchivedUsersQuery
+	ArchiveDecisionsQuery                          = archiveDecisionsQuery
+	DeleteArchivedDecisionsQuery                   = deleteArchivedDecisionsQuery
+	InsertOrUpdateDecisionQuery                    = insertOrUpdateDecisionQuery
+	ReadLikeQuery                                  = readLikeQuery
+	ReadSentLikeQuery                              = readSentLikeQuery
+	InsertSentLikeQuery                            = insertSentLikeQuery
+	DeleteSentLikeQuery                            = deleteSentLikeQuery
+	ReadLikesBatchQuery                            = readLikesBatchQuery
+	ReadSentLikesBatchQuery                        = readSentLikesBatchQuery
+)
+
+// WithCommitError returns the router failing the first transaction of the decisions spanning two shards with err
+// once the second one committed
+func (r ShardRouter) WithCommitError(err error) ShardRouter {
+	r.commitErr = err
+	return r
+}
+
+// Prepared returns the number of statements prepared on the primary of the reader
+func (r DatabaseReader) Prepared() int {
+	r.primary.mu.Lock()
+	defer r.primary.mu.Unlock()
+	return len(r.primary.prepared)
+}
@@ -0,0 +1,522 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"app/database"
+)
+
+type pair struct {
+	actorId     uint
+	recipientId uint
+}
+
+type decision struct {
+	id    uint
+	model database.DecisionModel
+	isNew bool
+	// archived decisions are in decisions_archive on MySQL, where they are still read as likes but never new
+	archived bool
+}
+
+// Store is a thread-safe in-memory implementation of database.Reader and database.Writer
+// following the semantics of the MySQL implementation. It is meant for tests and local development.
+type Store struct {
+	now func() time.Time
+
+	mu             sync.RWMutex
+	users          map[uint]*database.UserModel
+	decisions      map[pair]*decision
+	decisionsById  map[uint]*decision
+	nextUserId     uint
+	nextDecisionId uint
+}
+
+func NewStore() *Store {
+	return NewStoreWithClock(time.Now)
+}
+
+// NewStoreWithClock creates a Store reading the time from now, for tests
+func NewStoreWithClock(now func() time.Time) *Store {
+	return &Store{
+		now:            now,
+		users:          make(map[uint]*database.UserModel),
+		decisions:      make(map[pair]*decision),
+		decisionsById:  make(map[uint]*decision),
+		nextUserId:     1,
+		nextDecisionId: 1,
+	}
+}
+
+// parseId converts an ID the way MySQL compares a string with an INT column, returning false when it can never match
+func parseId(id string) (uint, bool) {
+	parsed, err := strconv.ParseUint(id, 10, 0)
+	if err != nil || parsed == 0 {
+		return 0, false
+	}
+	return uint(parsed), true
+}
+
+func (s *Store) timestamp() uint64 {
+	return uint64(s.now().Unix())
+}
+
+// AddUser inserts a user, assigning the next ID when user.Id is empty, and returns its ID
+func (s *Store) AddUser(user database.UserModel) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextUserId
+	if user.Id != "" {
+		parsed, ok := parseId(user.Id)
+		if !ok {
+			return "", fmt.Errorf("invalid user id %q", user.Id)
+		}
+		if _, exists := s.users[parsed]; exists {
+			return "", fmt.Errorf("duplicate user id %q", user.Id)
+		}
+		id = parsed
+	}
+	if id >= s.nextUserId {
+		s.nextUserId = id + 1
+	}
+
+	now := s.timestamp()
+	user = clone(user)
+	user.Id = strconv.FormatUint(uint64(id), 10)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	s.users[id] = &user
+
+	return user.Id, nil
+}
+
+// AddDecision inserts a decision with the given is_new flag, without touching the counters, and returns its ID
+func (s *Store) AddDecision(actorId string, recipientId string, liked bool, isNew bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.pair(actorId, recipientId)
+	if err != nil {
+		return "", err
+	}
+	if _, exists := s.decisions[key]; exists {
+		return "", fmt.Errorf("duplicate decision from %s to %s", actorId, recipientId)
+	}
+
+	return s.insertDecision(key, liked, isNew).model.Id, nil
+}
+
+func (s *Store) pair(actorId string, recipientId string) (pair, error) {
+	actor, ok := parseId(actorId)
+	if !ok || s.users[actor] == nil {
+		return pair{}, fmt.Errorf("actor %q does not exist", actorId)
+	}
+	recipient, ok := parseId(recipientId)
+	if !ok || s.users[recipient] == nil {
+		return pair{}, fmt.Errorf("recipient %q does not exist", recipientId)
+	}
+	return pair{actorId: actor, recipientId: recipient}, nil
+}
+
+func (s *Store) insertDecision(key pair, liked bool, isNew bool) *decision {
+	now := s.timestamp()
+	d := &decision{
+		id: s.nextDecisionId,
+		model: database.DecisionModel{
+			Id:          strconv.FormatUint(uint64(s.nextDecisionId), 10),
+			ActorId:     key.actorId,
+			RecipientId: key.recipientId,
+			Liked:       liked,
+			Created_at:  now,
+			Updated_at:  now,
+		},
+		isNew: isNew,
+	}
+	s.decisions[key] = d
+	s.decisionsById[s.nextDecisionId] = d
+	s.nextDecisionId++
+	return d
+}
+
+// likes returns the likes received by the recipient ordered by decision ID, as MySQL returns them through idx_recipient_likes
+func (s *Store) likes(recipientId string, onlyNew bool) []*decision {
+	recipient, ok := parseId(recipientId)
+	if !ok {
+		return nil
+	}
+
+	var likes []*decision
+	for key, d := range s.decisions {
+		if key.recipientId == recipient && d.model.Liked && (!onlyNew || d.isNew) {
+			likes = append(likes, d)
+		}
+	}
+	sort.Slice(likes, func(i, j int) bool {
+		return likes[i].id < likes[j].id
+	})
+	return likes
+}
+
+func (s *Store) page(likes []*decision, page int) []database.DecisionModel {
+	offset := (page - 1) * database.Limit
+	if offset < 0 || offset >= len(likes) {
+		return nil
+	}
+
+	end := min(offset+database.Limit, len(likes))
+	decisions := make([]database.DecisionModel, 0, end-offset)
+	for _, d := range likes[offset:end] {
+		decisions = append(decisions, d.model)
+	}
+	return decisions
+}
+
+// FindLikesByRecipientIdPaginated finds all likes for a given recipient user ID with pagination
+func (s *Store) FindLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]database.DecisionModel, error) {
+	if page < 1 {
+		return nil, fmt.Errorf("invalid page %d", page)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.page(s.likes(recipientId, false), page), nil
+}
+
+// FindNewLikesByRecipientIdPaginated finds all new/unchecked likes for a given recipient user ID with pagination
+func (s *Store) FindNewLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]database.DecisionModel, error) {
+	if page < 1 {
+		return nil, fmt.Errorf("invalid page %d", page)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.page(s.likes(recipientId, true), page), nil
+}
+
+// GetIsMatch reports whether the actor and the recipient like each other
+func (s *Store) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
+	actor, ok := parseId(ActorId)
+	if !ok {
+		return false, nil
+	}
+	recipient, ok := parseId(RecipientId)
+	if !ok {
+		return false, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	forward, ok := s.decisions[pair{actorId: actor, recipientId: recipient}]
+	if !ok || !forward.model.Liked {
+		return false, nil
+	}
+	backward, ok := s.decisions[pair{actorId: recipient, recipientId: actor}]
+	// a self-like is a single row, MySQL only reports a match for two rows
+	return ok && backward.model.Liked && actor != recipient, nil
+}
+
+// GetUserById gets an active user, returning an empty UserModel when there is none
+func (s *Store) GetUserById(ctx context.Context, userId string) (database.UserModel, error) {
+	id, ok := parseId(userId)
+	if !ok {
+		return database.UserModel{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || !user.IsAactive {
+		return database.UserModel{}, nil
+	}
+	return clone(*user), nil
+}
+
+// clone copies a user, so the location stored is never shared with the callers
+func clone(user database.UserModel) database.UserModel {
+	if user.Location != nil {
+		location := *user.Location
+		user.Location = &location
+	}
+	return user
+}
+
+// FindUsersByIds gets the active users among the given IDs, in ID order
+func (s *Store) FindUsersByIds(ctx context.Context, userIds []string) ([]database.UserModel, error) {
+	if len(userIds) > database.MaxUsers {
+		return nil, fmt.Errorf("unable to read %d users, at most %d can be read at once", len(userIds), database.MaxUsers)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make(map[uint]bool, len(userIds))
+	for _, userId := range userIds {
+		if id, ok := parseId(userId); ok {
+			if user, exists := s.users[id]; exists && user.IsAactive {
+				found[id] = true
+			}
+		}
+	}
+	ids := make([]uint, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	return s.read(ids), nil
+}
+
+// FindUsers gets a page of the active users selected by filter, in ID order
+func (s *Store) FindUsers(ctx context.Context, filter database.UserFilter) ([]database.UserModel, error) {
+	limit, err := filter.PageSize()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []uint
+	for id, user := range s.users {
+		if id > filter.AfterId && user.IsAactive && matches(filter, user) {
+			ids = append(ids, id)
+		}
+	}
+	users := s.read(ids)
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// matches reports whether the user is selected by the filter, the users missing a filtered attribute never are
+func matches(filter database.UserFilter, user *database.UserModel) bool {
+	switch {
+	case filter.Gender != "" && user.Gender != filter.Gender:
+		return false
+	case filter.BornFrom != "" && (user.BirthDate == "" || user.BirthDate < filter.BornFrom):
+		return false
+	case filter.BornTo != "" && (user.BirthDate == "" || user.BirthDate > filter.BornTo):
+		return false
+	case filter.ActiveSince > 0 && user.LastActiveAt < filter.ActiveSince:
+		return false
+	case filter.Area != nil && (user.Location == nil || !filter.Area.Contains(*user.Location)):
+		return false
+	}
+	return true
+}
+
+// read copies the users of ids, sorted by ID
+func (s *Store) read(ids []uint) []database.UserModel {
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	users := make([]database.UserModel, len(ids))
+	for i, id := range ids {
+		users[i] = clone(*s.users[id])
+	}
+	return users
+}
+
+func (s *Store) GetLimit() int {
+	return database.Limit
+}
+
+// InsertOrUpdateDecision creates the decision of the actor on the recipient or updates its liked flag,
+// updating the counters of both users
+func (s *Store) InsertOrUpdateDecision(ctx context.Context, entry database.PutDecisionEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.pair(entry.ActorId, entry.RecipientId)
+	if err != nil {
+		return fmt.Errorf("unable to insert or update decision: %w", err)
+	}
+
+	s.restore(key)
+	s.restore(pair{actorId: key.recipientId, recipientId: key.actorId})
+
+	var previous database.DecisionState
+	existing, ok := s.decisions[key]
+	if ok {
+		previous = database.DecisionState{Exists: true, Liked: existing.model.Liked, IsNew: existing.isNew}
+	}
+
+	// likes are new, and a decision is new again when it turns into a like
+	if !ok {
+		s.insertDecision(key, entry.Like, entry.Like)
+	} else if existing.model.Liked != entry.Like {
+		existing.model.Liked = entry.Like
+		existing.isNew = entry.Like
+		existing.model.Updated_at = s.timestamp()
+	}
+
+	reverse, ok := s.decisions[pair{actorId: key.recipientId, recipientId: key.actorId}]
+	reverseLiked := ok && reverse.model.Liked && key.actorId != key.recipientId
+	likes, newLikes, matches := database.CounterDeltas(previous, entry.Like, reverseLiked)
+
+	s.addCounters(s.users[key.recipientId], likes, newLikes, matches)
+	s.addCounters(s.users[key.actorId], 0, 0, matches)
+	return nil
+}
+
+// addCounters applies deltas to the counters of user, clamped at 0 as UserModel counters are unsigned
+func (s *Store) addCounters(user *database.UserModel, likes int, newLikes int, matches int) {
+	if likes == 0 && newLikes == 0 && matches == 0 {
+		return
+	}
+	add := func(counter *uint, delta int) {
+		*counter = uint(max(int(*counter)+delta, 0))
+	}
+	add(&user.Likes, likes)
+	add(&user.NewLikes, newLikes)
+	add(&user.Matches, matches)
+	user.UpdatedAt = s.timestamp()
+}
+
+// UpdateLikesAsViewed clears the is_new flag of the given likes of the recipient
+func (s *Store) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []database.DecisionModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipient, ok := parseId(RecipientId)
+	if !ok {
+		return nil
+	}
+
+	viewed := 0
+	for _, like := range likes {
+		id, ok := parseId(like.Id)
+		if !ok {
+			continue
+		}
+		if d, ok := s.decisionsById[id]; ok && d.model.RecipientId == recipient && d.model.Liked && d.isNew {
+			d.isNew = false
+			d.model.Updated_at = s.timestamp()
+			viewed++
+		}
+	}
+
+	if viewed > 0 {
+		s.addCounters(s.users[recipient], 0, -viewed, 0)
+	}
+	return nil
+}
+
+// ReconcileCounters recomputes the counters of a batch of users from their decisions
+func (s *Store) ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (uint, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]uint, 0, len(s.users))
+	for id := range s.users {
+		if id > afterUserId {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	type counters struct{ likes, newLikes, matches uint }
+	expected := make(map[uint]*counters, len(ids))
+	for _, id := range ids {
+		expected[id] = &counters{}
+	}
+	for key, d := range s.decisions {
+		if !d.model.Liked {
+			continue
+		}
+		if c, ok := expected[key.recipientId]; ok {
+			c.likes++
+			if d.isNew {
+				c.newLikes++
+			}
+		}
+		reverse, ok := s.decisions[pair{actorId: key.recipientId, recipientId: key.actorId}]
+		if c, found := expected[key.actorId]; found && ok && reverse.model.Liked && key.actorId != key.recipientId {
+			c.matches++
+		}
+	}
+
+	repaired := 0
+	for _, id := range ids {
+		user, c := s.users[id], expected[id]
+		if user.Likes != c.likes || user.NewLikes != c.newLikes || user.Matches != c.matches {
+			user.Likes, user.NewLikes, user.Matches = c.likes, c.newLikes, c.matches
+			user.UpdatedAt = s.timestamp()
+			repaired++
+		}
+	}
+	return ids[len(ids)-1], repaired, nil
+}
+
+// restore moves back the archived decision of a pair
+func (s *Store) restore(key pair) {
+	if d, ok := s.decisions[key]; ok {
+		d.archived = false
+	}
+}
+
+// ArchiveDecisions flags the decisions of a batch selected by policy as archived
+func (s *Store) ArchiveDecisions(ctx context.Context, policy database.ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]uint, 0, len(s.decisionsById))
+	for id := range s.decisionsById {
+		if id > afterId {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	archived := 0
+	for _, id := range ids {
+		d := s.decisionsById[id]
+		if !d.archived && archivable(policy, d, s.users[d.model.ActorId]) {
+			d.archived = true
+			archived++
+		}
+	}
+	return ids[len(ids)-1], archived, nil
+}
+
+// archivable reports whether the policy selects the decision of actor
+func archivable(policy database.ArchivePolicy, d *decision, actor *database.UserModel) bool {
+	if !d.model.Liked {
+		return d.model.Updated_at < policy.PassesBefore
+	}
+	lastActive := actor.LastActiveAt
+	if lastActive == 0 {
+		lastActive = actor.CreatedAt
+	}
+	return !d.isNew && !actor.IsAactive && lastActive < policy.InactiveLikesBefore
+}
+
+// Interface guards
+var (
+	_ database.Reader            = (*Store)(nil)
+	_ database.Writer            = (*Store)(nil)
+	_ database.CounterReconciler = (*Store)(nil)
+	_ database.DecisionArchiver  = (*Store)(nil)
+)
@@ -0,0 +1,24 @@
+package memory_test
+
+import (
+	"app/database"
+	"app/database/memory"
+	"app/database/storagetest"
+	"context"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		store := memory.NewStore()
+		return storagetest.Backend{
+			Reader:     store,
+			Writer:     store,
+			Reconciler: store,
+			Archiver:   store,
+			AddUser: func(ctx context.Context, user database.UserModel) (string, error) {
+				return store.AddUser(user)
+			},
+		}
+	})
+}
@@ -0,0 +1,412 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// DefaultBackfillBatchSize is the number of rows updated per chunk when BackfillOptions.BatchSize is 0
+const DefaultBackfillBatchSize = 1000
+
+// Backfill is a migration step written in Go, updating the rows of a large table by chunks of its key, each in a
+// transaction of its own, instead of in a single statement locking the table. It runs once the SQL file of its
+// Version is applied, and records its progress after every chunk, so an interrupted backfill resumes where it stopped.
+//
+// A schema change on a large table is rolled out in three steps: an online migration adds the column or the index
+// without a default to copy (ALGORITHM=INPLACE, LOCK=NONE on MySQL), a backfill fills the existing rows while the
+// servers write the new rows, then a later migration enforces the constraint.
+type Backfill struct {
+	// Version is the migration after which the backfill runs
+	Version uint
+	// Name identifies the checkpoint of the backfill, it must be unique and never change once released
+	Name string
+	// Table is walked in the order of Key, an integer column with a unique index such as the id of decisions
+	Table string
+	Key   string
+	// Update is run once per chunk, with two ? placeholders bound to the bounds of the chunk: the rows to update
+	// are the ones whose key is greater than the first and lower than or equal to the second
+	Update string
+}
+
+// backfills are the Backfill steps of the embedded migrations, by driver
+var backfills = map[string][]Backfill{}
+
+// Backfills returns the Backfill steps of the embedded migrations of driverName
+func Backfills(driverName string) []Backfill {
+	return backfills[driverName]
+}
+
+// BackfillOptions throttle the backfills
+type BackfillOptions struct {
+	// BatchSize is the number of rows updated per chunk, DefaultBackfillBatchSize when 0
+	BatchSize int
+	// Pause is waited after every chunk, leaving the database to the servers and the replicas time to catch up
+	Pause time.Duration
+	// Throttle is called before every chunk and may block, e.g. until the replicas catch up. A backfill stops on
+	// its error, and resumes from its checkpoint when run again.
+	Throttle func(ctx context.Context) error
+}
+
+// BackfillState is the progress of a backfill
+type BackfillState struct {
+	Backfill
+	// Started is false until the first chunk is updated
+	Started bool
+	// LastKey is the highest key of the chunks updated
+	LastKey int64
+	// Rows is the number of rows updated
+	Rows int64
+	Done bool
+}
+
+// Backfiller runs backfills on DB, recording their checkpoints in the schema_backfills table
+type Backfiller struct {
+	DB         *sql.DB
+	DriverName string
+	Backfills  []Backfill
+	Options    BackfillOptions
+}
+
+const createBackfillsTable = `
+CREATE TABLE IF NOT EXISTS schema_backfills (
+	name VARCHAR(255) NOT NULL PRIMARY KEY,
+	version BIGINT NOT NULL,
+	last_key BIGINT NOT NULL,
+	updated_rows BIGINT NOT NULL,
+	done BOOLEAN NOT NULL
+);
+`
+
+const readBackfillQuery = `
+SELECT
+	last_key,
+	updated_rows,
+	done
+FROM schema_backfills
+WHERE name = ?;
+`
+
+const insertBackfillQuery = `
+INSERT INTO schema_backfills (
+	name,
+	version,
+	last_key,
+	updated_rows,
+	done
+) VALUES (?, ?, ?, 0, FALSE);
+`
+
+const checkpointBackfillQuery = `
+UPDATE schema_backfills
+SET last_key = ?, updated_rows = updated_rows + ?
+WHERE name = ?;
+`
+
+const completeBackfillQuery = `
+UPDATE schema_backfills
+SET done = TRUE
+WHERE name = ?;
+`
+
+const resetBackfillsQuery = `
+DELETE FROM schema_backfills
+WHERE version > ?;
+`
+
+// OpenDB connects to dsn, a file path for sqlite, the way the migrations do
+func OpenDB(driverName string, dsn string) (*sql.DB, error) {
+	switch driverName {
+	case MySQL:
+		return sql.Open("mysql", dsn)
+	case Postgres:
+		return sql.Open("pgx", dsn)
+	case SQLite:
+		return sql.Open("sqlite", "file:"+dsn+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	default:
+		return nil, fmt.Errorf("unsupported driver %q", driverName)
+	}
+}
+
+// Run completes the backfills of the versions up to version, in version order, skipping the ones already done
+func (b Backfiller) Run(ctx context.Context, version uint) error {
+	pending, err := b.sorted()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if _, err := b.DB.ExecContext(ctx, createBackfillsTable); err != nil {
+		return fmt.Errorf("unable to create the backfills table: %w", err)
+	}
+
+	for _, backfill := range pending {
+		if backfill.Version > version {
+			break
+		}
+		if err := b.run(ctx, backfill); err != nil {
+			return fmt.Errorf("backfill %s of version %d failed: %w", backfill.Name, backfill.Version, err)
+		}
+	}
+	return nil
+}
+
+// Reset forgets the progress of the backfills of the versions after version, once they are rolled back, so they
+// run again with their migration
+func (b Backfiller) Reset(ctx context.Context, version uint) error {
+	if len(b.Backfills) == 0 {
+		return nil
+	}
+	if _, err := b.DB.ExecContext(ctx, createBackfillsTable); err != nil {
+		return fmt.Errorf("unable to create the backfills table: %w", err)
+	}
+	if _, err := b.DB.ExecContext(ctx, b.bind(resetBackfillsQuery), version); err != nil {
+		return fmt.Errorf("unable to reset the backfills: %w", err)
+	}
+	return nil
+}
+
+// Status returns the progress of every backfill, in version order
+func (b Backfiller) Status(ctx context.Context) ([]BackfillState, error) {
+	sorted, err := b.sorted()
+	if err != nil || len(sorted) == 0 {
+		return nil, err
+	}
+	if _, err := b.DB.ExecContext(ctx, createBackfillsTable); err != nil {
+		return nil, fmt.Errorf("unable to create the backfills table: %w", err)
+	}
+
+	states := make([]BackfillState, 0, len(sorted))
+	for _, backfill := range sorted {
+		state, err := b.state(ctx, backfill)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// sorted returns the backfills in version order, checking that their names are unique
+func (b Backfiller) sorted() ([]Backfill, error) {
+	sorted := append([]Backfill(nil), b.Backfills...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	names := make(map[string]bool, len(sorted))
+	for _, backfill := range sorted {
+		if backfill.Name == "" || names[backfill.Name] {
+			return nil, fmt.Errorf("invalid backfill name %q, names must be set and unique", backfill.Name)
+		}
+		names[backfill.Name] = true
+	}
+	return sorted, nil
+}
+
+func (b Backfiller) state(ctx context.Context, backfill Backfill) (BackfillState, error) {
+	state := BackfillState{Backfill: backfill, LastKey: math.MinInt64}
+	err := b.DB.QueryRowContext(ctx, b.bind(readBackfillQuery), backfill.Name).Scan(&state.LastKey, &state.Rows, &state.Done)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return BackfillState{}, fmt.Errorf("unable to read the checkpoint of backfill %s: %w", backfill.Name, err)
+	}
+	state.Started = true
+	return state, nil
+}
+
+func (b Backfiller) run(ctx context.Context, backfill Backfill) error {
+	state, err := b.state(ctx, backfill)
+	if err != nil || state.Done {
+		return err
+	}
+	if !state.Started {
+		if _, err := b.DB.ExecContext(ctx, b.bind(insertBackfillQuery), backfill.Name, backfill.Version, state.LastKey); err != nil {
+			return fmt.Errorf("unable to record the checkpoint: %w", err)
+		}
+		log.Printf("Backfilling %s of version %d", backfill.Name, backfill.Version)
+	} else {
+		log.Printf("Resuming backfill %s of version %d after key %d, %d rows updated", backfill.Name, backfill.Version, state.LastKey, state.Rows)
+	}
+
+	batchSize := b.Options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBackfillBatchSize
+	}
+	// the chunks end at the key of their last row, so a chunk never updates more than batchSize rows
+	chunkEnd := b.bind(fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[1]s > ? ORDER BY %[1]s LIMIT 1 OFFSET ?", backfill.Key, backfill.Table))
+	lastKey := b.bind(fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[1]s > ? ORDER BY %[1]s DESC LIMIT 1", backfill.Key, backfill.Table))
+	update := b.bind(backfill.Update)
+	checkpoint := b.bind(checkpointBackfillQuery)
+
+	for {
+		if b.Options.Throttle != nil {
+			if err := b.Options.Throttle(ctx); err != nil {
+				return err
+			}
+		}
+
+		var upper int64
+		err := b.DB.QueryRowContext(ctx, chunkEnd, state.LastKey, batchSize-1).Scan(&upper)
+		if err == sql.ErrNoRows {
+			err = b.DB.QueryRowContext(ctx, lastKey, state.LastKey).Scan(&upper)
+		}
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to find the next chunk: %w", err)
+		}
+
+		var rows int64
+		err = b.inTx(ctx, func(tx *sql.Tx) error {
+			result, err := tx.ExecContext(ctx, update, state.LastKey, upper)
+			if err != nil {
+				return err
+			}
+			if rows, err = result.RowsAffected(); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, checkpoint, upper, rows, backfill.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to update the rows after key %d: %w", state.LastKey, err)
+		}
+		state.LastKey = upper
+		state.Rows += rows
+
+		if b.Options.Pause > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(b.Options.Pause):
+			}
+		}
+	}
+
+	if _, err := b.DB.ExecContext(ctx, b.bind(completeBackfillQuery), backfill.Name); err != nil {
+		return fmt.Errorf("unable to complete the checkpoint: %w", err)
+	}
+	log.Printf("Backfill %s of version %d done, %d rows updated", backfill.Name, backfill.Version, state.Rows)
+	return nil
+}
+
+func (b Backfiller) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// bind replaces the ? placeholders of query with the $1, $2... ones of postgres
+func (b Backfiller) bind(query string) string {
+	if b.DriverName != Postgres {
+		return query
+	}
+	var bound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			bound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		bound.WriteRune(r)
+	}
+	return bound.String()
+}
+
+// Up applies the next n migrations, or all of them when n is negative, one at a time, running the backfills of
+// every version before applying the next one. The backfills left unfinished by a previous run are completed first.
+// It returns migrate.ErrNoChange when there was no migration to apply.
+func Up(ctx context.Context, m *migrate.Migrate, backfiller Backfiller, n int) error {
+	return stepUp(ctx, m, backfiller, func(applied int, version uint) bool {
+		return n >= 0 && applied >= n
+	})
+}
+
+// UpTo applies the migrations up to target like Up, which must be a version after the current one
+func UpTo(ctx context.Context, m *migrate.Migrate, backfiller Backfiller, target uint) error {
+	return stepUp(ctx, m, backfiller, func(applied int, version uint) bool {
+		return applied > 0 && version >= target
+	})
+}
+
+func stepUp(ctx context.Context, m *migrate.Migrate, backfiller Backfiller, done func(applied int, version uint) bool) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+	case err != nil:
+		return err
+	case dirty:
+		return migrate.ErrDirty{Version: int(version)}
+	default:
+		if err := backfiller.Run(ctx, version); err != nil {
+			return err
+		}
+	}
+
+	applied := 0
+	for !done(applied, version) {
+		err := m.Steps(1)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		applied++
+		if version, _, err = m.Version(); err != nil {
+			return err
+		}
+		if err := backfiller.Run(ctx, version); err != nil {
+			return err
+		}
+	}
+	if applied == 0 {
+		return migrate.ErrNoChange
+	}
+	return nil
+}
+
+// WaitForReplicas returns a BackfillOptions.Throttle waiting, checking every interval, until the lag measured by
+// probe is within maxLag on every replica. A replica failing the probe stops the backfill.
+func WaitForReplicas(replicas []*sql.DB, probe func(ctx context.Context, db *sql.DB) (time.Duration, error), maxLag time.Duration, interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for {
+			behind := time.Duration(0)
+			for _, replica := range replicas {
+				lag, err := probe(ctx, replica)
+				if err != nil {
+					return fmt.Errorf("unable to measure the replication lag: %w", err)
+				}
+				behind = max(behind, lag)
+			}
+			if behind <= maxLag {
+				return nil
+			}
+			log.Printf("Backfill paused, a replica is %s behind the primary", behind)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+}
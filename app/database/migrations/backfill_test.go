@@ -0,0 +1,177 @@
+package migrations_test
+
+import (
+	"app/database/migrations"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/zeebo/assert"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// newItems opens a database holding items 1 to count, whose copy column is to backfill
+func newItems(t *testing.T, count int) *sql.DB {
+	db, err := migrations.OpenDB(migrations.SQLite, filepath.Join(t.TempDir(), "backfill.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, value INTEGER NOT NULL, copy INTEGER)")
+	assert.NoError(t, err)
+	for id := 1; id <= count; id++ {
+		_, err = db.Exec("INSERT INTO items (id, value) VALUES (?, ?)", id, 10*id)
+		assert.NoError(t, err)
+	}
+	return db
+}
+
+func copied(t *testing.T, db *sql.DB) int {
+	var n int
+	assert.NoError(t, db.QueryRow("SELECT count(*) FROM items WHERE copy = value").Scan(&n))
+	return n
+}
+
+var copyItems = migrations.Backfill{
+	Version: 2,
+	Name:    "copy_items",
+	Table:   "items",
+	Key:     "id",
+	Update:  "UPDATE items SET copy = value WHERE id > ? AND id <= ?",
+}
+
+func TestBackfillResumesFromItsCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	db := newItems(t, 25)
+
+	// the throttle runs before every chunk, the third one is interrupted
+	chunks, interruptAt := 0, 3
+	backfiller := migrations.Backfiller{
+		DB:         db,
+		DriverName: migrations.SQLite,
+		Backfills:  []migrations.Backfill{copyItems},
+		Options: migrations.BackfillOptions{
+			BatchSize: 10,
+			Throttle: func(ctx context.Context) error {
+				chunks++
+				if chunks == interruptAt {
+					return errInterrupted
+				}
+				return nil
+			},
+		},
+	}
+
+	// the backfills of later versions wait for their migration
+	assert.NoError(t, backfiller.Run(ctx, 1))
+	assert.Equal(t, 0, chunks)
+
+	assert.That(t, errors.Is(backfiller.Run(ctx, 2), errInterrupted))
+	assert.Equal(t, 20, copied(t, db))
+	states, err := backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(states))
+	assert.That(t, states[0].Started && !states[0].Done)
+	assert.Equal(t, int64(20), states[0].LastKey)
+	assert.Equal(t, int64(20), states[0].Rows)
+
+	// the rows written meanwhile are left to the servers
+	_, err = db.Exec("UPDATE items SET value = 0 WHERE id = 5")
+	assert.NoError(t, err)
+
+	assert.NoError(t, backfiller.Run(ctx, 2))
+	assert.Equal(t, 24, copied(t, db))
+	states, err = backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.That(t, states[0].Done)
+	assert.Equal(t, int64(25), states[0].LastKey)
+	assert.Equal(t, int64(25), states[0].Rows)
+
+	// a backfill done never runs again, until its migration is rolled back
+	chunks, interruptAt = 0, 0
+	assert.NoError(t, backfiller.Run(ctx, 3))
+	assert.Equal(t, 0, chunks)
+	assert.NoError(t, backfiller.Reset(ctx, 2))
+	assert.NoError(t, backfiller.Run(ctx, 3))
+	assert.Equal(t, 0, chunks)
+
+	assert.NoError(t, backfiller.Reset(ctx, 1))
+	states, err = backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.That(t, !states[0].Started)
+	// three chunks, then the last check finding no row left
+	assert.NoError(t, backfiller.Run(ctx, 2))
+	assert.Equal(t, 4, chunks)
+	assert.Equal(t, 25, copied(t, db))
+}
+
+func TestBackfillOfAnEmptyTable(t *testing.T) {
+	ctx := context.Background()
+	db := newItems(t, 0)
+	backfiller := migrations.Backfiller{DB: db, DriverName: migrations.SQLite, Backfills: []migrations.Backfill{copyItems}}
+
+	assert.NoError(t, backfiller.Run(ctx, 2))
+	states, err := backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.That(t, states[0].Done)
+	assert.Equal(t, int64(0), states[0].Rows)
+}
+
+func TestBackfillNamesAreUnique(t *testing.T) {
+	db := newItems(t, 1)
+	backfiller := migrations.Backfiller{DB: db, DriverName: migrations.SQLite, Backfills: []migrations.Backfill{copyItems, copyItems}}
+	assert.Error(t, backfiller.Run(context.Background(), 2))
+}
+
+// TestUpRunsTheBackfillsBetweenMigrations checks that a backfill sees the schema of its version, and that the
+// next migration only runs once it is done
+func TestUpRunsTheBackfillsBetweenMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "muzzapp.db")
+	m, err := migrations.Open(migrations.SQLite, path)
+	assert.NoError(t, err)
+	defer m.Close()
+	db, err := migrations.OpenDB(migrations.SQLite, path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// the matches counter of users is added by migration 3, the backfill of version 2 fails if it runs after it
+	backfiller := migrations.Backfiller{
+		DB:         db,
+		DriverName: migrations.SQLite,
+		Backfills: []migrations.Backfill{{
+			Version: 2,
+			Name:    "users_before_counters",
+			Table:   "users",
+			Key:     "id",
+			Update:  "UPDATE users SET name = name WHERE id > ? AND id <= ? AND NOT EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'matches')",
+		}},
+	}
+
+	assert.NoError(t, migrations.Up(ctx, m, backfiller, 1))
+	_, err = db.Exec("INSERT INTO users (name, gender) VALUES ('user', 'f')")
+	assert.NoError(t, err)
+	assert.NoError(t, migrations.Up(ctx, m, backfiller, 1))
+	states, err := backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.That(t, states[0].Done)
+	assert.Equal(t, int64(1), states[0].Rows)
+
+	assert.NoError(t, migrations.Up(ctx, m, backfiller, -1))
+	assert.That(t, errors.Is(migrations.Up(ctx, m, backfiller, -1), migrate.ErrNoChange))
+	latest, err := migrations.Latest(migrations.SQLite)
+	assert.NoError(t, err)
+	version, _, err := m.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, latest, version)
+
+	// rolling back version 2 forgets its backfill
+	assert.NoError(t, m.Migrate(1))
+	assert.NoError(t, backfiller.Reset(ctx, 1))
+	states, err = backfiller.Status(ctx)
+	assert.NoError(t, err)
+	assert.That(t, !states[0].Started)
+}
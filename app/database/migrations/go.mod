@@ -1,15 +0,0 @@
-module interview_task/database/migrations
-
-go 1.22.0
-
-toolchain go1.22.9
-
-require github.com/go-sql-driver/mysql v1.8.1
-
-require (
-	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/golang-migrate/migrate/v4 v4.18.1 // indirect
-	github.com/hashicorp/errwrap v1.1.0 // indirect
-	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	go.uber.org/atomic v1.7.0 // indirect
-)
@@ -0,0 +1,207 @@
+// Package migrations embeds the schema migrations of the MySQL, PostgreSQL and SQLite storages, one directory
+// per driver, so every binary can migrate its database and check its version without the source tree.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	pgx "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Source returns the embedded migrations of driverName
+func Source(driverName string) (source.Driver, error) {
+	switch driverName {
+	case MySQL, Postgres, SQLite:
+		return iofs.New(files, driverName)
+	default:
+		return nil, fmt.Errorf("no migrations for driver %q", driverName)
+	}
+}
+
+// Latest returns the version of the last embedded migration of driverName
+func Latest(driverName string) (uint, error) {
+	migrations, err := Source(driverName)
+	if err != nil {
+		return 0, err
+	}
+	defer migrations.Close()
+
+	version, err := migrations.First()
+	for err == nil {
+		var next uint
+		if next, err = migrations.Next(version); err == nil {
+			version = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Open connects to dsn, a file path for sqlite, and returns a migrate instance applying the embedded migrations.
+// The connection is its own and is closed with the instance.
+func Open(driverName string, dsn string) (*migrate.Migrate, error) {
+	migrations, err := Source(driverName)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithSource(driverName, dsn, "iofs", migrations)
+}
+
+// OpenWithSource is Open applying the migrations of another source, named sourceName
+func OpenWithSource(driverName string, dsn string, sourceName string, migrations source.Driver) (*migrate.Migrate, error) {
+	db, driver, err := openDriver(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance(sourceName, migrations, driverName, driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func openDriver(driverName string, dsn string) (*sql.DB, database.Driver, error) {
+	db, err := OpenDB(driverName, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	var driver database.Driver
+	switch driverName {
+	case MySQL:
+		driver, err = mysql.WithInstance(db, &mysql.Config{})
+	case Postgres:
+		driver, err = pgx.WithInstance(db, &pgx.Config{})
+	case SQLite:
+		driver, err = migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, driver, nil
+}
+
+// Check returns an error when the schema is dirty or older than latest. A newer schema is only logged, as
+// migrations are meant to stay compatible with the previous release of the server.
+func Check(m *migrate.Migrate, latest uint) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("the schema is not migrated, version %d is required", latest)
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("the schema is dirty at version %d, a migration failed and must be fixed by hand", version)
+	}
+	if version < latest {
+		return fmt.Errorf("the schema is at version %d, version %d is required", version, latest)
+	}
+	if version > latest {
+		log.Printf("WARNING: the schema is at version %d, ahead of version %d of this server", version, latest)
+	}
+	return nil
+}
+
+// Prepare applies the pending migrations when apply is set, then checks that the schema is up to date.
+// MySQL migrations wait up to lockTimeout for an advisory lock, so servers starting together migrate one at a
+// time, and the ones waiting find nothing left to apply.
+func Prepare(ctx context.Context, driverName string, dsn string, apply bool, lockTimeout time.Duration) error {
+	latest, err := Latest(driverName)
+	if err != nil {
+		return err
+	}
+
+	m, err := Open(driverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if apply {
+		if err := up(ctx, driverName, dsn, m, lockTimeout); err != nil {
+			return fmt.Errorf("unable to migrate the schema: %w", err)
+		}
+	}
+	return Check(m, latest)
+}
+
+// up applies the pending migrations and their backfills, which run unthrottled: the backfills of large tables
+// are better run with the migrate command before the servers start
+func up(ctx context.Context, driverName string, dsn string, m *migrate.Migrate, lockTimeout time.Duration) error {
+	if driverName == MySQL {
+		unlock, err := lockMySQL(ctx, dsn, lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	db, err := OpenDB(driverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	backfiller := Backfiller{DB: db, DriverName: driverName, Backfills: Backfills(driverName)}
+
+	if err := Up(ctx, m, backfiller, -1); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// lockMySQL takes the advisory lock of the migrations of the database on a connection of its own
+func lockMySQL(ctx context.Context, dsn string, timeout time.Duration) (func(), error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	release := func() {
+		var released sql.NullBool
+		conn.QueryRowContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.migrations'))").Scan(&released)
+		conn.Close()
+		db.Close()
+	}
+
+	var locked sql.NullBool
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT(DATABASE(), '.migrations'), ?)", int(timeout.Seconds())).Scan(&locked)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if !locked.Valid || !locked.Bool {
+		release()
+		return nil, fmt.Errorf("another server held the migrations lock for more than %s", timeout)
+	}
+	return release, nil
+}
@@ -0,0 +1,292 @@
+package migrations_test
+
+import (
+	"app/database/migrations"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/zeebo/assert"
+)
+
+var (
+	dropTable = regexp.MustCompile(`(?i)\bDROP\s+TABLE\b`)
+	// quoted strings hold the DDL run by the information_schema guards of MySQL
+	quoted = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// created and changed catch the schema changes failing when they run again, the word following them being IF
+	// for the guarded ones. A trigger can also be dropped first to be replaced.
+	created = regexp.MustCompile(`(?i)\bCREATE\s+(?:UNIQUE\s+)?(TABLE|INDEX|TRIGGER|FUNCTION)\s+(\w+)`)
+	changed = regexp.MustCompile(`(?i)\b(?:ADD|DROP)\s+(?:(?:COLUMN|INDEX|KEY|CONSTRAINT|TRIGGER|FUNCTION)\s+)?(\w+)`)
+)
+
+// unguarded returns the statements of an up migration which fail when it runs again, after failing half way or
+// being applied by hand. SQLite cannot guard ADD COLUMN, but it runs every migration in a transaction, so a
+// failed one leaves nothing behind.
+func unguarded(driverName string, body []byte) []string {
+	ddl := quoted.ReplaceAll(body, []byte("''"))
+	var found []string
+	for _, match := range created.FindAllSubmatch(ddl, -1) {
+		kind, name := string(match[1]), string(match[2])
+		if strings.EqualFold(name, "IF") {
+			continue
+		}
+		dropped := regexp.MustCompile(`(?i)\bDROP\s+` + kind + `\s+IF\s+EXISTS\s+` + name + `\b`)
+		if !dropped.Match(ddl) {
+			found = append(found, string(match[0]))
+		}
+	}
+	for _, match := range changed.FindAllSubmatch(ddl, -1) {
+		if strings.EqualFold(string(match[1]), "IF") {
+			continue
+		}
+		if driverName == migrations.SQLite && strings.HasPrefix(strings.ToUpper(string(match[0])), "ADD") {
+			continue
+		}
+		found = append(found, string(match[0]))
+	}
+	return found
+}
+
+func TestSources(t *testing.T) {
+	var latest []uint
+	for _, driverName := range []string{migrations.MySQL, migrations.Postgres, migrations.SQLite} {
+		t.Run(driverName, func(t *testing.T) {
+			files, err := migrations.Source(driverName)
+			assert.NoError(t, err)
+			defer files.Close()
+
+			// versions follow each other from 1, and every migration can be rolled back
+			expected := uint(1)
+			version, err := files.First()
+			for err == nil {
+				assert.Equal(t, expected, version)
+				up, _, readErr := files.ReadUp(version)
+				assert.NoError(t, readErr)
+				body, readErr := io.ReadAll(up)
+				assert.NoError(t, readErr)
+				up.Close()
+				// running an up migration again must never lose data, nor fail on what its first run changed
+				assert.That(t, !dropTable.Match(body))
+				assert.Equal(t, []string(nil), unguarded(driverName, body))
+				down, _, readErr := files.ReadDown(version)
+				assert.NoError(t, readErr)
+				down.Close()
+
+				expected++
+				version, err = files.Next(version)
+			}
+			assert.That(t, errors.Is(err, fs.ErrNotExist))
+
+			last, err := migrations.Latest(driverName)
+			assert.NoError(t, err)
+			assert.Equal(t, expected-1, last)
+			latest = append(latest, last)
+		})
+	}
+
+	// every storage has the same schema version
+	assert.Equal(t, []uint{latest[0], latest[0], latest[0]}, latest)
+
+	_, err := migrations.Source("oracle")
+	assert.Error(t, err)
+}
+
+func TestPrepare(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "muzzapp.db")
+	latest, err := migrations.Latest(migrations.SQLite)
+	assert.NoError(t, err)
+
+	// a database which was never migrated is refused
+	assert.Error(t, migrations.Prepare(ctx, migrations.SQLite, path, false, time.Second))
+
+	assert.NoError(t, migrations.Prepare(ctx, migrations.SQLite, path, true, time.Second))
+	assert.NoError(t, migrations.Prepare(ctx, migrations.SQLite, path, false, time.Second))
+	// migrating an up to date database changes nothing
+	assert.NoError(t, migrations.Prepare(ctx, migrations.SQLite, path, true, time.Second))
+
+	m, err := migrations.Open(migrations.SQLite, path)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	tests := []struct {
+		name    string
+		version int
+		dirty   bool
+		valid   bool
+	}{
+		{name: "up_to_date", version: int(latest), valid: true},
+		{name: "behind", version: int(latest) - 1, valid: false},
+		{name: "ahead", version: int(latest) + 1, valid: true},
+		{name: "not_migrated", version: -1, valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, m.Force(test.version))
+			err := migrations.Check(m, latest)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// snapshots list the queries describing the schema of every driver, one row per column, index, constraint or
+// trigger, leaving out the table of golang-migrate
+var snapshots = map[string][]string{
+	migrations.MySQL: {
+		`SELECT table_name, column_name, ordinal_position, column_type, is_nullable, column_default, extra
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'
+		ORDER BY table_name, ordinal_position`,
+		`SELECT table_name, index_name, seq_in_index, column_name, non_unique
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'
+		ORDER BY table_name, index_name, seq_in_index`,
+		`SELECT table_name, column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL
+		ORDER BY table_name, column_name`,
+		`SELECT trigger_name, event_object_table, action_timing, event_manipulation, action_statement
+		FROM information_schema.triggers
+		WHERE trigger_schema = DATABASE()
+		ORDER BY trigger_name`,
+	},
+	migrations.Postgres: {
+		`SELECT table_name, column_name, ordinal_position, data_type, is_nullable, column_default
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'
+		ORDER BY table_name, column_name`,
+		`SELECT tablename, indexname, indexdef
+		FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'
+		ORDER BY tablename, indexname`,
+		`SELECT conrelid::regclass::text, conname, pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE connamespace = current_schema()::regnamespace
+		ORDER BY 1, 2`,
+		`SELECT trigger_name, event_object_table, action_timing, event_manipulation, action_statement
+		FROM information_schema.triggers
+		WHERE trigger_schema = current_schema()
+		ORDER BY trigger_name, event_manipulation`,
+		`SELECT routine_name, routine_definition
+		FROM information_schema.routines
+		WHERE routine_schema = current_schema()
+		ORDER BY routine_name`,
+	},
+	migrations.SQLite: {
+		`SELECT m.name, c.cid, c.name, c.type, c."notnull", c.dflt_value, c.pk
+		FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.name <> 'schema_migrations'
+		ORDER BY m.name, c.cid`,
+		`SELECT m.name, i.name, i."unique", c.seqno, c.name
+		FROM sqlite_master m, pragma_index_list(m.name) i, pragma_index_info(i.name) c
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.name <> 'schema_migrations'
+		ORDER BY m.name, i.name, c.seqno`,
+		`SELECT m.name, f."from", f."table", f."to"
+		FROM sqlite_master m, pragma_foreign_key_list(m.name) f
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY m.name, f."from"`,
+		`SELECT name, tbl_name, sql
+		FROM sqlite_master
+		WHERE type = 'trigger'
+		ORDER BY name`,
+	},
+}
+
+// TestRoundTrip applies the migrations one at a time, rolls them back one at a time then applies them all again,
+// checking that every rollback restores the schema of the previous version. It runs on SQLite, and on MySQL and
+// PostgreSQL when MIGRATIONS_MYSQL_TEST_DSN or MIGRATIONS_POSTGRES_TEST_DSN point to an empty database, which
+// is left migrated.
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		driverName string
+		sqlDriver  string
+		dsn        string
+	}{
+		{driverName: migrations.MySQL, sqlDriver: "mysql", dsn: os.Getenv("MIGRATIONS_MYSQL_TEST_DSN")},
+		{driverName: migrations.Postgres, sqlDriver: "pgx", dsn: os.Getenv("MIGRATIONS_POSTGRES_TEST_DSN")},
+		{driverName: migrations.SQLite, sqlDriver: "sqlite", dsn: filepath.Join(t.TempDir(), "muzzapp.db")},
+	}
+	for _, test := range tests {
+		t.Run(test.driverName, func(t *testing.T) {
+			if test.dsn == "" {
+				t.Skipf("MIGRATIONS_%s_TEST_DSN is not set", strings.ToUpper(test.driverName))
+			}
+			latest, err := migrations.Latest(test.driverName)
+			assert.NoError(t, err)
+
+			m, err := migrations.Open(test.driverName, test.dsn)
+			assert.NoError(t, err)
+			defer m.Close()
+			db, err := sql.Open(test.sqlDriver, test.dsn)
+			assert.NoError(t, err)
+			defer db.Close()
+
+			_, _, err = m.Version()
+			if !errors.Is(err, migrate.ErrNilVersion) {
+				t.Fatalf("the database must be empty, got version error %v", err)
+			}
+
+			schemas := [][]string{snapshot(t, db, test.driverName)}
+			for version := uint(1); version <= latest; version++ {
+				assert.NoError(t, m.Steps(1))
+				schemas = append(schemas, snapshot(t, db, test.driverName))
+				assert.NotEqual(t, schemas[version-1], schemas[version])
+			}
+
+			for version := latest; version > 0; version-- {
+				assert.NoError(t, m.Steps(-1))
+				assert.Equal(t, schemas[version-1], snapshot(t, db, test.driverName))
+			}
+			_, _, err = m.Version()
+			assert.That(t, errors.Is(err, migrate.ErrNilVersion))
+
+			assert.NoError(t, m.Up())
+			assert.Equal(t, schemas[latest], snapshot(t, db, test.driverName))
+		})
+	}
+}
+
+// snapshot describes the schema of db, one line per row of the snapshot queries of the driver
+func snapshot(t *testing.T, db *sql.DB, driverName string) []string {
+	t.Helper()
+	var lines []string
+	for _, query := range snapshots[driverName] {
+		rows, err := db.Query(query)
+		assert.NoError(t, err)
+		columns, err := rows.Columns()
+		assert.NoError(t, err)
+		for rows.Next() {
+			values := make([]any, len(columns))
+			pointers := make([]any, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			assert.NoError(t, rows.Scan(pointers...))
+			fields := make([]string, len(values))
+			for i, value := range values {
+				if bytes, ok := value.([]byte); ok {
+					value = string(bytes)
+				}
+				fields[i] = fmt.Sprint(value)
+			}
+			lines = append(lines, strings.Join(fields, " | "))
+		}
+		assert.NoError(t, rows.Err())
+		rows.Close()
+	}
+	return lines
+}
@@ -7,9 +7,8 @@ CREATE TABLE IF NOT EXISTS decisions (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     UNIQUE KEY unique_decision (actor_id, recipient_id),
-    FOREIGN KEY (actor_id) REFERENCES Users(id),
-	FOREIGN KEY (recipient_id) REFERENCES Users(id)
+    INDEX idx_actor_recipient (actor_id, recipient_id),
+    INDEX idx_recipient (recipient_id),
+    FOREIGN KEY (actor_id) REFERENCES users (id),
+    FOREIGN KEY (recipient_id) REFERENCES users (id)
 );
-
-CREATE INDEX idx_actor_recipient ON decisions (actor_id, recipient_id);
-CREATE INDEX idx_recipient ON decisions (recipient_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN matches,
+    DROP COLUMN new_likes;
@@ -0,0 +1,40 @@
+-- MySQL commits every ALTER TABLE on its own, so each one only runs when its column is missing and a migration
+-- failing half way can run again.
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'new_likes') = 0,
+    'ALTER TABLE users ADD COLUMN new_likes INT NOT NULL DEFAULT 0 AFTER likes',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'matches') = 0,
+    'ALTER TABLE users ADD COLUMN matches INT NOT NULL DEFAULT 0 AFTER new_likes',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+UPDATE users u
+SET u.likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = u.id
+        AND d.liked = 1),
+    u.new_likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = u.id
+        AND d.liked = 1
+        AND d.is_new = 1),
+    u.matches = (
+        SELECT count(*)
+        FROM decisions d
+        JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+        WHERE d.actor_id = u.id
+        AND d.recipient_id <> u.id
+        AND d.liked = 1
+        AND r.liked = 1);
@@ -0,0 +1,7 @@
+ALTER TABLE users
+    DROP COLUMN last_active_at,
+    DROP COLUMN photo_count,
+    DROP COLUMN bio,
+    DROP COLUMN longitude,
+    DROP COLUMN latitude,
+    DROP COLUMN birth_date;
@@ -0,0 +1,55 @@
+-- MySQL commits every ALTER TABLE on its own, so each one only runs when its column is missing and a migration
+-- failing half way can run again.
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'birth_date') = 0,
+    'ALTER TABLE users ADD COLUMN birth_date DATE NULL AFTER gender',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'latitude') = 0,
+    'ALTER TABLE users ADD COLUMN latitude DECIMAL(8, 6) NULL AFTER birth_date',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'longitude') = 0,
+    'ALTER TABLE users ADD COLUMN longitude DECIMAL(9, 6) NULL AFTER latitude',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'bio') = 0,
+    'ALTER TABLE users ADD COLUMN bio VARCHAR(500) NOT NULL DEFAULT '''' AFTER longitude',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'photo_count') = 0,
+    'ALTER TABLE users ADD COLUMN photo_count INT NOT NULL DEFAULT 0 AFTER bio',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.columns
+    WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'last_active_at') = 0,
+    'ALTER TABLE users ADD COLUMN last_active_at TIMESTAMP NULL AFTER photo_count',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
@@ -0,0 +1,9 @@
+ALTER TABLE decisions
+    ADD INDEX idx_actor_recipient (actor_id, recipient_id),
+    ADD INDEX idx_recipient (recipient_id),
+    ALGORITHM=INPLACE, LOCK=NONE;
+
+ALTER TABLE decisions
+    DROP INDEX idx_recipient_likes,
+    DROP INDEX idx_recipient_new_likes,
+    ALGORITHM=INPLACE, LOCK=NONE;
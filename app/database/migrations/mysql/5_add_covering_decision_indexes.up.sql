@@ -0,0 +1,40 @@
+-- idx_recipient_likes serves the likes pages and the likes counters, idx_recipient_new_likes the new likes ones,
+-- both ordered by id and covering the columns read. idx_actor_recipient duplicated unique_decision, and the foreign
+-- key of recipient_id uses idx_recipient_likes.
+-- MySQL commits every ALTER TABLE on its own, so each one only runs when its index is missing, or still there for
+-- the dropped ones, and a migration failing half way can run again.
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'decisions' AND index_name = 'idx_recipient_likes') = 0,
+    'ALTER TABLE decisions ADD INDEX idx_recipient_likes (recipient_id, liked, id, actor_id, created_at, updated_at), ALGORITHM=INPLACE, LOCK=NONE',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'decisions' AND index_name = 'idx_recipient_new_likes') = 0,
+    'ALTER TABLE decisions ADD INDEX idx_recipient_new_likes (recipient_id, liked, is_new, id, actor_id, created_at, updated_at), ALGORITHM=INPLACE, LOCK=NONE',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'decisions' AND index_name = 'idx_actor_recipient') > 0,
+    'ALTER TABLE decisions DROP INDEX idx_actor_recipient, ALGORITHM=INPLACE, LOCK=NONE',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
+
+SET @ddl = IF(
+    (SELECT count(*) FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'decisions' AND index_name = 'idx_recipient') > 0,
+    'ALTER TABLE decisions DROP INDEX idx_recipient, ALGORITHM=INPLACE, LOCK=NONE',
+    'SELECT 1');
+PREPARE ddl FROM @ddl;
+EXECUTE ddl;
+DEALLOCATE PREPARE ddl;
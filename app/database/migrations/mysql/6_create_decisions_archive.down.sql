@@ -0,0 +1,6 @@
+-- the archived decisions go back to decisions rather than being lost
+INSERT IGNORE INTO decisions (id, actor_id, recipient_id, liked, is_new, created_at, updated_at)
+SELECT id, actor_id, recipient_id, liked, is_new, created_at, updated_at
+FROM decisions_archive;
+
+DROP TABLE IF EXISTS decisions_archive;
@@ -0,0 +1,14 @@
+-- decisions moved out of decisions by the retention job, with their id. The decision of a pair of users is in one
+-- of the two tables, a new decision of the pair moves the archived one back first.
+CREATE TABLE IF NOT EXISTS decisions_archive (
+    id INT PRIMARY KEY,
+    actor_id int,
+    recipient_id int,
+    liked BOOL,
+    is_new BOOL DEFAULT 0,
+    created_at TIMESTAMP NULL,
+    updated_at TIMESTAMP NULL,
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY unique_archived_decision (actor_id, recipient_id),
+    INDEX idx_archived_recipient_likes (recipient_id, liked, id, actor_id, created_at, updated_at)
+);
@@ -0,0 +1,8 @@
+-- likes sent by the users of this shard to the users of other shards, whose decisions live on the shard of their
+-- recipient. They count the matches of the users of the shard, and stay empty unless the decisions are sharded.
+CREATE TABLE IF NOT EXISTS sent_likes (
+    actor_id int NOT NULL,
+    recipient_id int NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (actor_id, recipient_id)
+);
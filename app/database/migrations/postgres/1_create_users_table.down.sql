@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS users;
+DROP FUNCTION IF EXISTS touch_updated_at();
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS decisions (
+    id INTEGER PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    actor_id INTEGER REFERENCES users (id),
+    recipient_id INTEGER REFERENCES users (id),
+    liked BOOLEAN,
+    is_new BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_decision UNIQUE (actor_id, recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recipient ON decisions (recipient_id);
+
+CREATE OR REPLACE TRIGGER decisions_touch_updated_at
+    BEFORE UPDATE ON decisions
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
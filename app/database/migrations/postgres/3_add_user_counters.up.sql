@@ -0,0 +1,24 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS new_likes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS matches INTEGER NOT NULL DEFAULT 0;
+
+UPDATE users u
+SET likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = u.id
+        AND d.liked),
+    new_likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = u.id
+        AND d.liked
+        AND d.is_new),
+    matches = (
+        SELECT count(*)
+        FROM decisions d
+        JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+        WHERE d.actor_id = u.id
+        AND d.recipient_id <> u.id
+        AND d.liked
+        AND r.liked);
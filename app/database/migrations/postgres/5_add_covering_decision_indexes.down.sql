@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_recipient_new_likes;
+DROP INDEX IF EXISTS idx_recipient_likes;
@@ -0,0 +1,8 @@
+-- partial indexes of the likes and of the new likes, ordered by id and covering the columns of the likes pages
+CREATE INDEX IF NOT EXISTS idx_recipient_likes ON decisions (recipient_id, id)
+    INCLUDE (actor_id, liked, created_at, updated_at)
+    WHERE liked;
+
+CREATE INDEX IF NOT EXISTS idx_recipient_new_likes ON decisions (recipient_id, id)
+    INCLUDE (actor_id, liked, created_at, updated_at)
+    WHERE liked AND is_new;
@@ -0,0 +1,7 @@
+-- the archived decisions go back to decisions rather than being lost
+INSERT INTO decisions (id, actor_id, recipient_id, liked, is_new, created_at, updated_at)
+SELECT id, actor_id, recipient_id, liked, is_new, created_at, updated_at
+FROM decisions_archive
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS decisions_archive;
@@ -0,0 +1,17 @@
+-- decisions moved out of decisions by the retention job, with their id. The decision of a pair of users is in one
+-- of the two tables, a new decision of the pair moves the archived one back first.
+CREATE TABLE IF NOT EXISTS decisions_archive (
+    id INTEGER PRIMARY KEY,
+    actor_id INTEGER,
+    recipient_id INTEGER,
+    liked BOOLEAN,
+    is_new BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_archived_decision UNIQUE (actor_id, recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_archived_recipient_likes ON decisions_archive (recipient_id, id)
+    INCLUDE (actor_id, liked, created_at, updated_at)
+    WHERE liked;
@@ -0,0 +1,8 @@
+-- likes sent by the users of this shard to the users of other shards, whose decisions live on the shard of their
+-- recipient. Only MySQL storages are sharded, the table stays empty.
+CREATE TABLE IF NOT EXISTS sent_likes (
+    actor_id INTEGER NOT NULL,
+    recipient_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (actor_id, recipient_id)
+);
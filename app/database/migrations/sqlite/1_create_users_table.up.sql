@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    likes INTEGER NOT NULL DEFAULT 0,
+    gender CHAR(1),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_active BOOLEAN NOT NULL DEFAULT 0
+);
+
+-- mirrors MySQL's ON UPDATE CURRENT_TIMESTAMP: updated_at only moves when the row actually changes
+CREATE TRIGGER IF NOT EXISTS users_touch_updated_at
+AFTER UPDATE ON users
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND (
+    OLD.name IS NOT NEW.name
+    OR OLD.likes IS NOT NEW.likes
+    OR OLD.gender IS NOT NEW.gender
+    OR OLD.is_active IS NOT NEW.is_active
+)
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS decisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER REFERENCES users (id),
+    recipient_id INTEGER REFERENCES users (id),
+    liked BOOLEAN,
+    is_new BOOLEAN NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_decision UNIQUE (actor_id, recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recipient ON decisions (recipient_id);
+
+CREATE TRIGGER IF NOT EXISTS decisions_touch_updated_at
+AFTER UPDATE ON decisions
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND OLD.liked IS NOT NEW.liked
+BEGIN
+    UPDATE decisions SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
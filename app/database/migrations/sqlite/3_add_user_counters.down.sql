@@ -0,0 +1,16 @@
+DROP TRIGGER IF EXISTS users_touch_updated_at;
+CREATE TRIGGER users_touch_updated_at
+AFTER UPDATE ON users
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND (
+    OLD.name IS NOT NEW.name
+    OR OLD.likes IS NOT NEW.likes
+    OR OLD.gender IS NOT NEW.gender
+    OR OLD.is_active IS NOT NEW.is_active
+)
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+ALTER TABLE users DROP COLUMN matches;
+ALTER TABLE users DROP COLUMN new_likes;
@@ -0,0 +1,39 @@
+ALTER TABLE users ADD COLUMN new_likes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN matches INTEGER NOT NULL DEFAULT 0;
+
+DROP TRIGGER IF EXISTS users_touch_updated_at;
+CREATE TRIGGER users_touch_updated_at
+AFTER UPDATE ON users
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND (
+    OLD.name IS NOT NEW.name
+    OR OLD.likes IS NOT NEW.likes
+    OR OLD.new_likes IS NOT NEW.new_likes
+    OR OLD.matches IS NOT NEW.matches
+    OR OLD.gender IS NOT NEW.gender
+    OR OLD.is_active IS NOT NEW.is_active
+)
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+UPDATE users
+SET likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = users.id
+        AND d.liked),
+    new_likes = (
+        SELECT count(*)
+        FROM decisions d
+        WHERE d.recipient_id = users.id
+        AND d.liked
+        AND d.is_new),
+    matches = (
+        SELECT count(*)
+        FROM decisions d
+        JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+        WHERE d.actor_id = users.id
+        AND d.recipient_id <> users.id
+        AND d.liked
+        AND r.liked);
@@ -0,0 +1,22 @@
+DROP TRIGGER IF EXISTS users_touch_updated_at;
+CREATE TRIGGER users_touch_updated_at
+AFTER UPDATE ON users
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND (
+    OLD.name IS NOT NEW.name
+    OR OLD.likes IS NOT NEW.likes
+    OR OLD.new_likes IS NOT NEW.new_likes
+    OR OLD.matches IS NOT NEW.matches
+    OR OLD.gender IS NOT NEW.gender
+    OR OLD.is_active IS NOT NEW.is_active
+)
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+ALTER TABLE users DROP COLUMN last_active_at;
+ALTER TABLE users DROP COLUMN photo_count;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN longitude;
+ALTER TABLE users DROP COLUMN latitude;
+ALTER TABLE users DROP COLUMN birth_date;
@@ -0,0 +1,28 @@
+ALTER TABLE users ADD COLUMN birth_date TEXT;
+ALTER TABLE users ADD COLUMN latitude REAL;
+ALTER TABLE users ADD COLUMN longitude REAL;
+ALTER TABLE users ADD COLUMN bio VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN photo_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN last_active_at TIMESTAMP;
+
+DROP TRIGGER IF EXISTS users_touch_updated_at;
+CREATE TRIGGER users_touch_updated_at
+AFTER UPDATE ON users
+FOR EACH ROW
+WHEN OLD.updated_at IS NEW.updated_at AND (
+    OLD.name IS NOT NEW.name
+    OR OLD.likes IS NOT NEW.likes
+    OR OLD.new_likes IS NOT NEW.new_likes
+    OR OLD.matches IS NOT NEW.matches
+    OR OLD.gender IS NOT NEW.gender
+    OR OLD.is_active IS NOT NEW.is_active
+    OR OLD.birth_date IS NOT NEW.birth_date
+    OR OLD.latitude IS NOT NEW.latitude
+    OR OLD.longitude IS NOT NEW.longitude
+    OR OLD.bio IS NOT NEW.bio
+    OR OLD.photo_count IS NOT NEW.photo_count
+    OR OLD.last_active_at IS NOT NEW.last_active_at
+)
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
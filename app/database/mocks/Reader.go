@@ -74,6 +74,66 @@ func (_m *Reader) FindNewLikesByRecipientIdPaginated(ctx context.Context, recipi
 	return r0, r1
 }
 
+// FindUsers provides a mock function with given fields: ctx, filter
+func (_m *Reader) FindUsers(ctx context.Context, filter database.UserFilter) ([]database.UserModel, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FindUsers")
+	}
+
+	var r0 []database.UserModel
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, database.UserFilter) ([]database.UserModel, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, database.UserFilter) []database.UserModel); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]database.UserModel)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, database.UserFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindUsersByIds provides a mock function with given fields: ctx, userIds
+func (_m *Reader) FindUsersByIds(ctx context.Context, userIds []string) ([]database.UserModel, error) {
+	ret := _m.Called(ctx, userIds)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FindUsersByIds")
+	}
+
+	var r0 []database.UserModel
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) ([]database.UserModel, error)); ok {
+		return rf(ctx, userIds)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []database.UserModel); ok {
+		r0 = rf(ctx, userIds)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]database.UserModel)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, userIds)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetIsMatch provides a mock function with given fields: ctx, ActorId, RecipientId
 func (_m *Reader) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
 	ret := _m.Called(ctx, ActorId, RecipientId)
@@ -50,24 +50,6 @@ func (_m *Writer) UpdateLikesAsViewed(ctx context.Context, RecipientId string, l
 	return r0
 }
 
-// UpdateUserTotalLikes provides a mock function with given fields: ctx, RecipientId
-func (_m *Writer) UpdateUserTotalLikes(ctx context.Context, RecipientId string) error {
-	ret := _m.Called(ctx, RecipientId)
-
-	if len(ret) == 0 {
-		panic("no return value specified for UpdateUserTotalLikes")
-	}
-
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, RecipientId)
-	} else {
-		r0 = ret.Error(0)
-	}
-
-	return r0
-}
-
 // NewWriter creates a new instance of Writer. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewWriter(t interface {
@@ -0,0 +1,151 @@
+package database_test
+
+import (
+	"app/database"
+	"app/database/storagetest"
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+// TestConformance runs the storage suite against a migrated MySQL database when MYSQL_TEST_DSN is set,
+// e.g. MYSQL_TEST_DSN="app:password@tcp(127.0.0.1:33306)/muzzapp_test". The tables are emptied before every test.
+func TestConformance(t *testing.T) {
+	dsn := testDSN(t, "MYSQL_TEST_DSN")
+
+	db, err := sql.Open("mysql", dsn)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	replica, err := sql.Open("mysql", dsn)
+	assert.NoError(t, err)
+	t.Cleanup(func() { replica.Close() })
+	// the replica is the primary itself, so the suite covers the queries routed to replicas without lag
+	pool := database.NewReplicaPool(db, []*sql.DB{replica}, database.ReplicaOptions{
+		Probe: func(ctx context.Context, db *sql.DB) (time.Duration, error) { return 0, nil },
+	})
+	pool.Check(context.Background())
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		truncate(t, db)
+		reader, err := database.NewDatabaseReaderWithReplicas(pool, database.QueryOptions{})
+		assert.NoError(t, err)
+		writer, err := database.NewDatabaseWriter(db, database.QueryOptions{})
+		assert.NoError(t, err)
+		bulk, err := database.NewDatabaseBulkWriter(db, database.QueryOptions{})
+		assert.NoError(t, err)
+		return storagetest.Backend{
+			Reader:     reader,
+			Writer:     writer,
+			Reconciler: writer,
+			Archiver:   writer,
+			AddUser: func(ctx context.Context, user database.UserModel) (string, error) {
+				result, err := db.ExecContext(ctx,
+					`INSERT INTO users (name, likes, new_likes, matches, gender, is_active, birth_date, latitude, longitude, bio, photo_count, last_active_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+					append([]any{user.Name, user.Likes, user.NewLikes, user.Matches, user.Gender, user.IsAactive}, user.ProfileArgs()...)...,
+				)
+				if err != nil {
+					return "", err
+				}
+				id, err := result.LastInsertId()
+				return strconv.FormatInt(id, 10), err
+			},
+			Bulk:     bulk,
+			Importer: bulk,
+			Exporter: bulk,
+		}
+	})
+}
+
+func truncate(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, statement := range []string{
+		"SET FOREIGN_KEY_CHECKS = 0",
+		"TRUNCATE TABLE decisions",
+		"TRUNCATE TABLE decisions_archive",
+		"TRUNCATE TABLE sent_likes",
+		"TRUNCATE TABLE users",
+		"SET FOREIGN_KEY_CHECKS = 1",
+	} {
+		_, err := conn.ExecContext(ctx, statement)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	dsn := testDSN(t, "MYSQL_TEST_DSN")
+
+	db, err := sql.Open("mysql", dsn)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	reader, err := database.NewDatabaseReader(db, database.QueryOptions{SlowQueryThreshold: time.Nanosecond})
+	assert.NoError(t, err)
+	_, err = reader.GetUserById(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.That(t, strings.Contains(logs.String(), "Slow query took"))
+	assert.That(t, strings.Contains(logs.String(), "FROM users WHERE id = ? AND is_active = 1;"))
+}
+
+// TestQueriesBuiltAtRunTimeAreNotPrepared reads users with a growing number of IDs and of conditions, which must not
+// add prepared statements to the fixed queries of the reader
+func TestQueriesBuiltAtRunTimeAreNotPrepared(t *testing.T) {
+	dsn := testDSN(t, "MYSQL_TEST_DSN")
+
+	db, err := sql.Open("mysql", dsn)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	reader, err := database.NewDatabaseReader(db, database.QueryOptions{})
+	assert.NoError(t, err)
+	prepared := reader.Prepared()
+
+	ids := []string{}
+	for id := 1; id <= 40; id++ {
+		ids = append(ids, strconv.Itoa(id))
+		_, err := reader.FindUsersByIds(ctx, ids)
+		assert.NoError(t, err)
+	}
+	for _, filter := range []database.UserFilter{
+		{},
+		{Gender: "f"},
+		{Gender: "m", BornFrom: "1990-01-01"},
+		{BornFrom: "1990-01-01", BornTo: "2000-12-31", ActiveSince: 1700000000},
+	} {
+		_, err := reader.FindUsers(ctx, filter)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, prepared, reader.Prepared())
+}
+
+// testDSN returns the connection string held by the environment variable key. Without it the test is skipped, or
+// fails when CI is set, so that the continuous integration cannot pass without running the MySQL tests.
+func testDSN(t *testing.T, key string) string {
+	t.Helper()
+	dsn := os.Getenv(key)
+	if dsn == "" && os.Getenv("CI") != "" {
+		t.Fatalf("%s is not set, the MySQL tests must run on CI", key)
+	}
+	if dsn == "" {
+		t.Skipf("%s is not set", key)
+	}
+	return dsn
+}
@@ -0,0 +1,212 @@
+package database_test
+
+import (
+	"app/database"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+// TestQueryPlans runs EXPLAIN on every statement of the reader and the writer against a seeded MySQL database when
+// MYSQL_TEST_DSN is set, and fails when a statement reads a whole table or index, sorts rows outside of an index, or
+// reads a table without the index expected for it. An insert reads no rows, so the index expected for the table it
+// writes must be unique, as it finds the duplicates. The temporary tables of unions are left out, their branches
+// are limited to a page.
+func TestQueryPlans(t *testing.T) {
+	dsn := testDSN(t, "MYSQL_TEST_DSN")
+
+	db, err := sql.Open("mysql", dsn)
+	assert.NoError(t, err)
+	defer db.Close()
+	if !describesPlans(db) {
+		t.Skip("the server does not describe its plans like MySQL")
+	}
+	truncate(t, db)
+	seedPlans(t, db)
+
+	// a filter using every condition
+	filter := database.UserFilter{
+		Gender:      "f",
+		BornFrom:    "1990-01-01",
+		BornTo:      "1999-12-31",
+		Area:        &database.Area{South: 40, West: -10, North: 60, East: 10},
+		ActiveSince: 1700000000,
+	}
+	conditions, filterArgs := filter.Conditions("FROM_UNIXTIME(?)")
+
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		// indexes are the keys expected by table
+		indexes map[string]string
+	}{
+		{name: "likes", query: database.ReadDecisionsWithLikeByRecipientIdPaginated, args: []any{7, 30, 7, 30, 10, 20}},
+		{name: "new_likes", query: database.ReadNewDecisionsWithLikeByRecipientIdPaginated, args: []any{7, 10, 20}},
+		{name: "user", query: database.ReadActiveUsersById, args: []any{7}},
+		{name: "users_by_ids", query: database.ReadActiveUsersByIds(4), args: []any{3, 7, 11, 11}},
+		{name: "users", query: database.ReadActiveUsersFiltered(""), args: []any{100, 10}},
+		{name: "filtered_users", query: database.ReadActiveUsersFiltered(conditions), args: append(append([]any{100}, filterArgs...), 10)},
+		{name: "match", query: database.ReadGetMatchByActorIdAndRecipientId, args: []any{7, 8, 8, 7, 7, 8, 8, 7}},
+		{name: "lock_users", query: database.LockUsersQuery, args: []any{7, 8}},
+		{name: "restore_decisions", query: database.RestoreDecisionsQuery, args: []any{7, 8, 8, 7}},
+		{name: "delete_restored_decisions", query: database.DeleteRestoredDecisionsQuery, args: []any{7, 8, 8, 7}},
+		{name: "decision_state", query: database.ReadDecisionStateQuery, args: []any{7, 8}},
+		{name: "reverse_like", query: database.ReadReverseLikeQuery, args: []any{8, 7}},
+		{name: "recipient_counters", query: database.UpdateRecipientCountersQuery, args: []any{1, 1, 8}},
+		{name: "matches", query: database.UpdateMatchesQuery, args: []any{1, 7, 8}},
+		{name: "viewed_like", query: database.UpdateDecisionLikeQuery, args: []any{8, 42}},
+		{name: "new_likes_counter", query: database.UpdateNewLikesQuery, args: []any{1, 8}},
+		{name: "lock_users_batch", query: database.LockUsersBatchQuery, args: []any{100, 50}},
+		{name: "reconcile_counters", query: database.ReconcileCountersQuery, args: []any{100, 150}},
+		{name: "decisions_batch", query: database.ReadDecisionsBatchQuery, args: []any{1000, 500}},
+		{name: "lock_archived_users", query: database.LockArchivedUsersQuery, args: []any{1000, 1500, 1000, 1500}},
+		{name: "archive_decisions", query: database.ArchiveDecisionsQuery, args: []any{1000, 1500, 1700000000, 1700000000}},
+		{name: "delete_archived_decisions", query: database.DeleteArchivedDecisionsQuery, args: []any{1000, 1500}},
+		{name: "insert_or_update_decision", query: database.InsertOrUpdateDecisionQuery, args: []any{7, 15, 1, 1},
+			indexes: map[string]string{"decisions": "unique_decision"}},
+		{name: "like", query: database.ReadLikeQuery, args: []any{7, 15, 7, 15},
+			indexes: map[string]string{"decisions": "unique_decision", "decisions_archive": "unique_archived_decision"}},
+		{name: "sent_like", query: database.ReadSentLikeQuery, args: []any{7, 15},
+			indexes: map[string]string{"sent_likes": "PRIMARY"}},
+		{name: "insert_sent_like", query: database.InsertSentLikeQuery, args: []any{7, 15},
+			indexes: map[string]string{"sent_likes": "PRIMARY"}},
+		{name: "delete_sent_like", query: database.DeleteSentLikeQuery, args: []any{7, 15},
+			indexes: map[string]string{"sent_likes": "PRIMARY"}},
+		{name: "likes_batch", query: database.ReadLikesBatchQuery, args: []any{100, 150, 100, 150},
+			indexes: map[string]string{"decisions": "unique_decision", "decisions_archive": "unique_archived_decision"}},
+		{name: "sent_likes_batch", query: database.ReadSentLikesBatchQuery, args: []any{100, 150},
+			indexes: map[string]string{"sent_likes": "PRIMARY"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			read := map[string]bool{}
+			for _, row := range explain(t, db, test.query, test.args) {
+				if strings.HasPrefix(row["table"], "<") {
+					continue
+				}
+				index, expected := test.indexes[row["table"]]
+				read[row["table"]] = true
+				if row["select_type"] == "INSERT" {
+					if expected && !isUnique(t, db, row["table"], index) {
+						t.Errorf("%s has no unique index %s finding the duplicates of the insert", row["table"], index)
+					}
+					continue
+				}
+				if expected && row["key"] != index {
+					t.Errorf("%s is read with %q instead of %s: %v", row["table"], row["key"], index, row)
+				}
+				if row["type"] == "ALL" || row["type"] == "index" {
+					t.Errorf("full scan of %s: %v", row["table"], row)
+				}
+				if strings.Contains(row["Extra"], "Using filesort") {
+					t.Errorf("filesort of %s: %v", row["table"], row)
+				}
+			}
+			for table := range test.indexes {
+				if !read[table] {
+					t.Errorf("%s is not in the plan", table)
+				}
+			}
+		})
+	}
+}
+
+// seedPlans adds enough users and decisions for the optimizer to prefer the indexes, then refreshes its statistics
+func seedPlans(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	bulk, err := database.NewDatabaseBulkWriter(db, database.QueryOptions{})
+	assert.NoError(t, err)
+
+	const users = 400
+	var batch []database.UserModel
+	for id := 1; id <= users; id++ {
+		batch = append(batch, database.UserModel{Id: fmt.Sprint(id), Name: fmt.Sprintf("user %d", id), Gender: "f", IsAactive: id%10 != 0})
+	}
+	assert.NoError(t, bulk.InsertUsers(ctx, batch))
+
+	var decisions []database.BulkDecision
+	for actor := uint(1); actor <= users; actor++ {
+		for step := uint(1); step <= 20; step++ {
+			recipient := (actor+step*7)%users + 1
+			liked := (actor+step)%3 == 0
+			decisions = append(decisions, database.BulkDecision{ActorId: actor, RecipientId: recipient, Liked: liked, IsNew: liked && step%2 == 0})
+		}
+		if len(decisions) >= 2000 {
+			assert.NoError(t, bulk.InsertDecisions(ctx, decisions))
+			decisions = decisions[:0]
+		}
+	}
+	assert.NoError(t, bulk.InsertDecisions(ctx, decisions))
+
+	// some of the decisions are archived, or sent to the users of another shard, as a lookup of a missing row is
+	// not described with its index
+	for _, statement := range []string{
+		`INSERT INTO decisions_archive (id, actor_id, recipient_id, liked, is_new, created_at, updated_at)
+			SELECT id, actor_id, recipient_id, liked, 0, created_at, updated_at FROM decisions WHERE actor_id <= 20`,
+		"INSERT INTO sent_likes (actor_id, recipient_id) SELECT actor_id, recipient_id FROM decisions WHERE actor_id <= 20 AND liked = 1",
+	} {
+		_, err := db.ExecContext(ctx, statement)
+		assert.NoError(t, err)
+	}
+
+	rows, err := db.Query("ANALYZE TABLE users, decisions, decisions_archive, sent_likes")
+	assert.NoError(t, err)
+	rows.Close()
+}
+
+// isUnique tells whether index is a unique index of table
+func isUnique(t *testing.T, db *sql.DB, table string, index string) bool {
+	var columns int
+	err := db.QueryRow(`SELECT count(*)
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE()
+		AND table_name = ?
+		AND index_name = ?
+		AND non_unique = 0`, table, index).Scan(&columns)
+	assert.NoError(t, err)
+	return columns > 0
+}
+
+// describesPlans tells whether the server explains a statement with the rows of MySQL
+func describesPlans(db *sql.DB) bool {
+	rows, err := db.Query("EXPLAIN SELECT 1")
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+	var id, selectType, table, partitions, kind, possibleKeys, key, keyLength, ref, count, filtered, extra sql.NullString
+	return rows.Next() &&
+		rows.Scan(&id, &selectType, &table, &partitions, &kind, &possibleKeys, &key, &keyLength, &ref, &count, &filtered, &extra) == nil &&
+		extra.String == "No tables used"
+}
+
+// explain returns the rows of the plan of query, by column
+func explain(t *testing.T, db *sql.DB, query string, args []any) []map[string]string {
+	rows, err := db.Query("EXPLAIN "+query, args...)
+	assert.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	assert.NoError(t, err)
+
+	var plan []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		assert.NoError(t, rows.Scan(pointers...))
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		plan = append(plan, row)
+	}
+	assert.NoError(t, rows.Err())
+	return plan
+}
@@ -0,0 +1,272 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"app/database"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type DatabaseReader struct {
+	db *sql.DB
+}
+
+func NewDatabaseReader(db *sql.DB) DatabaseReader {
+	return DatabaseReader{db}
+}
+
+// readDecisionsWithLikeByRecipientIdPaginated merges the likes of decisions and of the archive, each read up to
+// the end of the page
+const readDecisionsWithLikeByRecipientIdPaginated = `
+(SELECT
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	EXTRACT(EPOCH FROM created_at)::BIGINT AS created_at,
+	EXTRACT(EPOCH FROM updated_at)::BIGINT AS updated_at
+FROM decisions
+WHERE recipient_id = $1
+AND liked
+ORDER BY id
+LIMIT $2::BIGINT + $3::BIGINT)
+UNION ALL
+(SELECT
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	EXTRACT(EPOCH FROM created_at)::BIGINT AS created_at,
+	EXTRACT(EPOCH FROM updated_at)::BIGINT AS updated_at
+FROM decisions_archive
+WHERE recipient_id = $1
+AND liked
+ORDER BY id
+LIMIT $2::BIGINT + $3::BIGINT)
+ORDER BY id
+LIMIT $2
+OFFSET $3;
+`
+
+const readNewDecisionsWithLikeByRecipientIdPaginated = `
+SELECT
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	EXTRACT(EPOCH FROM created_at)::BIGINT AS created_at,
+	EXTRACT(EPOCH FROM updated_at)::BIGINT AS updated_at
+FROM decisions
+WHERE recipient_id = $1
+AND liked
+AND is_new
+ORDER BY id
+LIMIT $2
+OFFSET $3;
+`
+
+// userColumns are the columns of a user, in the order of database.UserRow
+const userColumns = `
+	id,
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	EXTRACT(EPOCH FROM created_at)::BIGINT AS created_at,
+	EXTRACT(EPOCH FROM updated_at)::BIGINT AS updated_at,
+	is_active,
+	to_char(birth_date, 'YYYY-MM-DD') AS birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	COALESCE(EXTRACT(EPOCH FROM last_active_at)::BIGINT, 0) AS last_active_at`
+
+const readActiveUsersById = `
+SELECT` + userColumns + `
+FROM users
+WHERE id = $1
+AND is_active;
+`
+
+// readGetMatchByActorIdAndRecipientId counts the likes between two users in both directions, archived or not
+const readGetMatchByActorIdAndRecipientId = `
+SELECT (
+	SELECT count(*)
+	FROM decisions
+	WHERE liked
+	AND (
+		(actor_id = $1 AND recipient_id = $2)
+		OR (actor_id = $2 AND recipient_id = $1)
+	)
+) + (
+	SELECT count(*)
+	FROM decisions_archive
+	WHERE liked
+	AND (
+		(actor_id = $1 AND recipient_id = $2)
+		OR (actor_id = $2 AND recipient_id = $1)
+	)
+);
+`
+
+// parseId converts a user ID the way MySQL compares a string with an INT column: IDs that are not numbers never match
+func parseId(id string) (int64, bool) {
+	parsed, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || parsed <= 0 {
+		return 0, false
+	}
+	return parsed, true
+}
+
+// numbered replaces the ? placeholders of query with the $1, $2... ones of postgres
+func numbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// FindLikesByRecipientIdPaginated finds all likes on decisions table for a given recipient user ID with pagination
+func (r DatabaseReader) FindLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]database.DecisionModel, error) {
+	return r.findDecisions(ctx, readDecisionsWithLikeByRecipientIdPaginated, recipientId, page)
+}
+
+// FindNewLikesByRecipientIdPaginated finds all new/unchecked likes on decisions table for a given recipient user ID with pagination
+func (r DatabaseReader) FindNewLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]database.DecisionModel, error) {
+	return r.findDecisions(ctx, readNewDecisionsWithLikeByRecipientIdPaginated, recipientId, page)
+}
+
+func (r DatabaseReader) findDecisions(ctx context.Context, query string, recipientId string, page int) ([]database.DecisionModel, error) {
+	offset := (page - 1) * database.Limit
+	// an invalid recipient ID parses to 0, which matches no rows but still lets postgres reject a negative offset
+	recipient, _ := parseId(recipientId)
+
+	rows, err := r.db.QueryContext(ctx, query, recipient, database.Limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []database.DecisionModel
+	for rows.Next() {
+		var decision database.DecisionModel
+		err = rows.Scan(
+			&decision.Id,
+			&decision.ActorId,
+			&decision.RecipientId,
+			&decision.Liked,
+			&decision.Created_at,
+			&decision.Updated_at,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, rows.Err()
+}
+
+func (r DatabaseReader) GetLimit() int {
+	return database.Limit
+}
+
+// GetUserById get user information for a given user ID
+func (r DatabaseReader) GetUserById(ctx context.Context, userId string) (database.UserModel, error) {
+	id, ok := parseId(userId)
+	if !ok {
+		return database.UserModel{}, nil
+	}
+
+	var row database.UserRow
+	err := r.db.QueryRowContext(ctx, readActiveUsersById, id).Scan(row.Dest()...)
+	if err == sql.ErrNoRows {
+		return database.UserModel{}, nil
+	}
+	if err != nil {
+		return database.UserModel{}, err
+	}
+
+	return row.User(), nil
+}
+
+func (r DatabaseReader) FindUsersByIds(ctx context.Context, userIds []string) ([]database.UserModel, error) {
+	if len(userIds) > database.MaxUsers {
+		return nil, fmt.Errorf("unable to read %d users, at most %d can be read at once", len(userIds), database.MaxUsers)
+	}
+	var ids []any
+	for _, userId := range userIds {
+		if id, ok := parseId(userId); ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := numbered(`
+SELECT` + userColumns + `
+FROM users
+WHERE id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + `)
+AND is_active
+ORDER BY id;
+`)
+	return database.ScanUsers(r.db.QueryContext(ctx, query, ids...))
+}
+
+func (r DatabaseReader) FindUsers(ctx context.Context, filter database.UserFilter) ([]database.UserModel, error) {
+	limit, err := filter.PageSize()
+	if err != nil {
+		return nil, err
+	}
+	conditions, args := filter.Conditions("to_timestamp(?)")
+	query := numbered(`
+SELECT` + userColumns + `
+FROM users
+WHERE is_active
+AND id > ?` + conditions + `
+ORDER BY id
+LIMIT ?;
+`)
+	args = append(append([]any{int64(filter.AfterId)}, args...), limit)
+	return database.ScanUsers(r.db.QueryContext(ctx, query, args...))
+}
+
+// GetIsMatch check for match on decisions between actor user id and recipient user id and return if match is true or false
+func (r DatabaseReader) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
+	actor, ok := parseId(ActorId)
+	if !ok {
+		return false, nil
+	}
+	recipient, ok := parseId(RecipientId)
+	if !ok {
+		return false, nil
+	}
+
+	var likes int
+	if err := r.db.QueryRowContext(ctx, readGetMatchByActorIdAndRecipientId, actor, recipient).Scan(&likes); err != nil {
+		return false, err
+	}
+
+	return likes == 2, nil
+}
+
+// Interface guards
+var (
+	_ database.Reader = (*DatabaseReader)(nil)
+)
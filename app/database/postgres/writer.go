@@ -0,0 +1,399 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"app/database"
+)
+
+type DatabaseWriter struct {
+	db    *sql.DB
+	retry database.RetryPolicy
+}
+
+// NewDatabaseWriter creates a DatabaseWriter running its transactions again by retry when they fail with a
+// transient error
+func NewDatabaseWriter(db *sql.DB, retry database.RetryPolicy) DatabaseWriter {
+	return DatabaseWriter{db: db, retry: retry}
+}
+
+// lockUsersQuery locks the users of a decision in ID order, so decisions between the same users and the
+// counter reconciliation are serialised without deadlocking each other
+const lockUsersQuery = `
+SELECT id
+FROM users
+WHERE id IN ($1, $2)
+ORDER BY id
+FOR UPDATE;
+`
+
+// restoreDecisionsQuery moves the archived decisions between two users back to decisions, in both directions, so
+// a new decision finds the previous one and the like it answers
+const restoreDecisionsQuery = `
+WITH restored AS (
+	DELETE FROM decisions_archive
+	WHERE (actor_id = $1 AND recipient_id = $2)
+	OR (actor_id = $2 AND recipient_id = $1)
+	RETURNING
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		created_at,
+		updated_at
+)
+INSERT INTO decisions (
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+)
+SELECT *
+FROM restored
+ON CONFLICT DO NOTHING;
+`
+
+const readDecisionStateQuery = `
+SELECT
+	liked,
+	is_new
+FROM decisions
+WHERE actor_id = $1
+AND recipient_id = $2
+FOR UPDATE;
+`
+
+const readReverseLikeQuery = `
+SELECT count(*)
+FROM decisions
+WHERE actor_id = $1
+AND recipient_id = $2
+AND actor_id <> recipient_id
+AND liked;
+`
+
+// insertOrUpdateDecisionQuery flags likes as new, and a decision as new again when it turns into a like
+const insertOrUpdateDecisionQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new
+) VALUES ($1, $2, $3, $3)
+ON CONFLICT (actor_id, recipient_id) DO UPDATE SET
+	is_new = CASE WHEN decisions.liked = EXCLUDED.liked THEN decisions.is_new ELSE EXCLUDED.is_new END,
+	liked = EXCLUDED.liked;
+`
+
+const updateRecipientCountersQuery = `
+UPDATE users
+SET likes = likes + $1,
+	new_likes = new_likes + $2
+WHERE id = $3;
+`
+
+const updateMatchesQuery = `
+UPDATE users
+SET matches = matches + $1
+WHERE id IN ($2, $3);
+`
+
+const updateDecisionLikesQuery = `
+UPDATE decisions
+SET is_new = FALSE
+WHERE recipient_id = $1
+AND id = ANY($2)
+AND liked
+AND is_new;
+`
+
+const updateNewLikesQuery = `
+UPDATE users
+SET new_likes = new_likes - $1
+WHERE id = $2;
+`
+
+const lockUsersBatchQuery = `
+SELECT id
+FROM users
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+FOR UPDATE;
+`
+
+// reconcileCountersQuery recomputes the counters with the expressions of the MySQL reconciliation, and only changes
+// the drifted rows
+const reconcileCountersQuery = `
+UPDATE users
+SET likes = expected.likes,
+	new_likes = expected.new_likes,
+	matches = expected.matches
+FROM (
+	SELECT
+		u.id,
+		` + database.LikesOfUser + ` AS likes,
+		` + database.NewLikesOfUser + ` AS new_likes,
+		` + database.MatchesOfUser + ` AS matches
+	FROM users u
+	WHERE u.id > $1
+	AND u.id <= $2
+) expected
+WHERE users.id = expected.id
+AND (users.likes, users.new_likes, users.matches) IS DISTINCT FROM (expected.likes, expected.new_likes, expected.matches);
+`
+
+// inTx runs fn in a transaction, committed when fn succeeds. The whole transaction runs again when it fails with
+// a transient error, so fn must not have effects outside of tx.
+func inTx(ctx context.Context, db *sql.DB, retry database.RetryPolicy, fn func(tx *sql.Tx) error) error {
+	return retry.Do(ctx, func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+func (w DatabaseWriter) InsertOrUpdateDecision(ctx context.Context, entry database.PutDecisionEntry) error {
+	actor, ok := parseId(entry.ActorId)
+	if !ok {
+		return fmt.Errorf("unable to insert or update decision: invalid actor id %q", entry.ActorId)
+	}
+	recipient, ok := parseId(entry.RecipientId)
+	if !ok {
+		return fmt.Errorf("unable to insert or update decision: invalid recipient id %q", entry.RecipientId)
+	}
+
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, lockUsersQuery, actor, recipient); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, restoreDecisionsQuery, actor, recipient); err != nil {
+			return err
+		}
+
+		previous := database.DecisionState{Exists: true}
+		err := tx.QueryRowContext(ctx, readDecisionStateQuery, actor, recipient).Scan(&previous.Liked, &previous.IsNew)
+		if err == sql.ErrNoRows {
+			previous = database.DecisionState{}
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, insertOrUpdateDecisionQuery, actor, recipient, entry.Like); err != nil {
+			return err
+		}
+
+		var reverseLikes int
+		if err := tx.QueryRowContext(ctx, readReverseLikeQuery, recipient, actor).Scan(&reverseLikes); err != nil {
+			return err
+		}
+
+		likes, newLikes, matches := database.CounterDeltas(previous, entry.Like, reverseLikes > 0)
+		if likes != 0 {
+			if _, err := tx.ExecContext(ctx, updateRecipientCountersQuery, likes, newLikes, recipient); err != nil {
+				return err
+			}
+		}
+		if matches != 0 {
+			if _, err := tx.ExecContext(ctx, updateMatchesQuery, matches, actor, recipient); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert or update decision: %w", err)
+	}
+
+	return nil
+}
+
+func (w DatabaseWriter) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []database.DecisionModel) error {
+	recipient, ok := parseId(RecipientId)
+	if !ok || len(likes) == 0 {
+		return nil
+	}
+
+	decisionIds := make([]int64, 0, len(likes))
+	for _, like := range likes {
+		if id, ok := parseId(like.Id); ok {
+			decisionIds = append(decisionIds, id)
+		}
+	}
+
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, lockUsersQuery, recipient, recipient); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, updateDecisionLikesQuery, recipient, decisionIds)
+		if err != nil {
+			return err
+		}
+		viewed, err := result.RowsAffected()
+		if err != nil || viewed == 0 {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, updateNewLikesQuery, viewed, recipient)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update decisions: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileCounters recomputes the counters of a batch of users from their decisions
+func (w DatabaseWriter) ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (uint, int, error) {
+	var lastUserId uint
+	var repaired int64
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, lockUsersBatchQuery, int64(afterUserId), limit)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := rows.Scan(&lastUserId); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || lastUserId == 0 {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, reconcileCountersQuery, int64(afterUserId), int64(lastUserId))
+		if err != nil {
+			return err
+		}
+		repaired, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to reconcile counters: %w", err)
+	}
+
+	return lastUserId, int(repaired), nil
+}
+
+const readDecisionsBatchQuery = `
+SELECT max(id)
+FROM (
+	SELECT id
+	FROM decisions
+	WHERE id > $1
+	ORDER BY id
+	LIMIT $2
+) batch;
+`
+
+// lockArchivedUsersQuery locks the users of the decisions of a batch in ID order, like lockUsersQuery, so no
+// decision between two of them is written or restored while the batch is moved
+const lockArchivedUsersQuery = `
+SELECT id
+FROM users
+WHERE id IN (
+	SELECT actor_id
+	FROM decisions
+	WHERE id > $1
+	AND id <= $2
+	UNION
+	SELECT recipient_id
+	FROM decisions
+	WHERE id > $1
+	AND id <= $2
+)
+ORDER BY id
+FOR UPDATE;
+`
+
+// archiveDecisionsQuery moves the decisions of a batch selected by the policy. A pair of users already archived is
+// left in decisions, which is read first.
+const archiveDecisionsQuery = `
+WITH archived AS (
+	INSERT INTO decisions_archive (
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		created_at,
+		updated_at
+	)
+	SELECT
+		d.id,
+		d.actor_id,
+		d.recipient_id,
+		d.liked,
+		d.is_new,
+		d.created_at,
+		d.updated_at
+	FROM decisions d
+	JOIN users a ON a.id = d.actor_id
+	WHERE d.id > $1
+	AND d.id <= $2
+	AND (
+		(NOT d.liked AND d.updated_at < to_timestamp($3))
+		OR (d.liked
+			AND NOT d.is_new
+			AND NOT a.is_active
+			AND COALESCE(a.last_active_at, a.created_at) < to_timestamp($4))
+	)
+	FOR UPDATE OF d
+	ON CONFLICT DO NOTHING
+	RETURNING id
+)
+DELETE FROM decisions
+WHERE id IN (SELECT id FROM archived);
+`
+
+// ArchiveDecisions moves a batch of decisions to decisions_archive in one transaction, holding the locks of their
+// users so a concurrent decision cannot leave a pair in both tables
+func (w DatabaseWriter) ArchiveDecisions(ctx context.Context, policy database.ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	var lastId sql.NullInt64
+	var archived int64
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, readDecisionsBatchQuery, int64(afterId), limit).Scan(&lastId)
+		if err != nil || !lastId.Valid {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, lockArchivedUsersQuery, int64(afterId), lastId.Int64); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, archiveDecisionsQuery, int64(afterId), lastId.Int64, int64(policy.PassesBefore), int64(policy.InactiveLikesBefore))
+		if err != nil {
+			return err
+		}
+		archived, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to archive decisions: %w", err)
+	}
+
+	return uint(lastId.Int64), int(archived), nil
+}
+
+// Interface guards
+var (
+	_ database.Writer            = (*DatabaseWriter)(nil)
+	_ database.CounterReconciler = (*DatabaseWriter)(nil)
+	_ database.DecisionArchiver  = (*DatabaseWriter)(nil)
+)
@@ -3,6 +3,9 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -15,28 +18,88 @@ type Reader interface {
 	FindNewLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]DecisionModel, error)
 	GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error)
 	GetUserById(ctx context.Context, userId string) (UserModel, error)
+	// FindUsersByIds returns the active users among at most MaxUsers IDs in ID order, e.g. to rank the actors of likes
+	FindUsersByIds(ctx context.Context, userIds []string) ([]UserModel, error)
+	// FindUsers returns a page of the active users selected by filter, in ID order
+	FindUsers(ctx context.Context, filter UserFilter) ([]UserModel, error)
 	GetLimit() int
 }
 
+// DatabaseReader runs the list and count queries on the replicas, and GetIsMatch, which must see the decision
+// just written by PutDecision, on the primary. Its queries are prepared on every database.
 type DatabaseReader struct {
-	db *sql.DB
+	replicas   *ReplicaPool
+	primary    *statements
+	statements map[*sql.DB]*statements
+	retry      RetryPolicy
 }
 
-func NewDatabaseReader(db *sql.DB) DatabaseReader {
-	return DatabaseReader{db}
+func NewDatabaseReader(db *sql.DB, options QueryOptions) (DatabaseReader, error) {
+	return NewDatabaseReaderWithReplicas(NewReplicaPool(db, nil, ReplicaOptions{}), options)
 }
 
+// NewDatabaseReaderWithReplicas creates a DatabaseReader reading from the replicas of pool when they are healthy.
+// The queries are prepared on the primary right away, and on a replica the first time it serves them.
+func NewDatabaseReaderWithReplicas(pool *ReplicaPool, options QueryOptions) (DatabaseReader, error) {
+	primary, err := prepareStatements(context.Background(), pool.Primary(), options, readerQueries...)
+	if err != nil {
+		return DatabaseReader{}, err
+	}
+
+	r := DatabaseReader{
+		replicas:   pool,
+		primary:    primary,
+		statements: map[*sql.DB]*statements{pool.Primary(): primary},
+		retry:      options.Retry,
+	}
+	for _, db := range pool.Replicas() {
+		r.statements[db] = newStatements(db, options, readerQueries...)
+	}
+	return r, nil
+}
+
+// readerQueries are the fixed queries of the reader, the users queries built for a number of IDs or of conditions
+// are not prepared
+var readerQueries = []string{
+	readDecisionsWithLikeByRecipientIdPaginated,
+	readNewDecisionsWithLikeByRecipientIdPaginated,
+	readActiveUsersById,
+	readGetMatchByActorIdAndRecipientId,
+	readLikeQuery,
+}
+
+// readDecisionsWithLikeByRecipientIdPaginated merges the likes of decisions and of the archive, each read up to
+// the end of the page so only two pages are sorted
 const readDecisionsWithLikeByRecipientIdPaginated = `
-SELECT
-	id,
-	actor_id, 
-	recipient_id, 
-	liked,
-	UNIX_TIMESTAMP(created_at) as created_at,
-	UNIX_TIMESTAMP(updated_at) as updated_at
-FROM decisions 
-WHERE recipient_id = ?
-AND liked = 1
+SELECT *
+FROM (
+	(SELECT
+		id,
+		actor_id, 
+		recipient_id, 
+		liked,
+		UNIX_TIMESTAMP(created_at) as created_at,
+		UNIX_TIMESTAMP(updated_at) as updated_at
+	FROM decisions 
+	WHERE recipient_id = ?
+	AND liked = 1
+	ORDER BY id
+	LIMIT ?)
+	UNION ALL
+	(SELECT
+		id,
+		actor_id, 
+		recipient_id, 
+		liked,
+		UNIX_TIMESTAMP(created_at) as created_at,
+		UNIX_TIMESTAMP(updated_at) as updated_at
+	FROM decisions_archive
+	WHERE recipient_id = ?
+	AND liked = 1
+	ORDER BY id
+	LIMIT ?)
+) likes
+ORDER BY id
 LIMIT ?
 OFFSET ?;
 `
@@ -53,24 +116,48 @@ FROM decisions
 WHERE recipient_id = ?
 AND liked = 1
 AND is_new = 1
+ORDER BY id
 LIMIT ?
 OFFSET ?;
 `
 
-const readActiveUsersById = `
-SELECT 
+// userColumns are the columns of a user, in the order of database.UserRow
+const userColumns = `
 	id,
-    name,
-    likes,
-    gender,
-    UNIX_TIMESTAMP(created_at) as created_at,
-    UNIX_TIMESTAMP(updated_at) as updated_at,
-	is_active
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	UNIX_TIMESTAMP(created_at) as created_at,
+	UNIX_TIMESTAMP(updated_at) as updated_at,
+	is_active,
+	birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	COALESCE(UNIX_TIMESTAMP(last_active_at), 0) as last_active_at`
+
+const readActiveUsersById = `
+SELECT` + userColumns + `
 FROM users
 WHERE id = ? 
 AND is_active = 1;
 `
 
+// readActiveUsersByIds reads the users of a list of IDs, padded to a power of two so few statements are prepared
+func readActiveUsersByIds(ids int) string {
+	return `
+SELECT` + userColumns + `
+FROM users
+WHERE id IN (` + strings.TrimSuffix(strings.Repeat("?, ", ids), ", ") + `)
+AND is_active = 1
+ORDER BY id;
+`
+}
+
+// readGetMatchByActorIdAndRecipientId reads the likes between two users in both directions, archived or not
 const readGetMatchByActorIdAndRecipientId = `
 SELECT
 	id,
@@ -80,6 +167,24 @@ SELECT
 	UNIX_TIMESTAMP(created_at) as created_at,
 	UNIX_TIMESTAMP(updated_at) as updated_at
 FROM decisions
+WHERE (
+	actor_id = ?
+	AND recipient_id = ?
+	AND liked = 1)
+OR (
+	actor_id = ?
+	AND recipient_id = ?
+	AND liked = 1
+)
+UNION ALL
+SELECT
+	id,
+	actor_id, 
+	recipient_id, 
+	liked,
+	UNIX_TIMESTAMP(created_at) as created_at,
+	UNIX_TIMESTAMP(updated_at) as updated_at
+FROM decisions_archive
 WHERE (
 	actor_id = ?
 	AND recipient_id = ?
@@ -93,42 +198,36 @@ OR (
 
 // FindLikesByRecipientIdPaginated finds all likes on  decisions table for a given recipient user ID with pagination
 func (r DatabaseReader) FindLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]DecisionModel, error) {
-	offset := (page - 1) * Limit
-	rows, err := r.db.QueryContext(ctx, readDecisionsWithLikeByRecipientIdPaginated, recipientId, Limit, offset)
-
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var decisions []DecisionModel
-	for rows.Next() {
-
-		var decision DecisionModel
-		err = rows.Scan(
-			&decision.Id,
-			&decision.ActorId,
-			&decision.RecipientId,
-			&decision.Liked,
-			&decision.Created_at,
-			&decision.Updated_at,
-		)
-
-		decisions = append(decisions, decision)
-
-		if err != nil {
-			return nil, err
-		}
+	if page < 1 {
+		return nil, fmt.Errorf("invalid page %d", page)
 	}
-
-	return decisions, nil
+	offset := (page - 1) * Limit
+	return r.readDecisions(ctx, readDecisionsWithLikeByRecipientIdPaginated, recipientId, offset+Limit, recipientId, offset+Limit, Limit, offset)
 }
 
 // FindNewLikesByRecipientIdPaginated finds all new/unchecked likes on  decisions table for a given recipient user ID with pagination
 func (r DatabaseReader) FindNewLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]DecisionModel, error) {
+	if page < 1 {
+		return nil, fmt.Errorf("invalid page %d", page)
+	}
 	offset := (page - 1) * Limit
-	rows, err := r.db.QueryContext(ctx, readNewDecisionsWithLikeByRecipientIdPaginated, recipientId, Limit, offset)
+	return r.readDecisions(ctx, readNewDecisionsWithLikeByRecipientIdPaginated, recipientId, Limit, offset)
+}
+
+// readDecisions runs a decisions query on a replica
+func (r DatabaseReader) readDecisions(ctx context.Context, query string, args ...any) ([]DecisionModel, error) {
+	var decisions []DecisionModel
+	err := r.retry.Do(ctx, func() error {
+		return r.replicas.Read(ctx, func(db *sql.DB) error {
+			var err error
+			decisions, err = scanDecisions(r.statements[db].query(ctx, nil, query, args...))
+			return err
+		})
+	})
+	return decisions, err
+}
 
+func scanDecisions(rows *sql.Rows, err error) ([]DecisionModel, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -136,7 +235,6 @@ func (r DatabaseReader) FindNewLikesByRecipientIdPaginated(ctx context.Context,
 
 	var decisions []DecisionModel
 	for rows.Next() {
-
 		var decision DecisionModel
 		err = rows.Scan(
 			&decision.Id,
@@ -146,15 +244,14 @@ func (r DatabaseReader) FindNewLikesByRecipientIdPaginated(ctx context.Context,
 			&decision.Created_at,
 			&decision.Updated_at,
 		)
-
-		decisions = append(decisions, decision)
-
 		if err != nil {
 			return nil, err
 		}
+
+		decisions = append(decisions, decision)
 	}
 
-	return decisions, nil
+	return decisions, rows.Err()
 }
 
 func (r DatabaseReader) GetLimit() int {
@@ -163,66 +260,112 @@ func (r DatabaseReader) GetLimit() int {
 
 // GetUserById get user information for a given user ID
 func (r DatabaseReader) GetUserById(ctx context.Context, userId string) (UserModel, error) {
-	rows, err := r.db.QueryContext(ctx, readActiveUsersById, userId)
-
+	var user UserModel
+	err := r.retry.Do(ctx, func() error {
+		return r.replicas.Read(ctx, func(db *sql.DB) error {
+			var row UserRow
+			err := r.statements[db].queryRow(ctx, nil, readActiveUsersById, []any{userId}, row.Dest()...)
+			if err == sql.ErrNoRows {
+				user = UserModel{}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			user = row.User()
+			return nil
+		})
+	})
 	if err != nil {
 		return UserModel{}, err
 	}
-	defer rows.Close()
 
-	var user UserModel
-	for rows.Next() {
-		err = rows.Scan(
-			&user.Id,
-			&user.Name,
-			&user.Likes,
-			&user.Gender,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-			&user.IsAactive,
-		)
+	return user, nil
+}
 
-		if err != nil {
-			return UserModel{}, err
+func (r DatabaseReader) FindUsersByIds(ctx context.Context, userIds []string) ([]UserModel, error) {
+	if len(userIds) > MaxUsers {
+		return nil, fmt.Errorf("unable to read %d users, at most %d can be read at once", len(userIds), MaxUsers)
+	}
+	// IDs that are not numbers never match, and are left out rather than compared to an INT column
+	var ids []uint64
+	for _, userId := range userIds {
+		if id, err := strconv.ParseUint(userId, 10, 32); err == nil && id > 0 {
+			ids = append(ids, id)
 		}
 	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
 
-	return user, nil
+	size := 1
+	for size < len(ids) {
+		size *= 2
+	}
+	args := make([]any, size)
+	for i := range args {
+		args[i] = ids[min(i, len(ids)-1)]
+	}
+	return r.readUsers(ctx, readActiveUsersByIds(size), args...)
 }
 
-// GetIsMatch check for match on decisions between actor user id and recipient user id and return if match is true or false
-func (r DatabaseReader) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
-	rows, err := r.db.QueryContext(
-		ctx,
-		readGetMatchByActorIdAndRecipientId,
-		ActorId,
-		RecipientId,
-		RecipientId,
-		ActorId,
-	)
-
+func (r DatabaseReader) FindUsers(ctx context.Context, filter UserFilter) ([]UserModel, error) {
+	limit, err := filter.PageSize()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	defer rows.Close()
+	conditions, args := filter.Conditions("FROM_UNIXTIME(?)")
+	args = append(append([]any{filter.AfterId}, args...), limit)
+	return r.readUsers(ctx, readActiveUsersFiltered(conditions), args...)
+}
 
-	var decisions []DecisionModel
-	for rows.Next() {
-		var decision DecisionModel
-		err = rows.Scan(
-			&decision.Id,
-			&decision.ActorId,
-			&decision.RecipientId,
-			&decision.Liked,
-			&decision.Created_at,
-			&decision.Updated_at,
-		)
+// readActiveUsersFiltered reads a page of the active users after an ID, with the conditions of a UserFilter
+func readActiveUsersFiltered(conditions string) string {
+	return `
+SELECT` + userColumns + `
+FROM users
+WHERE is_active = 1
+AND id > ?` + conditions + `
+ORDER BY id
+LIMIT ?;
+`
+}
 
-		decisions = append(decisions, decision)
+// readUsers runs a users query on a replica
+func (r DatabaseReader) readUsers(ctx context.Context, query string, args ...any) ([]UserModel, error) {
+	var users []UserModel
+	err := r.retry.Do(ctx, func() error {
+		return r.replicas.Read(ctx, func(db *sql.DB) error {
+			var err error
+			users, err = ScanUsers(r.statements[db].query(ctx, nil, query, args...))
+			return err
+		})
+	})
+	return users, err
+}
 
-		if err != nil {
-			return false, err
-		}
+// GetIsMatch check for match on decisions between actor user id and recipient user id and return if match is true or false
+func (r DatabaseReader) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
+	var decisions []DecisionModel
+	err := r.retry.Do(ctx, func() error {
+		var err error
+		decisions, err = scanDecisions(r.primary.query(
+			ctx,
+			nil,
+			readGetMatchByActorIdAndRecipientId,
+			ActorId,
+			RecipientId,
+			RecipientId,
+			ActorId,
+			ActorId,
+			RecipientId,
+			RecipientId,
+			ActorId,
+		))
+		return err
+	})
+	if err != nil {
+		return false, err
 	}
 
 	if len(decisions) == 2 {
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// LagProbe measures how far a replica is behind the primary
+type LagProbe func(ctx context.Context, db *sql.DB) (time.Duration, error)
+
+type ReplicaOptions struct {
+	// MaxLag is the replication lag above which a replica stops serving reads
+	MaxLag time.Duration
+	// CheckInterval is the time between two health checks run by Run
+	CheckInterval time.Duration
+	// Probe measures the lag, MySQLReplicaLag when nil
+	Probe LagProbe
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// ReplicaPool routes the reads that tolerate replication lag to healthy replicas in turn, and to the primary
+// when there is none. Replicas only serve reads once a health check found them within MaxLag.
+type ReplicaPool struct {
+	primary  *sql.DB
+	replicas []*replica
+	options  ReplicaOptions
+	next     atomic.Uint64
+}
+
+func NewReplicaPool(primary *sql.DB, replicas []*sql.DB, options ReplicaOptions) *ReplicaPool {
+	if options.Probe == nil {
+		options.Probe = MySQLReplicaLag
+	}
+	pool := &ReplicaPool{primary: primary, options: options}
+	for _, db := range replicas {
+		pool.replicas = append(pool.replicas, &replica{db: db})
+	}
+	return pool
+}
+
+// Primary returns the primary database, for the writes and the reads that must see them
+func (p *ReplicaPool) Primary() *sql.DB {
+	return p.primary
+}
+
+// pick returns the next healthy replica, or nil when there is none
+func (p *ReplicaPool) pick() *replica {
+	n := uint64(len(p.replicas))
+	if n == 0 {
+		return nil
+	}
+	start := p.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if candidate := p.replicas[(start+i)%n]; candidate.healthy.Load() {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// Replicas returns the databases of the replicas
+func (p *ReplicaPool) Replicas() []*sql.DB {
+	dbs := make([]*sql.DB, 0, len(p.replicas))
+	for _, replica := range p.replicas {
+		dbs = append(dbs, replica.db)
+	}
+	return dbs
+}
+
+// Read runs read on a healthy replica. The primary runs it when no replica is healthy, or when the replica
+// cannot be reached, in which case the replica is left out until the next health check.
+func (p *ReplicaPool) Read(ctx context.Context, read func(db *sql.DB) error) error {
+	candidate := p.pick()
+	if candidate == nil {
+		return read(p.primary)
+	}
+
+	// a MySQL error is the answer of a reachable replica, the primary would answer the same
+	err := read(candidate.db)
+	var serverErr *mysql.MySQLError
+	if err == nil || ctx.Err() != nil || errors.As(err, &serverErr) {
+		return err
+	}
+	if candidate.healthy.CompareAndSwap(true, false) {
+		log.Printf("Replica read failed, reading from the primary until the next check: %s", err)
+	}
+	return read(p.primary)
+}
+
+// Check probes every replica once and updates which ones serve reads
+func (p *ReplicaPool) Check(ctx context.Context) {
+	for i, candidate := range p.replicas {
+		lag, err := p.options.Probe(ctx, candidate.db)
+		healthy := err == nil && lag <= p.options.MaxLag
+		if candidate.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		switch {
+		case healthy:
+			log.Printf("Replica %d is serving reads, %s behind the primary", i, lag)
+		case err != nil:
+			log.Printf("Replica %d stopped serving reads: %s", i, err)
+		default:
+			log.Printf("Replica %d stopped serving reads, %s behind the primary", i, lag)
+		}
+	}
+}
+
+// Run checks the replicas right away then every CheckInterval until ctx is done
+func (p *ReplicaPool) Run(ctx context.Context) {
+	if len(p.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(p.options.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, p.options.CheckInterval)
+		p.Check(checkCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MySQLReplicaLag reads Seconds_Behind_Source from SHOW REPLICA STATUS, or Seconds_Behind_Master before MySQL 8.0.22.
+// A server which is not replicating, or whose replication threads are stopped, reports an error.
+func MySQLReplicaLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("replication is not configured")
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, errors.New("replication is stopped")
+		}
+		seconds, err := strconv.Atoi(string(values[i]))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("replication lag is not reported")
+}
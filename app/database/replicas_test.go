@@ -0,0 +1,106 @@
+package database_test
+
+import (
+	"app/database"
+	"app/database/sqlite"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+// newNamedDB opens a sqlite database holding a single user named name, so reads tell which database served them
+func newNamedDB(t *testing.T, name string) *sql.DB {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), name+".db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = sqlite.AddUser(context.Background(), db, database.UserModel{Name: name, Gender: "f", IsAactive: true})
+	assert.NoError(t, err)
+	return db
+}
+
+func readFrom(t *testing.T, pool *database.ReplicaPool) string {
+	var name string
+	err := pool.Read(context.Background(), func(db *sql.DB) error {
+		return db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name)
+	})
+	assert.NoError(t, err)
+	return name
+}
+
+func TestReplicaPool(t *testing.T) {
+	primary := newNamedDB(t, "primary")
+	first := newNamedDB(t, "first")
+	second := newNamedDB(t, "second")
+
+	tests := []struct {
+		name     string
+		lags     map[*sql.DB]time.Duration
+		errs     map[*sql.DB]error
+		expected map[string]bool
+	}{
+		{
+			name:     "healthy_replicas_take_turns",
+			lags:     map[*sql.DB]time.Duration{first: 0, second: time.Second},
+			expected: map[string]bool{"first": true, "second": true},
+		},
+		{
+			name:     "lagging_replica_is_left_out",
+			lags:     map[*sql.DB]time.Duration{first: 0, second: time.Minute},
+			expected: map[string]bool{"first": true},
+		},
+		{
+			name:     "failing_probe_leaves_the_replica_out",
+			lags:     map[*sql.DB]time.Duration{second: 0},
+			errs:     map[*sql.DB]error{first: errors.New("replication is stopped")},
+			expected: map[string]bool{"second": true},
+		},
+		{
+			name:     "primary_serves_reads_without_healthy_replica",
+			lags:     map[*sql.DB]time.Duration{first: time.Minute},
+			errs:     map[*sql.DB]error{second: errors.New("connection refused")},
+			expected: map[string]bool{"primary": true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := database.NewReplicaPool(primary, []*sql.DB{first, second}, database.ReplicaOptions{
+				MaxLag: 5 * time.Second,
+				Probe: func(ctx context.Context, db *sql.DB) (time.Duration, error) {
+					return test.lags[db], test.errs[db]
+				},
+			})
+
+			// replicas only serve reads once checked
+			assert.Equal(t, "primary", readFrom(t, pool))
+
+			pool.Check(context.Background())
+			served := map[string]bool{}
+			for i := 0; i < 4; i++ {
+				served[readFrom(t, pool)] = true
+			}
+			assert.Equal(t, test.expected, served)
+		})
+	}
+}
+
+func TestReplicaPoolFallsBackToThePrimaryWhenTheReplicaFails(t *testing.T) {
+	primary := newNamedDB(t, "primary")
+	replica := newNamedDB(t, "replica")
+
+	pool := database.NewReplicaPool(primary, []*sql.DB{replica}, database.ReplicaOptions{
+		MaxLag: time.Second,
+		Probe:  func(ctx context.Context, db *sql.DB) (time.Duration, error) { return 0, nil },
+	})
+	pool.Check(context.Background())
+	assert.Equal(t, "replica", readFrom(t, pool))
+
+	assert.NoError(t, replica.Close())
+	assert.Equal(t, "primary", readFrom(t, pool))
+	assert.Equal(t, "primary", readFrom(t, pool))
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MySQL error numbers worth retrying: the transaction was rolled back and can run again as is
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrLockDeadlock    = 1213
+)
+
+// PostgreSQL error codes worth retrying, the transaction was rolled back as well
+const (
+	postgresErrSerializationFailure = "40001"
+	postgresErrDeadlockDetected     = "40P01"
+	postgresErrLockNotAvailable     = "55P03"
+)
+
+// RetryPolicy retries units of work failing with a transient error, waiting an exponential backoff with full
+// jitter between attempts. The zero value runs every unit once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of runs of a unit, the first one included
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// IsRetryable reports whether err is transient: a deadlock, a lock wait timeout or a dropped connection, of MySQL
+// or PostgreSQL
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrLockDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	var postgresErr *pgconn.PgError
+	if errors.As(err, &postgresErr) {
+		return postgresErr.Code == postgresErrDeadlockDetected ||
+			postgresErr.Code == postgresErrSerializationFailure ||
+			postgresErr.Code == postgresErrLockNotAvailable
+	}
+
+	var netErr net.Error
+	return errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.As(err, &netErr)
+}
+
+// Do runs unit until it succeeds, fails with an error which is not retryable or runs out of attempts, and
+// returns its last error. It gives up early rather than waiting past the deadline of ctx.
+// unit must be idempotent or a transaction, which a failure rolls back as a whole.
+func (p RetryPolicy) Do(ctx context.Context, unit func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := unit()
+		if attempt >= p.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a random delay up to BaseDelay doubled for every attempt made, capped at MaxDelay
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << min(attempt-1, 30)
+	if ceiling <= 0 || (p.MaxDelay > 0 && ceiling > p.MaxDelay) {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
@@ -0,0 +1,127 @@
+package database_test
+
+import (
+	"app/database"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/zeebo/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "nil", err: nil, retryable: false},
+		{name: "deadlock", err: &mysql.MySQLError{Number: 1213}, retryable: true},
+		{name: "lock_wait_timeout", err: &mysql.MySQLError{Number: 1205}, retryable: true},
+		{name: "wrapped_deadlock", err: fmt.Errorf("unable to insert or update decision: %w", &mysql.MySQLError{Number: 1213}), retryable: true},
+		{name: "duplicate_entry", err: &mysql.MySQLError{Number: 1062}, retryable: false},
+		{name: "foreign_key", err: &mysql.MySQLError{Number: 1452}, retryable: false},
+		{name: "postgres_deadlock", err: &pgconn.PgError{Code: "40P01"}, retryable: true},
+		{name: "postgres_serialization_failure", err: &pgconn.PgError{Code: "40001"}, retryable: true},
+		{name: "postgres_lock_not_available", err: &pgconn.PgError{Code: "55P03"}, retryable: true},
+		{name: "postgres_unique_violation", err: &pgconn.PgError{Code: "23505"}, retryable: false},
+		{name: "invalid_connection", err: mysql.ErrInvalidConn, retryable: true},
+		{name: "bad_connection", err: driver.ErrBadConn, retryable: true},
+		{name: "network", err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, retryable: true},
+		{name: "canceled", err: context.Canceled, retryable: false},
+		{name: "deadline", err: context.DeadlineExceeded, retryable: false},
+		{name: "other", err: errors.New("invalid page"), retryable: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.retryable, database.IsRetryable(test.err))
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213}
+	duplicate := &mysql.MySQLError{Number: 1062}
+
+	tests := []struct {
+		name     string
+		policy   database.RetryPolicy
+		errs     []error
+		err      error
+		attempts int
+	}{
+		{
+			name:     "succeeds_after_transient_errors",
+			policy:   database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:     []error{deadlock, mysql.ErrInvalidConn, nil},
+			err:      nil,
+			attempts: 3,
+		},
+		{
+			name:     "gives_up_after_max_attempts",
+			policy:   database.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			errs:     []error{deadlock, deadlock, nil},
+			err:      deadlock,
+			attempts: 2,
+		},
+		{
+			name:     "stops_on_permanent_error",
+			policy:   database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:     []error{deadlock, duplicate, nil},
+			err:      duplicate,
+			attempts: 2,
+		},
+		{
+			name:     "zero_policy_runs_once",
+			errs:     []error{deadlock, nil},
+			err:      deadlock,
+			attempts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := test.policy.Do(context.Background(), func() error {
+				attempts++
+				return test.errs[attempts-1]
+			})
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+		})
+	}
+}
+
+func TestRetryPolicyDoRespectsTheContext(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213}
+	policy := database.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	// a backoff beyond the deadline is not waited for
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	attempts := 0
+	start := time.Now()
+	err := policy.Do(ctx, func() error {
+		attempts++
+		return deadlock
+	})
+	assert.Equal(t, deadlock, err)
+	assert.That(t, attempts < 10)
+	assert.That(t, time.Since(start) < time.Second)
+
+	// a canceled context stops the backoff
+	ctx, cancel = context.WithCancel(context.Background())
+	attempts = 0
+	err = policy.Do(ctx, func() error {
+		attempts++
+		cancel()
+		return deadlock
+	})
+	assert.Equal(t, deadlock, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,600 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Buckets is the number of buckets the users are hashed to. A bucket lives on a single shard, and moving a range of
+// buckets to another shard reshards the decisions.
+const Buckets = 1024
+
+// Bucket returns the bucket of a user: the CRC32 of its decimal ID modulo Buckets, CRC32(id) % 1024 in MySQL
+func Bucket(userId string) int {
+	if id, err := strconv.ParseUint(userId, 10, 64); err == nil {
+		userId = strconv.FormatUint(id, 10)
+	}
+	return int(crc32.ChecksumIEEE([]byte(userId)) % Buckets)
+}
+
+// BucketRange is an inclusive range of buckets
+type BucketRange struct {
+	First int
+	Last  int
+}
+
+// ParseBucketRange parses a bucket, e.g. 42, or an inclusive range of buckets, e.g. 512-767
+func ParseBucketRange(s string) (BucketRange, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		last = first
+	}
+	var r BucketRange
+	var err error
+	if r.First, err = strconv.Atoi(first); err != nil {
+		return BucketRange{}, fmt.Errorf("invalid bucket range %q", s)
+	}
+	if r.Last, err = strconv.Atoi(last); err != nil {
+		return BucketRange{}, fmt.Errorf("invalid bucket range %q", s)
+	}
+	if r.First < 0 || r.Last >= Buckets || r.First > r.Last {
+		return BucketRange{}, fmt.Errorf("invalid bucket range %q, buckets are between 0 and %d", s, Buckets-1)
+	}
+	return r, nil
+}
+
+// Contains reports whether bucket is in the range
+func (r BucketRange) Contains(bucket int) bool {
+	return bucket >= r.First && bucket <= r.Last
+}
+
+func (r BucketRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// ShardMap assigns every bucket to the index of its shard
+type ShardMap [Buckets]int
+
+// ParseShardMap parses the ranges of buckets of every shard, e.g. 0-511:0,512-1023:1. Every bucket must be
+// assigned once, to one of shards shards.
+func ParseShardMap(s string, shards int) (ShardMap, error) {
+	var m ShardMap
+	var assigned [Buckets]bool
+	for _, entry := range strings.Split(s, ",") {
+		buckets, shard, ok := strings.Cut(entry, ":")
+		if !ok {
+			return ShardMap{}, fmt.Errorf("invalid shard map entry %q, expected buckets:shard", entry)
+		}
+		r, err := ParseBucketRange(buckets)
+		if err != nil {
+			return ShardMap{}, err
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(shard))
+		if err != nil || index < 0 || index >= shards {
+			return ShardMap{}, fmt.Errorf("invalid shard %q of buckets %s, there are %d shards", shard, r, shards)
+		}
+		for bucket := r.First; bucket <= r.Last; bucket++ {
+			if assigned[bucket] {
+				return ShardMap{}, fmt.Errorf("bucket %d is assigned twice", bucket)
+			}
+			assigned[bucket] = true
+			m[bucket] = index
+		}
+	}
+	for bucket, ok := range assigned {
+		if !ok {
+			return ShardMap{}, fmt.Errorf("bucket %d is not assigned to a shard", bucket)
+		}
+	}
+	return m, nil
+}
+
+// Shard returns the index of the shard of a user
+func (m ShardMap) Shard(userId string) int {
+	return m[Bucket(userId)]
+}
+
+// Move returns the map with the buckets of r assigned to shard
+func (m ShardMap) Move(r BucketRange, shard int) ShardMap {
+	for bucket := r.First; bucket <= r.Last; bucket++ {
+		m[bucket] = shard
+	}
+	return m
+}
+
+// String formats the map as parsed by ParseShardMap, merging the consecutive buckets of a shard
+func (m ShardMap) String() string {
+	var entries []string
+	first := 0
+	for bucket := 1; bucket <= Buckets; bucket++ {
+		if bucket < Buckets && m[bucket] == m[first] {
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s:%d", BucketRange{first, bucket - 1}, m[first]))
+		first = bucket
+	}
+	return strings.Join(entries, ",")
+}
+
+// ShardRouter spreads the decisions over MySQL shards by the bucket of their recipient, so the likes of a user
+// are read from a single shard. The users are copied to every shard, and the counters of a user are kept by the
+// shard of its bucket. A like between users of two shards is also kept in the sent_likes of the shard of its
+// actor, so every shard counts and reconciles the matches of its users on its own.
+type ShardRouter struct {
+	shards  []shard
+	buckets ShardMap
+	// commitErr fails the first transaction of the decisions spanning two shards once the second one committed,
+	// for tests
+	commitErr error
+}
+
+type shard struct {
+	reader DatabaseReader
+	writer DatabaseWriter
+}
+
+// NewShardRouter creates a ShardRouter over the primaries of the shards, dbs being in the order of their index
+func NewShardRouter(dbs []*sql.DB, buckets ShardMap, options QueryOptions) (ShardRouter, error) {
+	r := ShardRouter{buckets: buckets}
+	for index, db := range dbs {
+		reader, err := NewDatabaseReader(db, options)
+		if err != nil {
+			return ShardRouter{}, fmt.Errorf("shard %d: %w", index, err)
+		}
+		writer, err := NewDatabaseWriter(db, options)
+		if err != nil {
+			return ShardRouter{}, fmt.Errorf("shard %d: %w", index, err)
+		}
+		r.shards = append(r.shards, shard{reader: reader, writer: writer})
+	}
+	for bucket, index := range buckets {
+		if index < 0 || index >= len(r.shards) {
+			return ShardRouter{}, fmt.Errorf("bucket %d is assigned to shard %d, there are %d shards", bucket, index, len(r.shards))
+		}
+	}
+	return r, nil
+}
+
+// shardOf returns the shard of a user
+func (r ShardRouter) shardOf(userId string) shard {
+	return r.shards[r.buckets.Shard(userId)]
+}
+
+func (r ShardRouter) FindLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]DecisionModel, error) {
+	return r.shardOf(recipientId).reader.FindLikesByRecipientIdPaginated(ctx, recipientId, page)
+}
+
+func (r ShardRouter) FindNewLikesByRecipientIdPaginated(ctx context.Context, recipientId string, page int) ([]DecisionModel, error) {
+	return r.shardOf(recipientId).reader.FindNewLikesByRecipientIdPaginated(ctx, recipientId, page)
+}
+
+// GetIsMatch reads each like from the shard of its recipient, both shards at once when they differ
+func (r ShardRouter) GetIsMatch(ctx context.Context, ActorId string, RecipientId string) (bool, error) {
+	actorShard, recipientShard := r.buckets.Shard(ActorId), r.buckets.Shard(RecipientId)
+	if actorShard == recipientShard {
+		return r.shards[actorShard].reader.GetIsMatch(ctx, ActorId, RecipientId)
+	}
+
+	var liked, likedBack bool
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() (err error) {
+		liked, err = r.shards[recipientShard].reader.getIsLiked(ctx, ActorId, RecipientId)
+		return err
+	})
+	group.Go(func() (err error) {
+		likedBack, err = r.shards[actorShard].reader.getIsLiked(ctx, RecipientId, ActorId)
+		return err
+	})
+	if err := group.Wait(); err != nil {
+		return false, err
+	}
+	return liked && likedBack, nil
+}
+
+// GetUserById reads the user from the shard of its bucket, the only one keeping its counters
+func (r ShardRouter) GetUserById(ctx context.Context, userId string) (UserModel, error) {
+	return r.shardOf(userId).reader.GetUserById(ctx, userId)
+}
+
+// FindUsersByIds reads every user from the shard of its bucket
+func (r ShardRouter) FindUsersByIds(ctx context.Context, userIds []string) ([]UserModel, error) {
+	if len(userIds) > MaxUsers {
+		return nil, fmt.Errorf("unable to read %d users, at most %d can be read at once", len(userIds), MaxUsers)
+	}
+	idsByShard := map[int][]string{}
+	for _, userId := range userIds {
+		index := r.buckets.Shard(userId)
+		idsByShard[index] = append(idsByShard[index], userId)
+	}
+
+	var users []UserModel
+	for index, ids := range idsByShard {
+		found, err := r.shards[index].reader.FindUsersByIds(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, found...)
+	}
+	slices.SortFunc(users, func(a, b UserModel) int {
+		return cmp.Compare(userIdOrder(a.Id), userIdOrder(b.Id))
+	})
+	return users, nil
+}
+
+// userIdOrder returns the numeric value of a user ID read from a shard
+func userIdOrder(userId string) uint64 {
+	id, _ := strconv.ParseUint(userId, 10, 64)
+	return id
+}
+
+// FindUsers filters the users on the first shard, then reads their counters from the shards of their buckets
+func (r ShardRouter) FindUsers(ctx context.Context, filter UserFilter) ([]UserModel, error) {
+	page, err := r.shards[0].reader.FindUsers(ctx, filter)
+	if err != nil || len(page) == 0 {
+		return page, err
+	}
+	ids := make([]string, 0, len(page))
+	for _, user := range page {
+		ids = append(ids, user.Id)
+	}
+	return r.FindUsersByIds(ctx, ids)
+}
+
+func (r ShardRouter) GetLimit() int {
+	return Limit
+}
+
+// InsertOrUpdateDecision writes the decision on the shard of its recipient, and its sent like on the shard of its
+// actor when it differs. The transaction of the shard with the lowest index is opened first and committed last, so
+// decisions spanning the same shards lock their users in the same order, and a failed commit of the first
+// transaction runs both again, which is idempotent.
+//
+// The two commits are not atomic: when the first one keeps failing, or ctx is cancelled between them, the second
+// shard keeps its write alone, a decision without its sent like or a sent like without its decision, and an error
+// is returned. Writing the decision again repairs the pair, and so does ReconcileCounters.
+func (r ShardRouter) InsertOrUpdateDecision(ctx context.Context, entry PutDecisionEntry) error {
+	actorShard, recipientShard := r.buckets.Shard(entry.ActorId), r.buckets.Shard(entry.RecipientId)
+	if actorShard == recipientShard {
+		return r.shards[actorShard].writer.InsertOrUpdateDecision(ctx, entry)
+	}
+
+	type side struct {
+		writer DatabaseWriter
+		write  func(w DatabaseWriter, tx *sql.Tx) error
+	}
+	first := side{r.shards[recipientShard].writer, func(w DatabaseWriter, tx *sql.Tx) error {
+		return w.writeDecision(ctx, tx, entry, readSentLikeQuery, entry.RecipientId)
+	}}
+	second := side{r.shards[actorShard].writer, func(w DatabaseWriter, tx *sql.Tx) error {
+		return w.writeSentLike(ctx, tx, entry)
+	}}
+	if actorShard < recipientShard {
+		first, second = second, first
+	}
+
+	err := inTx(ctx, first.writer.db, first.writer.retry, func(tx *sql.Tx) error {
+		if err := first.write(first.writer, tx); err != nil {
+			return err
+		}
+		if err := inTx(ctx, second.writer.db, second.writer.retry, func(tx *sql.Tx) error {
+			return second.write(second.writer, tx)
+		}); err != nil {
+			return err
+		}
+		return r.commitErr
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert or update decision: %w", err)
+	}
+	return nil
+}
+
+func (r ShardRouter) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []DecisionModel) error {
+	return r.shardOf(RecipientId).writer.UpdateLikesAsViewed(ctx, RecipientId, likes)
+}
+
+// ReconcileCounters reconciles the batch on every shard, then repairs the sent likes of the batch of users left
+// apart from their decisions by a failed InsertOrUpdateDecision
+func (r ShardRouter) ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (uint, int, error) {
+	lastUserId, repaired, err := r.eachShard(func(w DatabaseWriter) (uint, int, error) {
+		return w.ReconcileCounters(ctx, afterUserId, limit)
+	})
+	if err != nil || lastUserId == 0 {
+		return lastUserId, repaired, err
+	}
+
+	sentLikes, err := r.repairSentLikes(ctx, afterUserId, lastUserId)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to repair sent likes: %w", err)
+	}
+	return lastUserId, repaired + sentLikes, nil
+}
+
+// ArchiveDecisions archives the batch on every shard
+func (r ShardRouter) ArchiveDecisions(ctx context.Context, policy ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	return r.eachShard(func(w DatabaseWriter) (uint, int, error) {
+		return w.ArchiveDecisions(ctx, policy, afterId, limit)
+	})
+}
+
+// eachShard runs a batch on every shard in turn. It returns the lowest last ID of the shards not done yet, so the
+// next batch skips no rows of any shard, 0 once every shard is done, and the rows changed by every shard.
+func (r ShardRouter) eachShard(batch func(w DatabaseWriter) (uint, int, error)) (uint, int, error) {
+	var lastId uint
+	var total int
+	for index, shard := range r.shards {
+		last, changed, err := batch(shard.writer)
+		if err != nil {
+			return 0, 0, fmt.Errorf("shard %d: %w", index, err)
+		}
+		if last != 0 && (lastId == 0 || last < lastId) {
+			lastId = last
+		}
+		total += changed
+	}
+	return lastId, total, nil
+}
+
+// readLikeQuery counts the like of a pair of users, archived or not, for the matches spanning two shards
+const readLikeQuery = `
+SELECT count(*)
+FROM (
+	SELECT id
+	FROM decisions
+	WHERE actor_id = ?
+	AND recipient_id = ?
+	AND liked = 1
+	UNION ALL
+	SELECT id
+	FROM decisions_archive
+	WHERE actor_id = ?
+	AND recipient_id = ?
+	AND liked = 1
+) likes;
+`
+
+// getIsLiked reads on the primary whether actorId likes recipientId
+func (r DatabaseReader) getIsLiked(ctx context.Context, actorId string, recipientId string) (bool, error) {
+	var likes int
+	err := r.retry.Do(ctx, func() error {
+		return r.primary.queryRow(ctx, nil, readLikeQuery, []any{actorId, recipientId, actorId, recipientId}, &likes)
+	})
+	return likes > 0, err
+}
+
+const readSentLikeQuery = `
+SELECT count(*)
+FROM sent_likes
+WHERE actor_id = ?
+AND recipient_id = ?;
+`
+
+const insertSentLikeQuery = `
+INSERT IGNORE INTO sent_likes (
+	actor_id,
+	recipient_id
+) VALUES (?, ?);
+`
+
+const deleteSentLikeQuery = `
+DELETE FROM sent_likes
+WHERE actor_id = ?
+AND recipient_id = ?;
+`
+
+// readLikesBatchQuery reads the likes, archived or not, sent by a range of users
+const readLikesBatchQuery = `
+SELECT actor_id, recipient_id
+FROM decisions
+WHERE actor_id > ?
+AND actor_id <= ?
+AND liked = 1
+UNION
+SELECT actor_id, recipient_id
+FROM decisions_archive
+WHERE actor_id > ?
+AND actor_id <= ?
+AND liked = 1;
+`
+
+// readSentLikesBatchQuery reads the sent likes of a range of users
+const readSentLikesBatchQuery = `
+SELECT actor_id, recipient_id
+FROM sent_likes
+WHERE actor_id > ?
+AND actor_id <= ?;
+`
+
+// sentLike is a like between users of two shards
+type sentLike struct {
+	actorId     string
+	recipientId string
+}
+
+// repairSentLikes compares the sent likes of the users after afterUserId up to lastUserId with the likes kept by
+// the shards of their recipients, and repairs the pairs differing. It returns the number of pairs repaired.
+func (r ShardRouter) repairSentLikes(ctx context.Context, afterUserId uint, lastUserId uint) (int, error) {
+	liked := map[sentLike]bool{}
+	sent := map[sentLike]bool{}
+	for index, shard := range r.shards {
+		likes, err := shard.writer.readSentLikes(ctx, readLikesBatchQuery, afterUserId, lastUserId, afterUserId, lastUserId)
+		if err != nil {
+			return 0, fmt.Errorf("shard %d: %w", index, err)
+		}
+		for _, like := range likes {
+			if r.buckets.Shard(like.recipientId) == index && r.buckets.Shard(like.actorId) != index {
+				liked[like] = true
+			}
+		}
+
+		sentLikes, err := shard.writer.readSentLikes(ctx, readSentLikesBatchQuery, afterUserId, lastUserId)
+		if err != nil {
+			return 0, fmt.Errorf("shard %d: %w", index, err)
+		}
+		for _, like := range sentLikes {
+			if r.buckets.Shard(like.actorId) == index && r.buckets.Shard(like.recipientId) != index {
+				sent[like] = true
+			}
+		}
+	}
+
+	var differing []sentLike
+	for like := range liked {
+		if !sent[like] {
+			differing = append(differing, like)
+		}
+	}
+	for like := range sent {
+		if !liked[like] {
+			differing = append(differing, like)
+		}
+	}
+	slices.SortFunc(differing, func(a, b sentLike) int {
+		return cmp.Or(
+			cmp.Compare(userIdOrder(a.actorId), userIdOrder(b.actorId)),
+			cmp.Compare(userIdOrder(a.recipientId), userIdOrder(b.recipientId)),
+		)
+	})
+
+	repaired := 0
+	for _, like := range differing {
+		changed, err := r.repairSentLike(ctx, like)
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+func (w DatabaseWriter) readSentLikes(ctx context.Context, query string, args ...any) ([]sentLike, error) {
+	var likes []sentLike
+	err := w.retry.Do(ctx, func() error {
+		likes = nil
+		rows, err := w.statements.query(ctx, nil, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var like sentLike
+			if err := rows.Scan(&like.actorId, &like.recipientId); err != nil {
+				return err
+			}
+			likes = append(likes, like)
+		}
+		return rows.Err()
+	})
+	return likes, err
+}
+
+// repairSentLike makes the sent like of a pair match the like kept by the shard of its recipient, reporting whether
+// it changed. The transactions are opened in the order of InsertOrUpdateDecision, so a concurrent decision on the
+// pair waits for the repair, and the like is read again once both users are locked.
+func (r ShardRouter) repairSentLike(ctx context.Context, like sentLike) (bool, error) {
+	actorShard, recipientShard := r.buckets.Shard(like.actorId), r.buckets.Shard(like.recipientId)
+	actor, recipient := r.shards[actorShard].writer, r.shards[recipientShard].writer
+
+	var liked, changed bool
+	readLike := func(tx *sql.Tx) error {
+		if err := recipient.lockUsers(ctx, tx, like.actorId, like.recipientId); err != nil {
+			return err
+		}
+		var likes int
+		err := recipient.statements.queryRow(ctx, tx, readLikeQuery, []any{like.actorId, like.recipientId, like.actorId, like.recipientId}, &likes)
+		liked = likes > 0
+		return err
+	}
+	writeSentLike := func(tx *sql.Tx) error {
+		if err := actor.lockUsers(ctx, tx, like.actorId, like.recipientId); err != nil {
+			return err
+		}
+		var sent int
+		if err := actor.statements.queryRow(ctx, tx, readSentLikeQuery, []any{like.actorId, like.recipientId}, &sent); err != nil {
+			return err
+		}
+		if changed = (sent > 0) != liked; !changed {
+			return nil
+		}
+		return actor.writeSentLike(ctx, tx, PutDecisionEntry{ActorId: like.actorId, RecipientId: like.recipientId, Like: liked})
+	}
+
+	var err error
+	if actorShard < recipientShard {
+		err = inTx(ctx, actor.db, actor.retry, func(tx *sql.Tx) error {
+			if err := actor.lockUsers(ctx, tx, like.actorId, like.recipientId); err != nil {
+				return err
+			}
+			if err := inTx(ctx, recipient.db, recipient.retry, readLike); err != nil {
+				return err
+			}
+			return writeSentLike(tx)
+		})
+	} else {
+		err = inTx(ctx, recipient.db, recipient.retry, func(tx *sql.Tx) error {
+			if err := readLike(tx); err != nil {
+				return err
+			}
+			return inTx(ctx, actor.db, actor.retry, writeSentLike)
+		})
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to repair the sent like of %s to %s: %w", like.actorId, like.recipientId, err)
+	}
+	return changed, nil
+}
+
+// writeSentLike keeps the like of a decision whose recipient lives on another shard in sent_likes, a pass deleting
+// it, and updates the matches of the actor with the like of the recipient stored on this shard
+func (w DatabaseWriter) writeSentLike(ctx context.Context, tx *sql.Tx, entry PutDecisionEntry) error {
+	if err := w.lockUsers(ctx, tx, entry.ActorId, entry.RecipientId); err != nil {
+		return err
+	}
+	if err := w.restoreDecisions(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	var sent int
+	if err := w.statements.queryRow(ctx, tx, readSentLikeQuery, []any{entry.ActorId, entry.RecipientId}, &sent); err != nil {
+		return err
+	}
+	query := deleteSentLikeQuery
+	if entry.Like {
+		query = insertSentLikeQuery
+	}
+	if _, err := w.statements.exec(ctx, tx, query, entry.ActorId, entry.RecipientId); err != nil {
+		return err
+	}
+
+	var reverseLikes int
+	if err := w.statements.queryRow(ctx, tx, readReverseLikeQuery, []any{entry.RecipientId, entry.ActorId}, &reverseLikes); err != nil {
+		return err
+	}
+
+	previous := DecisionState{Exists: sent > 0, Liked: sent > 0}
+	if _, _, matches := CounterDeltas(previous, entry.Like, reverseLikes > 0); matches != 0 {
+		return w.statements.execAffecting(ctx, tx, 1, updateMatchesQuery, matches, entry.ActorId, entry.ActorId)
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ Reader            = (*ShardRouter)(nil)
+	_ Writer            = (*ShardRouter)(nil)
+	_ CounterReconciler = (*ShardRouter)(nil)
+	_ DecisionArchiver  = (*ShardRouter)(nil)
+)
@@ -0,0 +1,205 @@
+package database_test
+
+import (
+	"app/database"
+	"app/database/storagetest"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestBucket(t *testing.T) {
+	// CRC32('1') % 1024 in MySQL
+	assert.Equal(t, 951, database.Bucket("1"))
+	assert.Equal(t, database.Bucket("42"), database.Bucket("042"))
+	assert.That(t, database.Bucket("not a number") < database.Buckets)
+}
+
+func TestParseShardMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		shards int
+		valid  bool
+		shard  map[int]int
+	}{
+		{name: "single_shard", s: "0-1023:0", shards: 1, valid: true, shard: map[int]int{0: 0, 1023: 0}},
+		{name: "ranges", s: "0-511:0, 512-1022:1,1023:0", shards: 2, valid: true, shard: map[int]int{511: 0, 512: 1, 1022: 1, 1023: 0}},
+		{name: "missing_bucket", s: "0-511:0,513-1023:1", shards: 2},
+		{name: "bucket_assigned_twice", s: "0-512:0,512-1023:1", shards: 2},
+		{name: "unknown_shard", s: "0-511:0,512-1023:2", shards: 2},
+		{name: "bucket_out_of_range", s: "0-1024:0", shards: 1},
+		{name: "reversed_range", s: "1023-0:0", shards: 1},
+		{name: "missing_shard", s: "0-1023", shards: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := database.ParseShardMap(test.s, test.shards)
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			for bucket, shard := range test.shard {
+				assert.Equal(t, shard, m[bucket])
+			}
+
+			parsed, err := database.ParseShardMap(m.String(), test.shards)
+			assert.NoError(t, err)
+			assert.Equal(t, m, parsed)
+		})
+	}
+}
+
+func TestShardMapMove(t *testing.T) {
+	m, err := database.ParseShardMap("0-1023:0", 2)
+	assert.NoError(t, err)
+	r, err := database.ParseBucketRange("512-767")
+	assert.NoError(t, err)
+
+	moved := m.Move(r, 1)
+	assert.Equal(t, "0-511:0,512-767:1,768-1023:0", moved.String())
+	assert.Equal(t, "0-1023:0", m.String())
+}
+
+// interleaved spreads the buckets over two shards by parity, so the first users of a test live on both
+func interleaved() database.ShardMap {
+	var buckets database.ShardMap
+	for bucket := range buckets {
+		buckets[bucket] = bucket % 2
+	}
+	return buckets
+}
+
+// TestShardedConformance runs the storage suite against a ShardRouter over two migrated MySQL databases when
+// MYSQL_TEST_DSN and MYSQL_SHARD_TEST_DSN are set. The tables of both are emptied before every test.
+func TestShardedConformance(t *testing.T) {
+	dbs := openShards(t)
+	buckets := interleaved()
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		for _, db := range dbs {
+			truncate(t, db)
+		}
+		router, err := database.NewShardRouter(dbs, buckets, database.QueryOptions{})
+		assert.NoError(t, err)
+
+		lastId := 0
+		return storagetest.Backend{
+			Reader:     router,
+			Writer:     router,
+			Reconciler: router,
+			Archiver:   router,
+			AddUser: func(ctx context.Context, user database.UserModel) (string, error) {
+				lastId++
+				id := strconv.Itoa(lastId)
+				return id, addShardedUser(ctx, dbs, buckets, id, user)
+			},
+		}
+	})
+}
+
+// openShards opens the databases of MYSQL_TEST_DSN and MYSQL_SHARD_TEST_DSN, see testDSN
+func openShards(t *testing.T) []*sql.DB {
+	var dbs []*sql.DB
+	for _, key := range []string{"MYSQL_TEST_DSN", "MYSQL_SHARD_TEST_DSN"} {
+		db, err := sql.Open("mysql", testDSN(t, key))
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+// addShardedUser copies the user to every shard, with its counters on the shard of its bucket only
+func addShardedUser(ctx context.Context, dbs []*sql.DB, buckets database.ShardMap, id string, user database.UserModel) error {
+	for index, db := range dbs {
+		counters := []any{0, 0, 0}
+		if index == buckets.Shard(id) {
+			counters = []any{user.Likes, user.NewLikes, user.Matches}
+		}
+		_, err := db.ExecContext(ctx,
+			`INSERT INTO users (id, name, likes, new_likes, matches, gender, is_active, birth_date, latitude, longitude, bio, photo_count, last_active_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+			append(append(append([]any{id, user.Name}, counters...), user.Gender, user.IsAactive), user.ProfileArgs()...)...,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestFailedCommitIsRepaired fails the first commit of decisions spanning two shards, leaving a decision without its
+// sent like and a sent like without its decision, and checks that ReconcileCounters repairs both pairs
+func TestFailedCommitIsRepaired(t *testing.T) {
+	ctx := context.Background()
+	dbs := openShards(t)
+	buckets := interleaved()
+	for _, db := range dbs {
+		truncate(t, db)
+	}
+	router, err := database.NewShardRouter(dbs, buckets, database.QueryOptions{})
+	assert.NoError(t, err)
+
+	// a lives on shard 0, b and c on shard 1
+	var a, b, c string
+	for id := 1; a == "" || c == ""; id++ {
+		userId := strconv.Itoa(id)
+		switch {
+		case buckets.Shard(userId) == 0 && a == "":
+			a = userId
+		case buckets.Shard(userId) == 1 && b == "":
+			b = userId
+		case buckets.Shard(userId) == 1 && c == "":
+			c = userId
+		}
+	}
+	for _, userId := range []string{a, b, c} {
+		assert.NoError(t, addShardedUser(ctx, dbs, buckets, userId, database.UserModel{Name: "user " + userId, IsAactive: true}))
+	}
+	assert.NoError(t, router.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: b, RecipientId: a, Like: true}))
+	assert.NoError(t, router.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: a, RecipientId: c, Like: true}))
+
+	failing := router.WithCommitError(errors.New("commit failed"))
+	// the sent like on shard 0 is rolled back, the decision on shard 1 is kept
+	assert.Error(t, failing.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: a, RecipientId: b, Like: true}))
+	// the decision on shard 0 is rolled back, the sent like on shard 1 is kept
+	assert.Error(t, failing.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: c, RecipientId: a, Like: true}))
+
+	matches := func(userId string) uint {
+		user, err := router.GetUserById(ctx, userId)
+		assert.NoError(t, err)
+		return user.Matches
+	}
+	isMatch := func(actorId string, recipientId string) bool {
+		match, err := router.GetIsMatch(ctx, actorId, recipientId)
+		assert.NoError(t, err)
+		return match
+	}
+	assert.True(t, isMatch(a, b))
+	assert.Equal(t, uint(0), matches(a))
+	assert.False(t, isMatch(c, a))
+	assert.Equal(t, uint(1), matches(c))
+
+	var afterUserId uint
+	for {
+		lastUserId, _, err := router.ReconcileCounters(ctx, afterUserId, 2)
+		assert.NoError(t, err)
+		if lastUserId == 0 {
+			break
+		}
+		afterUserId = lastUserId
+	}
+
+	assert.Equal(t, uint(1), matches(a))
+	assert.Equal(t, uint(1), matches(b))
+	assert.Equal(t, uint(0), matches(c))
+	assert.True(t, isMatch(a, b))
+	assert.False(t, isMatch(c, a))
+}
@@ -0,0 +1,317 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"app/database"
+)
+
+const lastUserIdQuery = `
+SELECT id
+FROM users
+ORDER BY id DESC
+LIMIT 1;
+`
+
+const insertUsersQuery = `
+INSERT INTO users (
+	id,
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	is_active,
+	birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	last_active_at
+) VALUES %s;
+`
+
+// insertUsersRow converts the unix timestamp of last_active_at
+const insertUsersRow = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime(?, 'unixepoch'))"
+
+// upsertUsersQuery leaves the counters of the existing users to the reconciliation
+const upsertUsersQuery = `
+INSERT INTO users (
+	id,
+	name,
+	likes,
+	new_likes,
+	matches,
+	gender,
+	is_active,
+	birth_date,
+	latitude,
+	longitude,
+	bio,
+	photo_count,
+	last_active_at
+) VALUES %s
+ON CONFLICT (id) DO UPDATE SET
+	name = excluded.name,
+	gender = excluded.gender,
+	is_active = excluded.is_active,
+	birth_date = excluded.birth_date,
+	latitude = excluded.latitude,
+	longitude = excluded.longitude,
+	bio = excluded.bio,
+	photo_count = excluded.photo_count,
+	last_active_at = excluded.last_active_at;
+`
+
+const insertDecisionsQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+) VALUES %s;
+`
+
+// upsertDecisionsQuery keeps the ID of the existing decisions
+const upsertDecisionsQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+) VALUES %s
+ON CONFLICT (actor_id, recipient_id) DO UPDATE SET
+	liked = excluded.liked,
+	is_new = excluded.is_new,
+	created_at = excluded.created_at,
+	updated_at = excluded.updated_at;
+`
+
+// deleteArchivedPairsQuery deletes the archived decisions of the pairs of users of %s, the PairConditions of a batch
+const deleteArchivedPairsQuery = `
+DELETE FROM decisions_archive
+WHERE %s;
+`
+
+// readUserIdsQuery reads the existing users of %s, a list of placeholders
+const readUserIdsQuery = `
+SELECT id
+FROM users
+WHERE id IN (%s);
+`
+
+const exportUsersQuery = `
+SELECT` + userColumns + `
+FROM users
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
+`
+
+const exportDecisionsQuery = `
+SELECT *
+FROM (
+	SELECT
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		CAST(strftime('%s', created_at) AS INTEGER) AS created_at,
+		CAST(strftime('%s', updated_at) AS INTEGER) AS updated_at
+	FROM decisions
+	WHERE id > ?1
+	UNION ALL
+	SELECT
+		id,
+		actor_id,
+		recipient_id,
+		liked,
+		is_new,
+		CAST(strftime('%s', created_at) AS INTEGER) AS created_at,
+		CAST(strftime('%s', updated_at) AS INTEGER) AS updated_at
+	FROM decisions_archive
+	WHERE id > ?1
+)
+ORDER BY id
+LIMIT ?2;
+`
+
+// insertDecisionsRow converts the unix timestamps of created_at and updated_at, the current time when NULL
+const insertDecisionsRow = "(?, ?, ?, ?, COALESCE(datetime(?, 'unixepoch'), CURRENT_TIMESTAMP), COALESCE(datetime(?, 'unixepoch'), CURRENT_TIMESTAMP))"
+
+func (w DatabaseWriter) LastUserId(ctx context.Context) (uint, error) {
+	var id int64
+	err := w.db.QueryRowContext(ctx, lastUserIdQuery).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the last user id: %w", err)
+	}
+	return uint(id), nil
+}
+
+func (w DatabaseWriter) InsertUsers(ctx context.Context, users []database.UserModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+	if len(users) > database.MaxBulkRows {
+		return fmt.Errorf("unable to insert users: %d rows exceed the maximum of %d", len(users), database.MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(users)*13)
+	for _, user := range users {
+		id, ok := parseId(user.Id)
+		if !ok {
+			return fmt.Errorf("unable to insert users: invalid user id %q", user.Id)
+		}
+		args = append(args, id, user.Name, user.Likes, user.NewLikes, user.Matches, user.Gender, user.IsAactive)
+		args = append(args, user.ProfileArgs()...)
+	}
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(insertUsersQuery, database.BulkRows(len(users), insertUsersRow)), args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert users: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseWriter) InsertDecisions(ctx context.Context, decisions []database.BulkDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	if len(decisions) > database.MaxBulkRows {
+		return fmt.Errorf("unable to insert decisions: %d rows exceed the maximum of %d", len(decisions), database.MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(decisions)*6)
+	for _, decision := range decisions {
+		args = append(args, decision.ActorId, decision.RecipientId, decision.Liked, decision.IsNew)
+		args = append(args, decision.TimestampArgs()...)
+	}
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(insertDecisionsQuery, database.BulkRows(len(decisions), insertDecisionsRow)), args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert decisions: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseWriter) UpsertUsers(ctx context.Context, users []database.UserModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+	if len(users) > database.MaxBulkRows {
+		return fmt.Errorf("unable to upsert users: %d rows exceed the maximum of %d", len(users), database.MaxBulkRows)
+	}
+
+	args := make([]any, 0, len(users)*13)
+	for _, user := range users {
+		id, ok := parseId(user.Id)
+		if !ok {
+			return fmt.Errorf("unable to upsert users: invalid user id %q", user.Id)
+		}
+		args = append(args, id, user.Name, 0, 0, 0, user.Gender, user.IsAactive)
+		args = append(args, user.ProfileArgs()...)
+	}
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(upsertUsersQuery, database.BulkRows(len(users), insertUsersRow)), args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upsert users: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseWriter) UpsertDecisions(ctx context.Context, decisions []database.BulkDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	if len(decisions) > database.MaxBulkRows {
+		return fmt.Errorf("unable to upsert decisions: %d rows exceed the maximum of %d", len(decisions), database.MaxBulkRows)
+	}
+
+	pairs := make([]any, 0, len(decisions)*2)
+	args := make([]any, 0, len(decisions)*6)
+	for _, decision := range decisions {
+		pairs = append(pairs, decision.ActorId, decision.RecipientId)
+		args = append(args, decision.ActorId, decision.RecipientId, decision.Liked, decision.IsNew)
+		args = append(args, decision.TimestampArgs()...)
+	}
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(deleteArchivedPairsQuery, database.PairConditions(len(decisions))), pairs...); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(upsertDecisionsQuery, database.BulkRows(len(decisions), insertDecisionsRow)), args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upsert decisions: %w", err)
+	}
+	return nil
+}
+
+func (w DatabaseWriter) FindUserIds(ctx context.Context, ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) > database.MaxBulkRows {
+		return nil, fmt.Errorf("unable to read user ids: %d ids exceed the maximum of %d", len(ids), database.MaxBulkRows)
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := w.db.QueryContext(ctx, fmt.Sprintf(readUserIdsQuery, strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read user ids: %w", err)
+	}
+	defer rows.Close()
+
+	var found []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("unable to read user ids: %w", err)
+		}
+		found = append(found, id)
+	}
+	return found, rows.Err()
+}
+
+func (w DatabaseWriter) ExportUsers(ctx context.Context, afterId uint, limit int) ([]database.UserModel, error) {
+	users, err := database.ScanUsers(w.db.QueryContext(ctx, exportUsersQuery, afterId, limit))
+	if err != nil {
+		return nil, fmt.Errorf("unable to export users: %w", err)
+	}
+	return users, nil
+}
+
+func (w DatabaseWriter) ExportDecisions(ctx context.Context, afterId uint, limit int) ([]database.BulkDecision, error) {
+	decisions, err := database.ScanBulkDecisions(w.db.QueryContext(ctx, exportDecisionsQuery, afterId, limit))
+	if err != nil {
+		return nil, fmt.Errorf("unable to export decisions: %w", err)
+	}
+	return decisions, nil
+}
+
+// Interface guards
+var (
+	_ database.BulkWriter   = (*DatabaseWriter)(nil)
+	_ database.BulkImporter = (*DatabaseWriter)(nil)
+	_ database.BulkExporter = (*DatabaseWriter)(nil)
+)
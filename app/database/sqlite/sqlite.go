@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"app/database"
+	"app/database/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// Open opens (creating it when missing) the SQLite database stored in path and applies the pending migrations
+func Open(path string) (*sql.DB, error) {
+	pragmas := url.Values{"_pragma": {
+		"foreign_keys(1)",
+		"busy_timeout(5000)",
+		"journal_mode(WAL)",
+	}}
+	// immediate transactions take the write lock upfront so concurrent writers wait on busy_timeout instead of failing
+	pragmas.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateUp(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func migrateUp(db *sql.DB) error {
+	source, err := migrations.Source(migrations.SQLite)
+	if err != nil {
+		return err
+	}
+	driver, err := migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	if err != nil {
+		return err
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("unable to migrate sqlite database: %w", err)
+	}
+	return nil
+}
+
+// AddUser inserts a user with its counters as given and returns its ID, used to seed development databases and by tests
+func AddUser(ctx context.Context, db *sql.DB, user database.UserModel) (string, error) {
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO users (name, likes, new_likes, matches, gender, is_active, birth_date, latitude, longitude, bio, photo_count, last_active_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime(?, 'unixepoch'))`,
+		append([]any{user.Name, user.Likes, user.NewLikes, user.Matches, user.Gender, user.IsAactive}, user.ProfileArgs()...)...,
+	)
+	if err != nil {
+		return "", fmt.Errorf("unable to insert user: %w", err)
+	}
+	id, err := result.LastInsertId()
+	return strconv.FormatInt(id, 10), err
+}
+
+// AddDecision inserts a decision with an explicit is_new flag, without touching the counters, and returns its ID
+func AddDecision(ctx context.Context, db *sql.DB, actorId string, recipientId string, liked bool, isNew bool) (string, error) {
+	result, err := db.ExecContext(ctx,
+		"INSERT INTO decisions (actor_id, recipient_id, liked, is_new) VALUES (?, ?, ?, ?)",
+		actorId, recipientId, liked, isNew,
+	)
+	if err != nil {
+		return "", fmt.Errorf("unable to insert decision: %w", err)
+	}
+	id, err := result.LastInsertId()
+	return strconv.FormatInt(id, 10), err
+}
+
+// parseId converts a user ID the way MySQL compares a string with an INT column: IDs that are not numbers never match
+func parseId(id string) (int64, bool) {
+	parsed, err := strconv.ParseInt(id, 10, 32)
+	if err != nil || parsed <= 0 {
+		return 0, false
+	}
+	return parsed, true
+}
@@ -0,0 +1,52 @@
+package sqlite_test
+
+import (
+	"app/database"
+	"app/database/sqlite"
+	"app/database/storagetest"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		db, err := sqlite.Open(filepath.Join(t.TempDir(), "muzzapp.db"))
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		writer := sqlite.NewDatabaseWriter(db)
+		return storagetest.Backend{
+			Reader:     sqlite.NewDatabaseReader(db),
+			Writer:     writer,
+			Reconciler: writer,
+			Archiver:   writer,
+			AddUser: func(ctx context.Context, user database.UserModel) (string, error) {
+				return sqlite.AddUser(ctx, db, user)
+			},
+			Bulk:     writer,
+			Importer: writer,
+			Exporter: writer,
+		}
+	})
+}
+
+func TestOpenIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "muzzapp.db")
+
+	db, err := sqlite.Open(path)
+	assert.NoError(t, err)
+	id, err := sqlite.AddUser(context.Background(), db, database.UserModel{Name: "user", Gender: "f", IsAactive: true})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	db, err = sqlite.Open(path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	user, err := sqlite.NewDatabaseReader(db).GetUserById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", user.Name)
+}
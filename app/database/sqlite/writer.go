@@ -0,0 +1,400 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"app/database"
+)
+
+type DatabaseWriter struct {
+	db *sql.DB
+}
+
+func NewDatabaseWriter(db *sql.DB) DatabaseWriter {
+	return DatabaseWriter{db}
+}
+
+// restoreDecisionsQuery moves the archived decisions between two users back to decisions, in both directions, so
+// a new decision finds the previous one and the like it answers
+const restoreDecisionsQuery = `
+INSERT OR IGNORE INTO decisions (
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+)
+SELECT
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+FROM decisions_archive
+WHERE (actor_id = ?1 AND recipient_id = ?2)
+OR (actor_id = ?2 AND recipient_id = ?1);
+`
+
+const deleteRestoredDecisionsQuery = `
+DELETE FROM decisions_archive
+WHERE (actor_id = ?1 AND recipient_id = ?2)
+OR (actor_id = ?2 AND recipient_id = ?1);
+`
+
+const readDecisionStateQuery = `
+SELECT
+	liked,
+	is_new
+FROM decisions
+WHERE actor_id = ?
+AND recipient_id = ?;
+`
+
+const readReverseLikeQuery = `
+SELECT count(*)
+FROM decisions
+WHERE actor_id = ?
+AND recipient_id = ?
+AND actor_id <> recipient_id
+AND liked;
+`
+
+// insertOrUpdateDecisionQuery flags likes as new, and a decision as new again when it turns into a like
+const insertOrUpdateDecisionQuery = `
+INSERT INTO decisions (
+	actor_id,
+	recipient_id,
+	liked,
+	is_new
+) VALUES (?1, ?2, ?3, ?3)
+ON CONFLICT (actor_id, recipient_id) DO UPDATE SET
+	is_new = CASE WHEN decisions.liked = excluded.liked THEN decisions.is_new ELSE excluded.is_new END,
+	liked = excluded.liked;
+`
+
+const updateRecipientCountersQuery = `
+UPDATE users
+SET likes = likes + ?,
+	new_likes = new_likes + ?
+WHERE id = ?;
+`
+
+const updateMatchesQuery = `
+UPDATE users
+SET matches = matches + ?
+WHERE id IN (?, ?);
+`
+
+const updateDecisionLikesQuery = `
+UPDATE decisions
+SET is_new = 0
+WHERE recipient_id = ?
+AND id IN (%s)
+AND liked
+AND is_new;
+`
+
+const updateNewLikesQuery = `
+UPDATE users
+SET new_likes = new_likes - ?
+WHERE id = ?;
+`
+
+const readUsersBatchQuery = `
+SELECT max(id)
+FROM (
+	SELECT id
+	FROM users
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?
+);
+`
+
+// reconcileCountersQuery counts the archived likes too, which are never new
+const reconcileCountersQuery = `
+UPDATE users
+SET likes = expected.likes,
+	new_likes = expected.new_likes,
+	matches = expected.matches
+FROM (
+	SELECT
+		u.id,
+		(SELECT count(*)
+			FROM decisions d
+			WHERE d.recipient_id = u.id
+			AND d.liked) + (
+		SELECT count(*)
+			FROM decisions_archive d
+			WHERE d.recipient_id = u.id
+			AND d.liked) AS likes,
+		(SELECT count(*)
+			FROM decisions d
+			WHERE d.recipient_id = u.id
+			AND d.liked
+			AND d.is_new) AS new_likes,
+		(SELECT count(*)
+			FROM decisions d
+			JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+			WHERE d.actor_id = u.id
+			AND d.recipient_id <> u.id
+			AND d.liked
+			AND r.liked) + (
+		SELECT count(*)
+			FROM decisions d
+			JOIN decisions_archive r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+			WHERE d.actor_id = u.id
+			AND d.recipient_id <> u.id
+			AND d.liked
+			AND r.liked) + (
+		SELECT count(*)
+			FROM decisions_archive d
+			JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+			WHERE d.actor_id = u.id
+			AND d.recipient_id <> u.id
+			AND d.liked
+			AND r.liked) + (
+		SELECT count(*)
+			FROM decisions_archive d
+			JOIN decisions_archive r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+			WHERE d.actor_id = u.id
+			AND d.recipient_id <> u.id
+			AND d.liked
+			AND r.liked) AS matches
+	FROM users u
+	WHERE u.id > ?
+	AND u.id <= ?
+) AS expected
+WHERE users.id = expected.id
+AND (users.likes, users.new_likes, users.matches) IS NOT (expected.likes, expected.new_likes, expected.matches);
+`
+
+// inTx runs fn in a transaction, committed when fn succeeds. Transactions are immediate (see Open),
+// so they are serialised and need no row locks.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (w DatabaseWriter) InsertOrUpdateDecision(ctx context.Context, entry database.PutDecisionEntry) error {
+	actor, ok := parseId(entry.ActorId)
+	if !ok {
+		return fmt.Errorf("unable to insert or update decision: invalid actor id %q", entry.ActorId)
+	}
+	recipient, ok := parseId(entry.RecipientId)
+	if !ok {
+		return fmt.Errorf("unable to insert or update decision: invalid recipient id %q", entry.RecipientId)
+	}
+
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		for _, query := range []string{restoreDecisionsQuery, deleteRestoredDecisionsQuery} {
+			if _, err := tx.ExecContext(ctx, query, actor, recipient); err != nil {
+				return err
+			}
+		}
+
+		previous := database.DecisionState{Exists: true}
+		err := tx.QueryRowContext(ctx, readDecisionStateQuery, actor, recipient).Scan(&previous.Liked, &previous.IsNew)
+		if err == sql.ErrNoRows {
+			previous = database.DecisionState{}
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, insertOrUpdateDecisionQuery, actor, recipient, entry.Like); err != nil {
+			return err
+		}
+
+		var reverseLikes int
+		if err := tx.QueryRowContext(ctx, readReverseLikeQuery, recipient, actor).Scan(&reverseLikes); err != nil {
+			return err
+		}
+
+		likes, newLikes, matches := database.CounterDeltas(previous, entry.Like, reverseLikes > 0)
+		if likes != 0 {
+			if _, err := tx.ExecContext(ctx, updateRecipientCountersQuery, likes, newLikes, recipient); err != nil {
+				return err
+			}
+		}
+		if matches != 0 {
+			if _, err := tx.ExecContext(ctx, updateMatchesQuery, matches, actor, recipient); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to insert or update decision: %w", err)
+	}
+
+	return nil
+}
+
+func (w DatabaseWriter) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []database.DecisionModel) error {
+	recipient, ok := parseId(RecipientId)
+	if !ok || len(likes) == 0 {
+		return nil
+	}
+
+	args := []interface{}{recipient}
+	placeholders := make([]string, 0, len(likes))
+	for _, like := range likes {
+		if id, ok := parseId(like.Id); ok {
+			args = append(args, id)
+			placeholders = append(placeholders, "?")
+		}
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(updateDecisionLikesQuery, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return err
+		}
+		viewed, err := result.RowsAffected()
+		if err != nil || viewed == 0 {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, updateNewLikesQuery, viewed, recipient)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update decisions: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileCounters recomputes the counters of a batch of users from their decisions
+func (w DatabaseWriter) ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (uint, int, error) {
+	var lastUserId sql.NullInt64
+	var repaired int64
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, readUsersBatchQuery, int64(afterUserId), limit).Scan(&lastUserId)
+		if err != nil || !lastUserId.Valid {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, reconcileCountersQuery, int64(afterUserId), lastUserId.Int64)
+		if err != nil {
+			return err
+		}
+		repaired, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to reconcile counters: %w", err)
+	}
+
+	return uint(lastUserId.Int64), int(repaired), nil
+}
+
+const readDecisionsBatchQuery = `
+SELECT max(id)
+FROM (
+	SELECT id
+	FROM decisions
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?
+);
+`
+
+// archiveDecisionsQuery copies the decisions of a batch selected by the policy. A pair of users already archived is
+// left in decisions, which is read first.
+const archiveDecisionsQuery = `
+INSERT OR IGNORE INTO decisions_archive (
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+)
+SELECT
+	d.id,
+	d.actor_id,
+	d.recipient_id,
+	d.liked,
+	d.is_new,
+	d.created_at,
+	d.updated_at
+FROM decisions d
+JOIN users a ON a.id = d.actor_id
+WHERE d.id > ?1
+AND d.id <= ?2
+AND (
+	(NOT d.liked AND d.updated_at < datetime(?3, 'unixepoch'))
+	OR (d.liked
+		AND NOT d.is_new
+		AND NOT a.is_active
+		AND COALESCE(a.last_active_at, a.created_at) < datetime(?4, 'unixepoch'))
+);
+`
+
+// deleteArchivedDecisionsQuery deletes the decisions of a batch copied to the archive
+const deleteArchivedDecisionsQuery = `
+DELETE FROM decisions
+WHERE id > ?1
+AND id <= ?2
+AND id IN (
+	SELECT id
+	FROM decisions_archive
+	WHERE id > ?1
+	AND id <= ?2
+);
+`
+
+// ArchiveDecisions moves a batch of decisions to decisions_archive in one transaction
+func (w DatabaseWriter) ArchiveDecisions(ctx context.Context, policy database.ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	var lastId sql.NullInt64
+	var archived int64
+	err := inTx(ctx, w.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, readDecisionsBatchQuery, int64(afterId), limit).Scan(&lastId)
+		if err != nil || !lastId.Valid {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, archiveDecisionsQuery, int64(afterId), lastId.Int64, int64(policy.PassesBefore), int64(policy.InactiveLikesBefore))
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, deleteArchivedDecisionsQuery, int64(afterId), lastId.Int64)
+		if err != nil {
+			return err
+		}
+		archived, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to archive decisions: %w", err)
+	}
+
+	return uint(lastId.Int64), int(archived), nil
+}
+
+// Interface guards
+var (
+	_ database.Writer            = (*DatabaseWriter)(nil)
+	_ database.CounterReconciler = (*DatabaseWriter)(nil)
+	_ database.DecisionArchiver  = (*DatabaseWriter)(nil)
+)
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type QueryOptions struct {
+	// SlowQueryThreshold is the duration above which a query is logged, 0 disables the log
+	SlowQueryThreshold time.Duration
+	// Retry reruns the reads and the transactions failing with a transient error
+	Retry RetryPolicy
+}
+
+// statements prepares the fixed queries of a database once and times every execution. database/sql re-prepares a
+// statement on the connections it was not prepared on yet, so they survive reconnections. The queries built at run
+// time, e.g. for a number of rows or of conditions, run unprepared, so the prepared statements stay bounded.
+type statements struct {
+	db      *sql.DB
+	options QueryOptions
+	// fixed holds the queries prepared on first use
+	fixed map[string]bool
+
+	mu       sync.Mutex
+	prepared map[string]*sql.Stmt
+}
+
+// querier runs the queries built at run time, on a *sql.DB or a *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func newStatements(db *sql.DB, options QueryOptions, queries ...string) *statements {
+	fixed := make(map[string]bool, len(queries))
+	for _, query := range queries {
+		fixed[query] = true
+	}
+	return &statements{db: db, options: options, fixed: fixed, prepared: map[string]*sql.Stmt{}}
+}
+
+// prepareStatements prepares queries upfront, so a broken query or an unreachable database fails on start
+func prepareStatements(ctx context.Context, db *sql.DB, options QueryOptions, queries ...string) (*statements, error) {
+	s := newStatements(db, options, queries...)
+	for _, query := range queries {
+		if _, err := s.stmt(ctx, query); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// stmt returns the statement of a fixed query, preparing it on first use
+func (s *statements) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.prepared[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare query %q: %w", compact(query), err)
+	}
+	s.prepared[query] = stmt
+	return stmt, nil
+}
+
+// bind binds the statement of query to tx when there is one. It returns no statement for a query built at run time.
+func (s *statements) bind(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	if !s.fixed[query] {
+		return nil, nil
+	}
+	stmt, err := s.stmt(ctx, query)
+	if err != nil || tx == nil {
+		return stmt, err
+	}
+	return tx.StmtContext(ctx, stmt), nil
+}
+
+// unprepared returns what a query built at run time runs on
+func (s *statements) unprepared(tx *sql.Tx) querier {
+	if tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+func (s *statements) query(ctx context.Context, tx *sql.Tx, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := s.bind(ctx, tx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.timed(query, time.Now())
+	if stmt == nil {
+		return s.unprepared(tx).QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// queryRow scans the single row of query into dest
+func (s *statements) queryRow(ctx context.Context, tx *sql.Tx, query string, args []any, dest ...any) error {
+	stmt, err := s.bind(ctx, tx, query)
+	if err != nil {
+		return err
+	}
+	defer s.timed(query, time.Now())
+	if stmt == nil {
+		return s.unprepared(tx).QueryRowContext(ctx, query, args...).Scan(dest...)
+	}
+	return stmt.QueryRowContext(ctx, args...).Scan(dest...)
+}
+
+func (s *statements) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	stmt, err := s.bind(ctx, tx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.timed(query, time.Now())
+	if stmt == nil {
+		return s.unprepared(tx).ExecContext(ctx, query, args...)
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// execAffecting runs a write expected to change exactly affected rows
+func (s *statements) execAffecting(ctx context.Context, tx *sql.Tx, affected int64, query string, args ...any) error {
+	result, err := s.exec(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed != affected {
+		return fmt.Errorf("query %q changed %d rows instead of %d", compact(query), changed, affected)
+	}
+	return nil
+}
+
+func (s *statements) timed(query string, start time.Time) {
+	if s.options.SlowQueryThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= s.options.SlowQueryThreshold {
+		log.Printf("Slow query took %s: %s", elapsed, compact(query))
+	}
+}
+
+// Close releases the prepared statements
+func (s *statements) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for query, stmt := range s.prepared {
+		if closeErr := stmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(s.prepared, query)
+	}
+	return err
+}
+
+// compact puts a query on a single line for logs and errors
+func compact(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
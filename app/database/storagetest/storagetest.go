@@ -0,0 +1,914 @@
+// Package storagetest is a conformance suite for database.Reader and database.Writer implementations.
+// Every backend must pass it so they can be swapped without changing the behaviour of the service.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"app/database"
+
+	"github.com/zeebo/assert"
+)
+
+// Backend is a storage implementation under test
+type Backend struct {
+	Reader     database.Reader
+	Writer     database.Writer
+	Reconciler database.CounterReconciler
+	Archiver   database.DecisionArchiver
+	// AddUser inserts a user, with its counters as given, and returns its ID
+	AddUser func(ctx context.Context, user database.UserModel) (string, error)
+	// Bulk is optional, its tests are skipped when the backend has none
+	Bulk database.BulkWriter
+	// Importer and Exporter are optional, their tests are skipped when the backend has none
+	Importer database.BulkImporter
+	Exporter database.BulkExporter
+}
+
+// Factory returns an empty backend, it is called once per test
+type Factory func(t *testing.T) Backend
+
+// Run executes the whole suite against the backends created by newBackend
+func Run(t *testing.T, newBackend Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b Backend)
+	}{
+		{"pagination_boundaries", testPaginationBoundaries},
+		{"pages_do_not_overlap", testPagesDoNotOverlap},
+		{"passes_are_not_listed", testPassesAreNotListed},
+		{"invalid_page", testInvalidPage},
+		{"is_new_transitions", testIsNewTransitions},
+		{"update_likes_as_viewed_only_touches_recipient", testUpdateLikesAsViewedOnlyTouchesRecipient},
+		{"match_symmetry", testMatchSymmetry},
+		{"self_like_is_not_a_match", testSelfLikeIsNotAMatch},
+		{"counters", testCounters},
+		{"reconcile_counters", testReconcileCounters},
+		{"archive_decisions", testArchiveDecisions},
+		{"inactive_and_missing_users", testInactiveAndMissingUsers},
+		{"decision_on_missing_user", testDecisionOnMissingUser},
+		{"concurrent_decisions", testConcurrentDecisions},
+		{"concurrent_updates_of_the_same_decision", testConcurrentUpdatesOfTheSameDecision},
+		{"concurrent_mutual_likes", testConcurrentMutualLikes},
+		{"concurrent_archive_and_decisions", testConcurrentArchiveAndDecisions},
+		{"bulk_writer", testBulkWriter},
+		{"bulk_import_export", testBulkImportExport},
+		{"user_profile", testUserProfile},
+		{"find_users_by_ids", testFindUsersByIds},
+		{"find_users", testFindUsers},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newBackend(t))
+		})
+	}
+}
+
+func addUsers(t *testing.T, b Backend, count int) []string {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		id, err := b.AddUser(context.Background(), database.UserModel{
+			Name:      fmt.Sprintf("user %d", i),
+			Gender:    "f",
+			IsAactive: true,
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func decide(t *testing.T, b Backend, actorId string, recipientId string, like bool) {
+	err := b.Writer.InsertOrUpdateDecision(context.Background(), database.PutDecisionEntry{
+		ActorId:     actorId,
+		RecipientId: recipientId,
+		Like:        like,
+	})
+	assert.NoError(t, err)
+}
+
+func actorIds(decisions []database.DecisionModel) []string {
+	ids := make([]string, 0, len(decisions))
+	for _, decision := range decisions {
+		ids = append(ids, fmt.Sprint(decision.ActorId))
+	}
+	return ids
+}
+
+func testPaginationBoundaries(t *testing.T, b Backend) {
+	ctx := context.Background()
+	limit := b.Reader.GetLimit()
+	users := addUsers(t, b, 2*limit+2)
+	recipient := users[0]
+
+	page, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+
+	for _, actor := range users[1 : limit+1] {
+		decide(t, b, actor, recipient, true)
+	}
+
+	page, err = b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, limit, len(page))
+	page, err = b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+
+	decide(t, b, users[limit+1], recipient, true)
+
+	page, err = b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, users[limit+1], fmt.Sprint(page[0].ActorId))
+
+	newPage, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(newPage))
+
+	page, err = b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+}
+
+func testPagesDoNotOverlap(t *testing.T, b Backend) {
+	ctx := context.Background()
+	limit := b.Reader.GetLimit()
+	users := addUsers(t, b, 2*limit+4)
+	recipient := users[0]
+	for _, actor := range users[1:] {
+		decide(t, b, actor, recipient, true)
+	}
+
+	seen := map[string]bool{}
+	for page := 1; page <= 3; page++ {
+		likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, page)
+		assert.NoError(t, err)
+		for _, like := range likes {
+			assert.Equal(t, recipient, fmt.Sprint(like.RecipientId))
+			assert.True(t, like.Liked)
+			assert.False(t, seen[like.Id])
+			seen[like.Id] = true
+		}
+	}
+	assert.Equal(t, len(users)-1, len(seen))
+}
+
+func testPassesAreNotListed(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 4)
+	recipient := users[0]
+	decide(t, b, users[1], recipient, true)
+	decide(t, b, users[2], recipient, false)
+	decide(t, b, users[3], recipient, true)
+	decide(t, b, users[3], recipient, false)
+
+	likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[1]}, actorIds(likes))
+
+	newLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[1]}, actorIds(newLikes))
+}
+
+func testInvalidPage(t *testing.T, b Backend) {
+	users := addUsers(t, b, 1)
+
+	_, err := b.Reader.FindLikesByRecipientIdPaginated(context.Background(), users[0], 0)
+	assert.Error(t, err)
+	_, err = b.Reader.FindNewLikesByRecipientIdPaginated(context.Background(), users[0], -1)
+	assert.Error(t, err)
+}
+
+func testIsNewTransitions(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 4)
+	recipient := users[0]
+	decide(t, b, users[1], recipient, true)
+	decide(t, b, users[2], recipient, true)
+
+	newLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[1], users[2]}, actorIds(newLikes))
+
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, newLikes[:1]))
+	newLikes, err = b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[2]}, actorIds(newLikes))
+
+	// viewing again or viewing nothing is a no-op
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, newLikes))
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, newLikes))
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, nil))
+	newLikes, err = b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(newLikes))
+
+	// a viewed like stays viewed when it is liked again
+	decide(t, b, users[1], recipient, true)
+	newLikes, err = b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(newLikes))
+
+	// a like given again after a pass and a pass turned into a like are new
+	decide(t, b, users[1], recipient, false)
+	decide(t, b, users[1], recipient, true)
+	decide(t, b, users[3], recipient, false)
+	decide(t, b, users[3], recipient, true)
+	newLikes, err = b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[1], users[3]}, actorIds(newLikes))
+
+	likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(likes))
+}
+
+func testUpdateLikesAsViewedOnlyTouchesRecipient(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 3)
+	decide(t, b, users[2], users[0], true)
+	decide(t, b, users[2], users[1], true)
+
+	otherLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, users[1], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(otherLikes))
+
+	// the likes belong to another recipient so nothing changes
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, users[0], otherLikes))
+
+	otherLikes, err = b.Reader.FindNewLikesByRecipientIdPaginated(ctx, users[1], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(otherLikes))
+	ownLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, users[0], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ownLikes))
+}
+
+func isMatch(t *testing.T, b Backend, first string, second string) bool {
+	forward, err := b.Reader.GetIsMatch(context.Background(), first, second)
+	assert.NoError(t, err)
+	backward, err := b.Reader.GetIsMatch(context.Background(), second, first)
+	assert.NoError(t, err)
+	assert.Equal(t, forward, backward)
+	return forward
+}
+
+func testMatchSymmetry(t *testing.T, b Backend) {
+	users := addUsers(t, b, 3)
+
+	assert.False(t, isMatch(t, b, users[0], users[1]))
+
+	decide(t, b, users[0], users[1], true)
+	assert.False(t, isMatch(t, b, users[0], users[1]))
+
+	decide(t, b, users[1], users[0], false)
+	assert.False(t, isMatch(t, b, users[0], users[1]))
+
+	decide(t, b, users[1], users[0], true)
+	assert.True(t, isMatch(t, b, users[0], users[1]))
+	assert.False(t, isMatch(t, b, users[0], users[2]))
+
+	decide(t, b, users[0], users[1], false)
+	assert.False(t, isMatch(t, b, users[0], users[1]))
+}
+
+func testSelfLikeIsNotAMatch(t *testing.T, b Backend) {
+	users := addUsers(t, b, 1)
+	decide(t, b, users[0], users[0], true)
+
+	match, err := b.Reader.GetIsMatch(context.Background(), users[0], users[0])
+	assert.NoError(t, err)
+	assert.False(t, match)
+}
+
+func likesOf(t *testing.T, b Backend, userId string) uint {
+	return countersOf(t, b, userId)[0]
+}
+
+// countersOf returns the likes, new likes and matches counters of a user
+func countersOf(t *testing.T, b Backend, userId string) [3]uint {
+	user, err := b.Reader.GetUserById(context.Background(), userId)
+	assert.NoError(t, err)
+	return [3]uint{user.Likes, user.NewLikes, user.Matches}
+}
+
+func testCounters(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 4)
+	recipient := users[0]
+	assert.Equal(t, [3]uint{0, 0, 0}, countersOf(t, b, recipient))
+
+	decide(t, b, users[1], recipient, true)
+	decide(t, b, users[2], recipient, true)
+	decide(t, b, users[3], recipient, false)
+	assert.Equal(t, [3]uint{2, 2, 0}, countersOf(t, b, recipient))
+
+	// deciding the same again changes nothing
+	decide(t, b, users[1], recipient, true)
+	decide(t, b, users[3], recipient, false)
+	assert.Equal(t, [3]uint{2, 2, 0}, countersOf(t, b, recipient))
+
+	// liking back makes a match for both users, the likes of the actor are not the recipient's
+	decide(t, b, recipient, users[1], true)
+	assert.Equal(t, [3]uint{2, 2, 1}, countersOf(t, b, recipient))
+	assert.Equal(t, [3]uint{1, 1, 1}, countersOf(t, b, users[1]))
+
+	newLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, newLikes[:1]))
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, newLikes[:1]))
+	assert.Equal(t, [3]uint{2, 1, 1}, countersOf(t, b, recipient))
+
+	// a viewed like turned into a pass only removes a like and the match
+	decide(t, b, users[1], recipient, false)
+	assert.Equal(t, [3]uint{1, 1, 0}, countersOf(t, b, recipient))
+	assert.Equal(t, [3]uint{1, 1, 0}, countersOf(t, b, users[1]))
+
+	// an unviewed like turned into a pass also removes a new like
+	decide(t, b, users[2], recipient, false)
+	assert.Equal(t, [3]uint{0, 0, 0}, countersOf(t, b, recipient))
+
+	// a self-like is a like but never a match
+	decide(t, b, users[3], users[3], true)
+	assert.Equal(t, [3]uint{1, 1, 0}, countersOf(t, b, users[3]))
+}
+
+func testReconcileCounters(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 3)
+	decide(t, b, users[1], users[0], true)
+	decide(t, b, users[0], users[1], true)
+	decide(t, b, users[2], users[0], true)
+
+	drifted, err := b.AddUser(ctx, database.UserModel{Name: "drifted", Gender: "m", IsAactive: true, Likes: 7, NewLikes: 3, Matches: 1})
+	assert.NoError(t, err)
+	users = append(users, drifted)
+	want := [][3]uint{{2, 2, 1}, {1, 1, 1}, {0, 0, 0}, {0, 0, 0}}
+
+	lastUserId, repaired, err := b.Reconciler.ReconcileCounters(ctx, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, users[1], fmt.Sprint(lastUserId))
+	assert.Equal(t, 0, repaired)
+
+	lastUserId, repaired, err = b.Reconciler.ReconcileCounters(ctx, lastUserId, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, drifted, fmt.Sprint(lastUserId))
+	assert.Equal(t, 1, repaired)
+
+	lastUserId, repaired, err = b.Reconciler.ReconcileCounters(ctx, lastUserId, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), lastUserId)
+	assert.Equal(t, 0, repaired)
+
+	for i, user := range users {
+		assert.Equal(t, want[i], countersOf(t, b, user))
+	}
+}
+
+// archive runs the policy over every decision in batches of two, and returns the number of decisions archived
+func archive(t *testing.T, b Backend, policy database.ArchivePolicy) int {
+	var lastId uint
+	total := 0
+	for {
+		next, archived, err := b.Archiver.ArchiveDecisions(context.Background(), policy, lastId, 2)
+		assert.NoError(t, err)
+		total += archived
+		if next == 0 {
+			return total
+		}
+		assert.That(t, next > lastId)
+		lastId = next
+	}
+}
+
+// reconciled reports whether the counters of every user already match their decisions
+func reconciled(t *testing.T, b Backend) bool {
+	_, repaired, err := b.Reconciler.ReconcileCounters(context.Background(), 0, 100)
+	assert.NoError(t, err)
+	return repaired == 0
+}
+
+func testArchiveDecisions(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 3)
+	recipient, fan, passer := users[0], users[1], users[2]
+	// deactivated in 2001, and one deactivated but active recently
+	gone, err := b.AddUser(ctx, database.UserModel{Name: "gone", Gender: "m", LastActiveAt: 1000000000})
+	assert.NoError(t, err)
+	away, err := b.AddUser(ctx, database.UserModel{Name: "away", Gender: "m", LastActiveAt: uint64(time.Now().Unix())})
+	assert.NoError(t, err)
+
+	decide(t, b, fan, recipient, true)
+	decide(t, b, passer, recipient, false)
+	decide(t, b, gone, recipient, true)
+	decide(t, b, away, recipient, true)
+	decide(t, b, recipient, gone, true)
+	likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, likes))
+	// the likes of gone and away to the recipient were viewed, the like of gone to the fan is still new
+	decide(t, b, gone, fan, true)
+
+	counters := countersOf(t, b, recipient)
+	assert.Equal(t, [3]uint{3, 0, 1}, counters)
+
+	// a zero policy archives nothing
+	assert.Equal(t, 0, archive(t, b, database.ArchivePolicy{}))
+
+	policy := database.ArchivePolicy{
+		PassesBefore:        uint64(time.Now().Add(time.Hour).Unix()),
+		InactiveLikesBefore: uint64(time.Now().Add(-24 * time.Hour).Unix()),
+	}
+	assert.Equal(t, 2, archive(t, b, policy))
+	assert.Equal(t, 0, archive(t, b, policy))
+
+	// the archived like is still listed, counted and matched
+	archived, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, actorIds(likes), actorIds(archived))
+	assert.Equal(t, likes[1].Id, archived[1].Id)
+	newLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(newLikes))
+	assert.True(t, isMatch(t, b, recipient, gone))
+	assert.Equal(t, counters, countersOf(t, b, recipient))
+	assert.That(t, reconciled(t, b))
+
+	// a new decision finds the archived one: a pass turning into a like, then the archived like into a pass
+	decide(t, b, passer, recipient, true)
+	assert.Equal(t, [3]uint{4, 1, 1}, countersOf(t, b, recipient))
+	decide(t, b, gone, recipient, false)
+	assert.Equal(t, [3]uint{3, 1, 0}, countersOf(t, b, recipient))
+	assert.False(t, isMatch(t, b, recipient, gone))
+	assert.That(t, reconciled(t, b))
+
+	// the new pass is recent
+	assert.Equal(t, 0, archive(t, b, database.ArchivePolicy{PassesBefore: uint64(time.Now().Add(-time.Hour).Unix())}))
+}
+
+func testInactiveAndMissingUsers(t *testing.T, b Backend) {
+	ctx := context.Background()
+	active := addUsers(t, b, 1)[0]
+	inactive, err := b.AddUser(ctx, database.UserModel{Name: "inactive", Gender: "m", IsAactive: false})
+	assert.NoError(t, err)
+
+	user, err := b.Reader.GetUserById(ctx, active)
+	assert.NoError(t, err)
+	assert.Equal(t, active, user.Id)
+	assert.Equal(t, "f", user.Gender)
+	assert.True(t, user.IsAactive)
+
+	user, err = b.Reader.GetUserById(ctx, inactive)
+	assert.NoError(t, err)
+	assert.Equal(t, database.UserModel{}, user)
+
+	user, err = b.Reader.GetUserById(ctx, "999999")
+	assert.NoError(t, err)
+	assert.Equal(t, database.UserModel{}, user)
+}
+
+func testDecisionOnMissingUser(t *testing.T, b Backend) {
+	users := addUsers(t, b, 1)
+
+	err := b.Writer.InsertOrUpdateDecision(context.Background(), database.PutDecisionEntry{
+		ActorId:     users[0],
+		RecipientId: "999999",
+		Like:        true,
+	})
+	assert.Error(t, err)
+
+	err = b.Writer.InsertOrUpdateDecision(context.Background(), database.PutDecisionEntry{
+		ActorId:     "999999",
+		RecipientId: users[0],
+		Like:        true,
+	})
+	assert.Error(t, err)
+}
+
+func testConcurrentDecisions(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 21)
+	recipient := users[0]
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(users))
+	for _, actor := range users[1:] {
+		wg.Add(1)
+		go func(actor string) {
+			defer wg.Done()
+			errs <- b.Writer.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: actor, RecipientId: recipient, Like: true})
+		}(actor)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, [3]uint{20, 20, 0}, countersOf(t, b, recipient))
+}
+
+func testConcurrentUpdatesOfTheSameDecision(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(like bool) {
+			defer wg.Done()
+			errs <- b.Writer.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: users[1], RecipientId: users[0], Like: like})
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// whatever the final value is, there is a single decision for the pair
+	decide(t, b, users[1], users[0], true)
+	likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, users[0], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(likes))
+	assert.Equal(t, uint(1), likesOf(t, b, users[0]))
+}
+
+func testConcurrentMutualLikes(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*(len(users)-1))
+	for _, other := range users[1:] {
+		for _, entry := range []database.PutDecisionEntry{
+			{ActorId: users[0], RecipientId: other, Like: true},
+			{ActorId: other, RecipientId: users[0], Like: true},
+		} {
+			wg.Add(1)
+			go func(entry database.PutDecisionEntry) {
+				defer wg.Done()
+				errs <- b.Writer.InsertOrUpdateDecision(ctx, entry)
+			}(entry)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, [3]uint{9, 9, 9}, countersOf(t, b, users[0]))
+	for _, other := range users[1:] {
+		assert.Equal(t, [3]uint{1, 1, 1}, countersOf(t, b, other))
+	}
+}
+
+// testConcurrentArchiveAndDecisions archives the viewed likes of inactive users while their recipient likes them back
+// and they like the recipient again, which must leave every decision in exactly one table
+func testConcurrentArchiveAndDecisions(t *testing.T, b Backend) {
+	ctx := context.Background()
+	recipient := addUsers(t, b, 1)[0]
+	var gone []string
+	for i := 0; i < b.Reader.GetLimit()-1; i++ {
+		id, err := b.AddUser(ctx, database.UserModel{Name: fmt.Sprintf("gone %d", i), Gender: "m", LastActiveAt: 1000000000})
+		assert.NoError(t, err)
+		gone = append(gone, id)
+		decide(t, b, id, recipient, true)
+	}
+	likes, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Writer.UpdateLikesAsViewed(ctx, recipient, likes))
+	policy := database.ArchivePolicy{InactiveLikesBefore: uint64(time.Now().Add(-24 * time.Hour).Unix())}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(gone)+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for pass := 0; pass < 3; pass++ {
+			for lastId := uint(0); ; {
+				next, _, err := b.Archiver.ArchiveDecisions(ctx, policy, lastId, 2)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if next == 0 {
+					break
+				}
+				lastId = next
+			}
+		}
+	}()
+	for _, id := range gone {
+		for _, entry := range []database.PutDecisionEntry{
+			{ActorId: recipient, RecipientId: id, Like: true},
+			{ActorId: id, RecipientId: recipient, Like: true},
+		} {
+			wg.Add(1)
+			go func(entry database.PutDecisionEntry) {
+				defer wg.Done()
+				errs <- b.Writer.InsertOrUpdateDecision(ctx, entry)
+			}(entry)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	listed, err := b.Reader.FindLikesByRecipientIdPaginated(ctx, recipient, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, len(gone), len(listed))
+	for _, id := range gone {
+		assert.True(t, isMatch(t, b, recipient, id))
+	}
+	counters := countersOf(t, b, recipient)
+	assert.Equal(t, [2]uint{uint(len(gone)), uint(len(gone))}, [2]uint{counters[0], counters[2]})
+	assert.That(t, reconciled(t, b))
+}
+
+func testBulkWriter(t *testing.T, b Backend) {
+	if b.Bulk == nil {
+		t.Skip("the backend has no bulk writer")
+	}
+	ctx := context.Background()
+
+	last, err := b.Bulk.LastUserId(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), last)
+
+	existing := addUsers(t, b, 1)[0]
+	last, err = b.Bulk.LastUserId(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, existing, fmt.Sprint(last))
+
+	var users []database.UserModel
+	for i := uint(1); i <= 3; i++ {
+		users = append(users, database.UserModel{Id: fmt.Sprint(last + i), Name: fmt.Sprintf("bulk %d", i), Gender: "m", IsAactive: true})
+	}
+	users[2].BirthDate = london.BirthDate
+	users[2].Location = london.Location
+	users[2].Bio = london.Bio
+	users[2].PhotoCount = london.PhotoCount
+	users[2].LastActiveAt = london.LastActiveAt
+	assert.NoError(t, b.Bulk.InsertUsers(ctx, users))
+	assertProfile(t, b, users[2].Id, london)
+	assert.NoError(t, b.Bulk.InsertUsers(ctx, nil))
+	// the users keep their ID, and a duplicate fails the whole batch
+	assert.Error(t, b.Bulk.InsertUsers(ctx, []database.UserModel{{Id: fmt.Sprint(last + 4), Name: "bulk 4", Gender: "m"}, users[0]}))
+	last, err = b.Bulk.LastUserId(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, users[2].Id, fmt.Sprint(last))
+
+	ids := []uint{last - 2, last - 1, last}
+	assert.NoError(t, b.Bulk.InsertDecisions(ctx, []database.BulkDecision{
+		{ActorId: ids[0], RecipientId: ids[1], Liked: true, IsNew: true},
+		{ActorId: ids[1], RecipientId: ids[0], Liked: true, IsNew: false},
+		{ActorId: ids[2], RecipientId: ids[1], Liked: false},
+	}))
+	assert.True(t, isMatch(t, b, users[0].Id, users[1].Id))
+
+	// the counters are only written by the reconciliation
+	assert.Equal(t, [3]uint{0, 0, 0}, countersOf(t, b, users[1].Id))
+	for afterUserId := uint(0); ; {
+		lastUserId, _, err := b.Reconciler.ReconcileCounters(ctx, afterUserId, 10)
+		assert.NoError(t, err)
+		if lastUserId == 0 {
+			break
+		}
+		afterUserId = lastUserId
+	}
+	assert.Equal(t, [3]uint{1, 1, 1}, countersOf(t, b, users[1].Id))
+	assert.Equal(t, [3]uint{1, 0, 1}, countersOf(t, b, users[0].Id))
+
+	newLikes, err := b.Reader.FindNewLikesByRecipientIdPaginated(ctx, users[1].Id, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[0].Id}, actorIds(newLikes))
+}
+
+func testBulkImportExport(t *testing.T, b Backend) {
+	if b.Importer == nil || b.Exporter == nil {
+		t.Skip("the backend has no bulk importer or exporter")
+	}
+	ctx := context.Background()
+
+	existing := addUsers(t, b, 2)
+	first, err := strconv.ParseUint(existing[0], 10, 32)
+	assert.NoError(t, err)
+	second, err := strconv.ParseUint(existing[1], 10, 32)
+	assert.NoError(t, err)
+	imported := uint(second) + 1
+
+	// the existing users are updated and keep their counters, the others are inserted with their ID
+	renamed := london
+	renamed.Id = existing[0]
+	renamed.Name = "renamed"
+	assert.NoError(t, b.Importer.UpsertUsers(ctx, []database.UserModel{
+		renamed,
+		{Id: fmt.Sprint(imported), Name: "imported", Gender: "m", IsAactive: false},
+	}))
+	assertProfile(t, b, existing[0], london)
+	user, err := b.Reader.GetUserById(ctx, existing[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", user.Name)
+
+	ids, err := b.Importer.FindUserIds(ctx, []uint{imported + 1, imported, uint(first)})
+	assert.NoError(t, err)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	assert.Equal(t, []uint{uint(first), imported}, ids)
+
+	// an archived pass of a pair is replaced by the imported decision
+	assert.NoError(t, b.Writer.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: existing[1], RecipientId: existing[0], Like: false}))
+	assert.Equal(t, 1, archive(t, b, database.ArchivePolicy{PassesBefore: uint64(time.Now().Add(time.Hour).Unix())}))
+	assert.NoError(t, b.Importer.UpsertDecisions(ctx, []database.BulkDecision{
+		{ActorId: uint(first), RecipientId: uint(second), Liked: true, IsNew: true, CreatedAt: 1600000000, UpdatedAt: 1600000100},
+		{ActorId: uint(second), RecipientId: uint(first), Liked: true},
+		{ActorId: imported, RecipientId: uint(first), Liked: true},
+	}))
+	assert.NoError(t, b.Importer.UpsertDecisions(ctx, []database.BulkDecision{
+		{ActorId: imported, RecipientId: uint(first), Liked: false},
+	}))
+	assert.True(t, isMatch(t, b, existing[0], existing[1]))
+
+	decisions, err := b.Exporter.ExportDecisions(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(decisions))
+	for i, decision := range decisions {
+		assert.That(t, i == 0 || decision.Id > decisions[i-1].Id)
+		switch decision.ActorId {
+		case uint(first):
+			assert.Equal(t, database.BulkDecision{
+				Id: decision.Id, ActorId: uint(first), RecipientId: uint(second), Liked: true, IsNew: true, CreatedAt: 1600000000, UpdatedAt: 1600000100,
+			}, decision)
+		case uint(second):
+			assert.True(t, decision.Liked)
+		case imported:
+			assert.False(t, decision.Liked)
+		}
+	}
+	// the archived decisions are exported too, in ID order
+	for afterUserId := uint(0); ; {
+		lastUserId, _, err := b.Reconciler.ReconcileCounters(ctx, afterUserId, 10)
+		assert.NoError(t, err)
+		if lastUserId == 0 {
+			break
+		}
+		afterUserId = lastUserId
+	}
+	assert.NoError(t, b.Writer.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{ActorId: existing[1], RecipientId: existing[0], Like: false}))
+	assert.Equal(t, 2, archive(t, b, database.ArchivePolicy{PassesBefore: uint64(time.Now().Add(time.Hour).Unix())}))
+	archived, err := b.Exporter.ExportDecisions(ctx, decisions[0].Id, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(archived))
+	for i, decision := range archived {
+		assert.Equal(t, decisions[i+1].Id, decision.Id)
+		assert.False(t, decision.Liked)
+	}
+
+	var exported []string
+	for afterId := uint(0); ; {
+		users, err := b.Exporter.ExportUsers(ctx, afterId, 2)
+		assert.NoError(t, err)
+		if len(users) == 0 {
+			break
+		}
+		exported = append(exported, userIds(users)...)
+		id, err := strconv.ParseUint(users[len(users)-1].Id, 10, 32)
+		assert.NoError(t, err)
+		afterId = uint(id)
+	}
+	assert.Equal(t, []string{existing[0], existing[1], fmt.Sprint(imported)}, exported)
+}
+
+// london is a user with a complete profile
+var london = database.UserModel{
+	Name:         "london",
+	Gender:       "f",
+	IsAactive:    true,
+	BirthDate:    "1990-05-17",
+	Location:     &database.Location{Latitude: 51.507351, Longitude: -0.127758},
+	Bio:          "Coffee, climbing and long walks",
+	PhotoCount:   3,
+	LastActiveAt: 1700000000,
+}
+
+func assertProfile(t *testing.T, b Backend, userId string, want database.UserModel) {
+	user, err := b.Reader.GetUserById(context.Background(), userId)
+	assert.NoError(t, err)
+	assert.Equal(t, userId, user.Id)
+	assert.Equal(t, want.BirthDate, user.BirthDate)
+	assert.DeepEqual(t, want.Location, user.Location)
+	assert.Equal(t, want.Bio, user.Bio)
+	assert.Equal(t, want.PhotoCount, user.PhotoCount)
+	assert.Equal(t, want.LastActiveAt, user.LastActiveAt)
+}
+
+func userIds(users []database.UserModel) []string {
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	return ids
+}
+
+func testUserProfile(t *testing.T, b Backend) {
+	ctx := context.Background()
+	complete, err := b.AddUser(ctx, london)
+	assert.NoError(t, err)
+	empty := addUsers(t, b, 1)[0]
+
+	assertProfile(t, b, complete, london)
+	// the attributes of the users created before the profiles are empty
+	assertProfile(t, b, empty, database.UserModel{})
+}
+
+func testFindUsersByIds(t *testing.T, b Backend) {
+	ctx := context.Background()
+	users := addUsers(t, b, 3)
+	inactive, err := b.AddUser(ctx, database.UserModel{Name: "inactive", Gender: "m", IsAactive: false})
+	assert.NoError(t, err)
+
+	found, err := b.Reader.FindUsersByIds(ctx, []string{users[2], "abc", users[0], inactive, "999999", users[2]})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{users[0], users[2]}, userIds(found))
+	assert.Equal(t, "user 0", found[0].Name)
+
+	found, err = b.Reader.FindUsersByIds(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(found))
+
+	_, err = b.Reader.FindUsersByIds(ctx, make([]string, database.MaxUsers+1))
+	assert.Error(t, err)
+}
+
+func testFindUsers(t *testing.T, b Backend) {
+	ctx := context.Background()
+	profiles := []database.UserModel{
+		london,
+		{Name: "paris", Gender: "m", IsAactive: true, BirthDate: "1985-01-01", Location: &database.Location{Latitude: 48.856613, Longitude: 2.352222}, LastActiveAt: 1690000000},
+		{Name: "unknown", Gender: "f", IsAactive: true},
+		{Name: "fiji", Gender: "f", IsAactive: true, BirthDate: "1995-12-31", Location: &database.Location{Latitude: -17.713371, Longitude: 178.065032}, LastActiveAt: 1710000000},
+		{Name: "samoa", Gender: "f", IsAactive: true, BirthDate: "1992-03-03", Location: &database.Location{Latitude: -13.759029, Longitude: -172.104629}, LastActiveAt: 1600000000},
+		{Name: "inactive", Gender: "f", IsAactive: false, BirthDate: "1990-05-17", Location: london.Location, LastActiveAt: 1700000000},
+	}
+	ids := make([]string, len(profiles))
+	for i, profile := range profiles {
+		id, err := b.AddUser(ctx, profile)
+		assert.NoError(t, err)
+		ids[i] = id
+	}
+	afterLondon, err := strconv.ParseUint(ids[0], 10, 0)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		filter database.UserFilter
+		want   []string
+	}{
+		{name: "no_filter", want: ids[:5]},
+		{name: "gender", filter: database.UserFilter{Gender: "f"}, want: []string{ids[0], ids[2], ids[3], ids[4]}},
+		{name: "born_from", filter: database.UserFilter{BornFrom: "1990-05-17"}, want: []string{ids[0], ids[3], ids[4]}},
+		{name: "born_to", filter: database.UserFilter{BornTo: "1990-05-17"}, want: []string{ids[0], ids[1]}},
+		{name: "area", filter: database.UserFilter{Area: &database.Area{South: 51, West: -1, North: 52, East: 0}}, want: []string{ids[0]}},
+		{name: "area_around", filter: database.UserFilter{Area: ptr(database.AreaAround(*london.Location, 400))}, want: []string{ids[0], ids[1]}},
+		{name: "area_across_the_antimeridian", filter: database.UserFilter{Area: &database.Area{South: -20, West: 170, North: -10, East: -170}}, want: []string{ids[3], ids[4]}},
+		{name: "active_since", filter: database.UserFilter{ActiveSince: 1700000000}, want: []string{ids[0], ids[3]}},
+		{name: "combined", filter: database.UserFilter{Gender: "f", BornFrom: "1991-01-01", ActiveSince: 1650000000}, want: []string{ids[3]}},
+		{name: "page", filter: database.UserFilter{AfterId: uint(afterLondon), Limit: 2}, want: []string{ids[1], ids[2]}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users, err := b.Reader.FindUsers(ctx, test.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, userIds(users))
+		})
+	}
+
+	users, err := b.Reader.FindUsers(ctx, database.UserFilter{Gender: "m"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, "1985-01-01", users[0].BirthDate)
+	assert.DeepEqual(t, profiles[1].Location, users[0].Location)
+
+	for _, limit := range []int{-1, database.MaxUsers + 1} {
+		_, err := b.Reader.FindUsers(ctx, database.UserFilter{Limit: limit})
+		assert.Error(t, err)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
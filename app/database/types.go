@@ -4,10 +4,26 @@ type UserModel struct {
 	Id        string
 	Name      string
 	Likes     uint
+	NewLikes  uint
+	Matches   uint
 	Gender    string
 	CreatedAt uint64
 	UpdatedAt uint64
 	IsAactive bool
+	// BirthDate is a YYYY-MM-DD date, empty when unknown
+	BirthDate string
+	// Location is nil when the user did not share it
+	Location   *Location
+	Bio        string
+	PhotoCount uint
+	// LastActiveAt is a unix timestamp, 0 when unknown
+	LastActiveAt uint64
+}
+
+// Location is a point in decimal degrees
+type Location struct {
+	Latitude  float64
+	Longitude float64
 }
 
 type DecisionModel struct {
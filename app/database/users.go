@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// MaxUsers bounds the users read at once by FindUsersByIds and FindUsers
+const MaxUsers = 100
+
+// kmPerDegree is the length of a degree of latitude, and of longitude at the equator
+const kmPerDegree = 111.195
+
+// UserFilter selects the active users read by FindUsers, in ID order. Its zero fields do not filter, and the users
+// missing an attribute are left out when it is filtered on.
+type UserFilter struct {
+	Gender string
+	// BornFrom and BornTo bound the birth date, as inclusive YYYY-MM-DD dates
+	BornFrom string
+	BornTo   string
+	Area     *Area
+	// ActiveSince is the unix timestamp of the last activity the users must have been active after
+	ActiveSince uint64
+	// AfterId pages through the users, returning the ones with a greater ID
+	AfterId uint
+	// Limit is the number of users returned, Limit when 0 and at most MaxUsers
+	Limit int
+}
+
+// PageSize returns the number of users to read, or an error when Limit is out of bounds
+func (f UserFilter) PageSize() (int, error) {
+	if f.Limit < 0 || f.Limit > MaxUsers {
+		return 0, fmt.Errorf("invalid limit %d, it must be between 1 and %d", f.Limit, MaxUsers)
+	}
+	if f.Limit == 0 {
+		return Limit, nil
+	}
+	return f.Limit, nil
+}
+
+// Conditions returns the SQL conditions of the filter, each starting with AND, and their arguments. Placeholders
+// are ?, and timestamp is the expression converting the placeholder of a unix timestamp, e.g. FROM_UNIXTIME(?).
+func (f UserFilter) Conditions(timestamp string) (string, []any) {
+	var conditions []string
+	var args []any
+	if f.Gender != "" {
+		conditions = append(conditions, "gender = ?")
+		args = append(args, f.Gender)
+	}
+	if f.BornFrom != "" {
+		conditions = append(conditions, "birth_date >= ?")
+		args = append(args, f.BornFrom)
+	}
+	if f.BornTo != "" {
+		conditions = append(conditions, "birth_date <= ?")
+		args = append(args, f.BornTo)
+	}
+	if f.ActiveSince > 0 {
+		conditions = append(conditions, "last_active_at >= "+timestamp)
+		args = append(args, f.ActiveSince)
+	}
+	if f.Area != nil {
+		conditions = append(conditions, "latitude BETWEEN ? AND ?")
+		args = append(args, f.Area.South, f.Area.North)
+		if f.Area.West > f.Area.East {
+			conditions = append(conditions, "(longitude >= ? OR longitude <= ?)")
+		} else {
+			conditions = append(conditions, "longitude BETWEEN ? AND ?")
+		}
+		args = append(args, f.Area.West, f.Area.East)
+	}
+
+	var where strings.Builder
+	for _, condition := range conditions {
+		where.WriteString("\nAND " + condition)
+	}
+	return where.String(), args
+}
+
+// Area is a latitude and longitude box. It crosses the antimeridian when West is greater than East.
+type Area struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// AreaAround returns the box around center containing every point within radius kilometers
+func AreaAround(center Location, radius float64) Area {
+	latitudeDelta := radius / kmPerDegree
+	area := Area{
+		South: max(center.Latitude-latitudeDelta, -90),
+		North: min(center.Latitude+latitudeDelta, 90),
+		West:  -180,
+		East:  180,
+	}
+	// near a pole the box covers every longitude
+	cos := math.Cos(math.Max(math.Abs(area.South), math.Abs(area.North)) * math.Pi / 180)
+	if area.South == -90 || area.North == 90 || radius >= kmPerDegree*180*cos {
+		return area
+	}
+
+	longitudeDelta := radius / (kmPerDegree * cos)
+	area.West = center.Longitude - longitudeDelta
+	area.East = center.Longitude + longitudeDelta
+	if area.West < -180 {
+		area.West += 360
+	}
+	if area.East > 180 {
+		area.East -= 360
+	}
+	return area
+}
+
+// Contains reports whether location is in the area
+func (a Area) Contains(location Location) bool {
+	if location.Latitude < a.South || location.Latitude > a.North {
+		return false
+	}
+	if a.West > a.East {
+		return location.Longitude >= a.West || location.Longitude <= a.East
+	}
+	return location.Longitude >= a.West && location.Longitude <= a.East
+}
+
+// ProfileArgs returns the arguments of the birth_date, latitude, longitude, bio, photo_count and last_active_at
+// columns of the user, the ones it misses being nil. last_active_at is a unix timestamp.
+func (u UserModel) ProfileArgs() []any {
+	args := []any{nil, nil, nil, u.Bio, u.PhotoCount, nil}
+	if u.BirthDate != "" {
+		args[0] = u.BirthDate
+	}
+	if u.Location != nil {
+		args[1], args[2] = u.Location.Latitude, u.Location.Longitude
+	}
+	if u.LastActiveAt > 0 {
+		args[5] = u.LastActiveAt
+	}
+	return args
+}
+
+// UserRow receives the columns of a user read by the SQL backends: id, name, likes, new_likes, matches, gender,
+// created_at, updated_at and is_active, then birth_date as YYYY-MM-DD, latitude, longitude, bio, photo_count and
+// last_active_at, the timestamps being unix timestamps
+type UserRow struct {
+	user      UserModel
+	birthDate sql.NullString
+	latitude  sql.NullFloat64
+	longitude sql.NullFloat64
+}
+
+// Dest returns the destinations to scan the columns into
+func (r *UserRow) Dest() []any {
+	return []any{
+		&r.user.Id,
+		&r.user.Name,
+		&r.user.Likes,
+		&r.user.NewLikes,
+		&r.user.Matches,
+		&r.user.Gender,
+		&r.user.CreatedAt,
+		&r.user.UpdatedAt,
+		&r.user.IsAactive,
+		&r.birthDate,
+		&r.latitude,
+		&r.longitude,
+		&r.user.Bio,
+		&r.user.PhotoCount,
+		&r.user.LastActiveAt,
+	}
+}
+
+// User returns the user scanned
+func (r *UserRow) User() UserModel {
+	user := r.user
+	user.BirthDate = r.birthDate.String
+	if r.latitude.Valid && r.longitude.Valid {
+		user.Location = &Location{Latitude: r.latitude.Float64, Longitude: r.longitude.Float64}
+	}
+	return user
+}
+
+// ScanUsers reads the users of rows, scanned through a UserRow
+func ScanUsers(rows *sql.Rows, err error) ([]UserModel, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserModel
+	for rows.Next() {
+		var row UserRow
+		if err := rows.Scan(row.Dest()...); err != nil {
+			return nil, err
+		}
+		users = append(users, row.User())
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"app/database"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestAreaAround(t *testing.T) {
+	// a degree of latitude is about 111 km everywhere
+	tests := []struct {
+		name    string
+		center  database.Location
+		radius  float64
+		inside  []database.Location
+		outside []database.Location
+	}{
+		{
+			name:    "city",
+			center:  database.Location{Latitude: 51.507351, Longitude: -0.127758},
+			radius:  50,
+			inside:  []database.Location{{Latitude: 51.507351, Longitude: -0.127758}, {Latitude: 51.867, Longitude: -0.127758}, {Latitude: 51.5, Longitude: 0.5}},
+			outside: []database.Location{{Latitude: 52.05, Longitude: -0.127758}, {Latitude: 48.856613, Longitude: 2.352222}},
+		},
+		{
+			name:    "across_the_antimeridian",
+			center:  database.Location{Latitude: -15.5, Longitude: 179.5},
+			radius:  300,
+			inside:  []database.Location{{Latitude: -15.5, Longitude: 178}, {Latitude: -15.5, Longitude: -179}},
+			outside: []database.Location{{Latitude: -15.5, Longitude: 0}, {Latitude: -15.5, Longitude: -170}},
+		},
+		{
+			name:    "near_a_pole",
+			center:  database.Location{Latitude: 89.5, Longitude: 10},
+			radius:  100,
+			inside:  []database.Location{{Latitude: 89.9, Longitude: -170}, {Latitude: 89, Longitude: 100}},
+			outside: []database.Location{{Latitude: 88, Longitude: 10}},
+		},
+		{
+			name:   "whole_world",
+			center: database.Location{Latitude: 0, Longitude: 0},
+			radius: 30000,
+			inside: []database.Location{{Latitude: -90, Longitude: -180}, {Latitude: 90, Longitude: 180}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			area := database.AreaAround(test.center, test.radius)
+			for _, location := range test.inside {
+				assert.That(t, area.Contains(location))
+			}
+			for _, location := range test.outside {
+				assert.False(t, area.Contains(location))
+			}
+		})
+	}
+}
+
+func TestUserFilterPageSize(t *testing.T) {
+	size, err := database.UserFilter{}.PageSize()
+	assert.NoError(t, err)
+	assert.Equal(t, database.Limit, size)
+
+	size, err = database.UserFilter{Limit: database.MaxUsers}.PageSize()
+	assert.NoError(t, err)
+	assert.Equal(t, database.MaxUsers, size)
+
+	_, err = database.UserFilter{Limit: -1}.PageSize()
+	assert.Error(t, err)
+	_, err = database.UserFilter{Limit: database.MaxUsers + 1}.PageSize()
+	assert.Error(t, err)
+}
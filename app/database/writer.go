@@ -4,101 +4,405 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 //go:generate mockery --name Writer
 type Writer interface {
+	// InsertOrUpdateDecision writes the decision and updates the counters of both users in the same transaction
 	InsertOrUpdateDecision(ctx context.Context, entry PutDecisionEntry) error
-	UpdateUserTotalLikes(ctx context.Context, RecipientId string) error
 	UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []DecisionModel) error
 }
 
+// DatabaseWriter runs its writes on statements prepared at construction
 type DatabaseWriter struct {
-	db *sql.DB
+	db         *sql.DB
+	statements *statements
+	retry      RetryPolicy
 }
 
-func NewDatabaseWriter(db *sql.DB) Writer {
-	return DatabaseWriter{db}
+func NewDatabaseWriter(db *sql.DB, options QueryOptions) (DatabaseWriter, error) {
+	statements, err := prepareStatements(context.Background(), db, options,
+		lockUsersQuery,
+		restoreDecisionsQuery,
+		deleteRestoredDecisionsQuery,
+		readDecisionStateQuery,
+		readReverseLikeQuery,
+		insertOrUpdateDecisionQuery,
+		updateRecipientCountersQuery,
+		updateMatchesQuery,
+		updateDecisionLikeQuery,
+		updateNewLikesQuery,
+		lockUsersBatchQuery,
+		reconcileCountersQuery,
+		readDecisionsBatchQuery,
+		lockArchivedUsersQuery,
+		archiveDecisionsQuery,
+		deleteArchivedDecisionsQuery,
+		readSentLikeQuery,
+		insertSentLikeQuery,
+		deleteSentLikeQuery,
+		readLikesBatchQuery,
+		readSentLikesBatchQuery,
+	)
+	if err != nil {
+		return DatabaseWriter{}, err
+	}
+	return DatabaseWriter{db: db, statements: statements, retry: options.Retry}, nil
 }
 
+// lockUsersQuery locks the users of a decision in ID order, so decisions between the same users and the
+// counter reconciliation are serialised without deadlocking each other
+const lockUsersQuery = `
+SELECT id
+FROM users
+WHERE id IN (?, ?)
+ORDER BY id
+FOR UPDATE;
+`
+
+// restoreDecisionsQuery moves the archived decisions between two users back to decisions, in both directions, so
+// a new decision finds the previous one and the like it answers
+const restoreDecisionsQuery = `
+INSERT IGNORE INTO decisions (
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+)
+SELECT
+	id,
+	actor_id,
+	recipient_id,
+	liked,
+	is_new,
+	created_at,
+	updated_at
+FROM decisions_archive
+WHERE (actor_id = ? AND recipient_id = ?)
+OR (actor_id = ? AND recipient_id = ?);
+`
+
+const deleteRestoredDecisionsQuery = `
+DELETE FROM decisions_archive
+WHERE (actor_id = ? AND recipient_id = ?)
+OR (actor_id = ? AND recipient_id = ?);
+`
+
+const readDecisionStateQuery = `
+SELECT
+	liked,
+	is_new
+FROM decisions
+WHERE actor_id = ?
+AND recipient_id = ?
+FOR UPDATE;
+`
+
+const readReverseLikeQuery = `
+SELECT count(*)
+FROM decisions
+WHERE actor_id = ?
+AND recipient_id = ?
+AND actor_id <> recipient_id
+AND liked = 1
+FOR UPDATE;
+`
+
+// insertOrUpdateDecisionQuery flags likes as new, and a decision as new again when it turns into a like
 const insertOrUpdateDecisionQuery = `
 INSERT INTO decisions (
 	actor_id, 
 	recipient_id, 
-	liked
-) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE liked=?;
+	liked,
+	is_new
+) VALUES(?, ?, ?, ?) ON DUPLICATE KEY UPDATE
+	is_new = IF(liked = VALUES(liked), is_new, VALUES(is_new)),
+	liked = VALUES(liked);
 `
 
-const updateUserTotalLikesQuery = `
-UPDATE users 
-SET likes = (
-	SELECT count(*)
-    FROM decisions
-    WHERE recipient_id = ?
-    AND liked = 1)
+const updateRecipientCountersQuery = `
+UPDATE users
+SET likes = likes + ?,
+	new_likes = new_likes + ?
 WHERE id = ?;
 `
 
-const updateDecisionLikesQuery = `
+const updateMatchesQuery = `
+UPDATE users
+SET matches = matches + ?
+WHERE id IN (?, ?);
+`
+
+const updateDecisionLikeQuery = `
 UPDATE decisions 
 SET is_new = 0 
 WHERE recipient_id = ? 
-AND id in (%s) 
+AND id = ?
+AND liked = 1
 AND is_new = 1;
 `
 
+const updateNewLikesQuery = `
+UPDATE users
+SET new_likes = new_likes - ?
+WHERE id = ?;
+`
+
+const lockUsersBatchQuery = `
+SELECT id
+FROM users
+WHERE id > ?
+ORDER BY id
+LIMIT ?
+FOR UPDATE;
+`
+
+// reconcileCountersQuery only changes drifted rows, and the driver reports changed rows as affected
+const reconcileCountersQuery = `
+UPDATE users u
+SET u.likes = ` + LikesOfUser + `,
+	u.new_likes = ` + NewLikesOfUser + `,
+	u.matches = ` + MatchesOfUser + `
+WHERE u.id > ?
+AND u.id <= ?;
+`
+
+// LikesOfUser counts the likes received by the user u, archived ones included, for the statements recomputing or
+// checking the counters. The counter expressions test the flags as booleans, so PostgreSQL shares them.
+const LikesOfUser = `(
+		SELECT count(*)
+		FROM decisions d
+		WHERE d.recipient_id = u.id
+		AND d.liked) + (
+		SELECT count(*)
+		FROM decisions_archive d
+		WHERE d.recipient_id = u.id
+		AND d.liked)`
+
+// NewLikesOfUser counts the likes the user u did not view yet, the archived likes are never new
+const NewLikesOfUser = `(
+		SELECT count(*)
+		FROM decisions d
+		WHERE d.recipient_id = u.id
+		AND d.liked
+		AND d.is_new)`
+
+// MatchesOfUser counts the mutual likes of the user u, each of the two likes being in decisions or in the archive.
+// The likes u sent to the users of other shards are in sent_likes, and the likes they sent back on this shard.
+const MatchesOfUser = `(
+		SELECT count(*)
+		FROM decisions d
+		JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND d.recipient_id <> u.id
+		AND d.liked
+		AND r.liked) + (
+		SELECT count(*)
+		FROM decisions d
+		JOIN decisions_archive r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND d.recipient_id <> u.id
+		AND d.liked
+		AND r.liked) + (
+		SELECT count(*)
+		FROM decisions_archive d
+		JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND d.recipient_id <> u.id
+		AND d.liked
+		AND r.liked) + (
+		SELECT count(*)
+		FROM decisions_archive d
+		JOIN decisions_archive r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND d.recipient_id <> u.id
+		AND d.liked
+		AND r.liked) + (
+		SELECT count(*)
+		FROM sent_likes d
+		JOIN decisions r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND r.liked) + (
+		SELECT count(*)
+		FROM sent_likes d
+		JOIN decisions_archive r ON r.actor_id = d.recipient_id AND r.recipient_id = d.actor_id
+		WHERE d.actor_id = u.id
+		AND r.liked)`
+
+// inTx runs fn in a transaction, committed when fn succeeds. The whole transaction runs again when it fails with
+// a transient error, so fn must not have effects outside of tx.
+func inTx(ctx context.Context, db *sql.DB, retry RetryPolicy, fn func(tx *sql.Tx) error) error {
+	return retry.Do(ctx, func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// lockUsers locks the rows of the given users until the end of tx
+func (w DatabaseWriter) lockUsers(ctx context.Context, tx *sql.Tx, actorId string, recipientId string) error {
+	rows, err := w.statements.query(ctx, tx, lockUsersQuery, actorId, recipientId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
 func (w DatabaseWriter) InsertOrUpdateDecision(ctx context.Context, entry PutDecisionEntry) error {
-	rows, err := w.db.QueryContext(ctx, insertOrUpdateDecisionQuery,
-		entry.ActorId,
-		entry.RecipientId,
-		entry.Like,
-		entry.Like,
-	)
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		return w.writeDecision(ctx, tx, entry, readReverseLikeQuery, entry.ActorId, entry.RecipientId)
+	})
 	if err != nil {
 		return fmt.Errorf("unable to insert or update decision: %w", err)
 	}
-	defer rows.Close()
 
 	return nil
 }
 
-func (w DatabaseWriter) UpdateUserTotalLikes(ctx context.Context, RecipientId string) error {
-	rows, err := w.db.QueryContext(ctx, updateUserTotalLikesQuery,
-		RecipientId,
-		RecipientId,
-	)
-	if err != nil {
-		return fmt.Errorf("unable to update user total likes: %w", err)
+// restoreDecisions moves the archived decisions between the users of entry back to decisions
+func (w DatabaseWriter) restoreDecisions(ctx context.Context, tx *sql.Tx, entry PutDecisionEntry) error {
+	for _, query := range []string{restoreDecisionsQuery, deleteRestoredDecisionsQuery} {
+		if _, err := w.statements.exec(ctx, tx, query, entry.ActorId, entry.RecipientId, entry.RecipientId, entry.ActorId); err != nil {
+			return err
+		}
 	}
-	defer rows.Close()
-
 	return nil
+}
+
+// writeDecision writes the decision and the counters of its recipient in tx. reverseLikeQuery counts the like of
+// the recipient to the actor, and the matches are counted for the users of matched only: both users of the
+// decision, or its recipient when the actor lives on another shard.
+func (w DatabaseWriter) writeDecision(ctx context.Context, tx *sql.Tx, entry PutDecisionEntry, reverseLikeQuery string, matched ...string) error {
+	if err := w.lockUsers(ctx, tx, entry.ActorId, entry.RecipientId); err != nil {
+		return err
+	}
+	if err := w.restoreDecisions(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	previous := DecisionState{Exists: true}
+	err := w.statements.queryRow(ctx, tx, readDecisionStateQuery, []any{entry.ActorId, entry.RecipientId}, &previous.Liked, &previous.IsNew)
+	if err == sql.ErrNoRows {
+		previous = DecisionState{}
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := w.statements.exec(ctx, tx, insertOrUpdateDecisionQuery,
+		entry.ActorId,
+		entry.RecipientId,
+		entry.Like,
+		entry.Like,
+	); err != nil {
+		return err
+	}
+
+	var reverseLikes int
+	if err := w.statements.queryRow(ctx, tx, reverseLikeQuery, []any{entry.RecipientId, entry.ActorId}, &reverseLikes); err != nil {
+		return err
+	}
 
+	likes, newLikes, matches := CounterDeltas(previous, entry.Like, reverseLikes > 0)
+	if likes != 0 {
+		if err := w.statements.execAffecting(ctx, tx, 1, updateRecipientCountersQuery, likes, newLikes, entry.RecipientId); err != nil {
+			return err
+		}
+	}
+	if matches != 0 {
+		if err := w.statements.execAffecting(ctx, tx, int64(len(matched)), updateMatchesQuery, matches, matched[0], matched[len(matched)-1]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (w DatabaseWriter) UpdateLikesAsViewed(ctx context.Context, RecipientId string, likes []DecisionModel) error {
-	decisionIds := make([]string, 0)
-
-	for _, like := range likes {
-		decisionIds = append(decisionIds, like.Id)
+	if len(likes) == 0 {
+		return nil
 	}
 
-	rows, err := w.db.QueryContext(ctx, fmt.Sprintf(updateDecisionLikesQuery, strings.Join(decisionIds, ", ")),
-		RecipientId,
-	)
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		if err := w.lockUsers(ctx, tx, RecipientId, RecipientId); err != nil {
+			return err
+		}
+
+		var viewed int64
+		for _, like := range likes {
+			result, err := w.statements.exec(ctx, tx, updateDecisionLikeQuery, RecipientId, like.Id)
+			if err != nil {
+				return err
+			}
+			changed, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			viewed += changed
+		}
+		if viewed == 0 {
+			return nil
+		}
+
+		return w.statements.execAffecting(ctx, tx, 1, updateNewLikesQuery, viewed, RecipientId)
+	})
 	if err != nil {
 		return fmt.Errorf("unable to update decisions: %w", err)
 	}
-	defer rows.Close()
 
 	return nil
+}
+
+// ReconcileCounters recomputes the counters of a batch of users from their decisions
+func (w DatabaseWriter) ReconcileCounters(ctx context.Context, afterUserId uint, limit int) (uint, int, error) {
+	var lastUserId uint
+	var repaired int64
+	err := inTx(ctx, w.db, w.retry, func(tx *sql.Tx) error {
+		rows, err := w.statements.query(ctx, tx, lockUsersBatchQuery, afterUserId, limit)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := rows.Scan(&lastUserId); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || lastUserId == 0 {
+			return err
+		}
+
+		result, err := w.statements.exec(ctx, tx, reconcileCountersQuery, afterUserId, lastUserId)
+		if err != nil {
+			return err
+		}
+		repaired, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to reconcile counters: %w", err)
+	}
 
+	return lastUserId, int(repaired), nil
 }
 
 // Interface guards
 var (
-	_ Writer = (*DatabaseWriter)(nil)
+	_ Writer            = (*DatabaseWriter)(nil)
+	_ CounterReconciler = (*DatabaseWriter)(nil)
+	_ DecisionArchiver  = (*DatabaseWriter)(nil)
 )
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"app/config"
+	"app/database"
+	"app/database/memory"
+	pb "app/explore_service_protos"
+	"app/handlers"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zeebo/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const e2eSecret = "e2e-secret"
+
+// newE2EClient serves the explore service backed by an in-memory store over bufconn, with the production interceptors
+func newE2EClient(t *testing.T, users int) (pb.ExploreServiceClient, *memory.Store) {
+	store := memory.NewStore()
+	for i := 0; i < users; i++ {
+		_, err := store.AddUser(database.UserModel{Name: "user", Gender: "f", IsAactive: true})
+		assert.NoError(t, err)
+	}
+
+	cfg := config.Config{
+		Auth: config.AuthConfig{Algorithm: "HS256", Secret: e2eSecret, AdminRole: "admin"},
+	}
+	chain, err := newInterceptorChain(cfg)
+	assert.NoError(t, err)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(chain)
+	pb.RegisterExploreServiceServer(server, &handlers.Server{DatabaseReader: store, DatabaseWriter: store})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("passthrough:///e2e",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewExploreServiceClient(conn), store
+}
+
+func as(t *testing.T, userId string) context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   userId,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte(e2eSecret))
+	assert.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestEndToEnd(t *testing.T) {
+	client, _ := newE2EClient(t, 3)
+
+	put, err := client.PutDecision(as(t, "2"), &pb.PutDecisionRequest{ActorUserId: "2", RecipientUserId: "1", LikedRecipient: true})
+	assert.NoError(t, err)
+	assert.False(t, put.MutualLikes)
+
+	_, err = client.PutDecision(as(t, "3"), &pb.PutDecisionRequest{ActorUserId: "3", RecipientUserId: "1", LikedRecipient: true})
+	assert.NoError(t, err)
+
+	count, err := client.CountLikedYou(as(t, "1"), &pb.CountLikedYouRequest{RecipientUserId: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count.Count)
+
+	newLikes, err := client.ListNewLikedYou(as(t, "1"), &pb.ListLikedYouRequest{RecipientUserId: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(newLikes.Likers))
+	assert.Equal(t, "2", newLikes.Likers[0].ActorId)
+	assert.Nil(t, newLikes.NextPaginationToken)
+
+	newLikes, err = client.ListNewLikedYou(as(t, "1"), &pb.ListLikedYouRequest{RecipientUserId: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(newLikes.Likers))
+
+	likes, err := client.ListLikedYou(as(t, "1"), &pb.ListLikedYouRequest{RecipientUserId: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(likes.Likers))
+
+	put, err = client.PutDecision(as(t, "1"), &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true})
+	assert.NoError(t, err)
+	assert.True(t, put.MutualLikes)
+
+	_, err = client.PutDecision(as(t, "2"), &pb.PutDecisionRequest{ActorUserId: "2", RecipientUserId: "1", LikedRecipient: false})
+	assert.NoError(t, err)
+	count, err = client.CountLikedYou(as(t, "1"), &pb.CountLikedYouRequest{RecipientUserId: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count.Count)
+}
+
+func TestEndToEndAuthorization(t *testing.T) {
+	client, _ := newE2EClient(t, 2)
+
+	_, err := client.CountLikedYou(context.Background(), &pb.CountLikedYouRequest{RecipientUserId: "1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ListLikedYou(as(t, "2"), &pb.ListLikedYouRequest{RecipientUserId: "1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.PutDecision(as(t, "2"), &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
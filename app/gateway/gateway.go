@@ -0,0 +1,267 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	pb "app/explore_service_protos"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const serviceName = "ExploreService"
+
+// maxBodySize bounds the JSON bodies accepted by the gateway
+const maxBodySize = 1 << 20
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{}
+)
+
+// NewHandler returns an http.Handler translating the REST/JSON API into calls on the given connection,
+// which is expected to reach the ExploreService gRPC server so interceptors apply to HTTP traffic too.
+// The OpenAPI document is served at /openapi.json.
+func NewHandler(conn grpc.ClientConnInterface) (http.Handler, error) {
+	service := pb.File_explore_service_proto.Services().ByName(serviceName)
+	if service == nil {
+		return nil, fmt.Errorf("service %s not found", serviceName)
+	}
+
+	document, err := OpenAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range Routes {
+		method := service.Methods().ByName(protoreflect.Name(route.RPC))
+		if method == nil {
+			return nil, fmt.Errorf("route %s %s: unknown RPC %s", route.Method, route.Path, route.RPC)
+		}
+		mux.Handle(route.Method+" "+route.Path, &rpcHandler{
+			conn:       conn,
+			route:      route,
+			fullMethod: fmt.Sprintf("/%s/%s", service.FullName(), method.Name()),
+			input:      method.Input(),
+			output:     method.Output(),
+		})
+	}
+
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+
+	return mux, nil
+}
+
+type rpcHandler struct {
+	conn       grpc.ClientConnInterface
+	route      Route
+	fullMethod string
+	input      protoreflect.MessageDescriptor
+	output     protoreflect.MessageDescriptor
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := newMessage(h.input)
+	if err != nil {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+
+	if err := h.decode(w, r, request); err != nil {
+		writeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	response, err := newMessage(h.output)
+	if err != nil {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+
+	if err := h.conn.Invoke(outgoingContext(r), h.fullMethod, request, response); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body, err := marshaler.Marshal(response)
+	if err != nil {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (h *rpcHandler) decode(w http.ResponseWriter, r *http.Request, request proto.Message) error {
+	if h.route.Body {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			return fmt.Errorf("unable to read body: %w", err)
+		}
+		if err := unmarshaler.Unmarshal(body, request); err != nil {
+			return fmt.Errorf("invalid body: %w", err)
+		}
+	} else {
+		for key, values := range r.URL.Query() {
+			if isPathField(h.route, key) {
+				continue
+			}
+			if err := setField(request, key, values[0]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for wildcard, field := range h.route.PathParams {
+		if err := setField(request, field, r.PathValue(wildcard)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isPathField(route Route, field string) bool {
+	for _, pathField := range route.PathParams {
+		if pathField == field {
+			return true
+		}
+	}
+	return false
+}
+
+func newMessage(descriptor protoreflect.MessageDescriptor) (proto.Message, error) {
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(descriptor.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return messageType.New().Interface(), nil
+}
+
+// setField assigns a scalar field of the message from its string representation
+func setField(message proto.Message, name string, value string) error {
+	reflected := message.ProtoReflect()
+	field := reflected.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if field == nil || field.IsList() || field.IsMap() {
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+
+	var converted protoreflect.Value
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		converted = protoreflect.ValueOfString(value)
+	case protoreflect.BoolKind:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		converted = protoreflect.ValueOfBool(parsed)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		converted = protoreflect.ValueOfUint64(parsed)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		converted = protoreflect.ValueOfInt64(parsed)
+	default:
+		return fmt.Errorf("parameter %q cannot be set from the URL", name)
+	}
+
+	reflected.Set(field, converted)
+	return nil
+}
+
+// outgoingContext forwards the Authorization header so the gRPC auth interceptor sees the caller's token, and the
+// address of the HTTP client in x-forwarded-for so the rate limiter tells the clients of the gateway apart
+func outgoingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", host)
+	}
+	return ctx
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := httpStatus(st.Code())
+	if code == http.StatusInternalServerError {
+		log.Printf("Error on gateway call: %s", err)
+	}
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			seconds := int64(math.Ceil(retryInfo.RetryDelay.AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{Code: st.Code().String(), Message: st.Message()})
+}
+
+// httpStatus follows the mapping used by grpc-gateway
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,189 @@
+package gateway_test
+
+import (
+	pb "app/explore_service_protos"
+	"app/gateway"
+	"app/ratelimit"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeExploreServer struct {
+	pb.UnimplementedExploreServiceServer
+	listRequests  []*pb.ListLikedYouRequest
+	decisions     []*pb.PutDecisionRequest
+	authorization []string
+}
+
+func (s *fakeExploreServer) ListLikedYou(ctx context.Context, request *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	s.listRequests = append(s.listRequests, request)
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.authorization = append(s.authorization, md.Get("authorization")...)
+	next := "2"
+	return &pb.ListLikedYouResponse{
+		Likers:              []*pb.ListLikedYouResponse_Liker{{ActorId: "2", UnixTimestamp: 1700000000}},
+		NextPaginationToken: &next,
+	}, nil
+}
+
+func (s *fakeExploreServer) ListNewLikedYou(ctx context.Context, request *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	s.listRequests = append(s.listRequests, request)
+	return &pb.ListLikedYouResponse{}, nil
+}
+
+func (s *fakeExploreServer) CountLikedYou(ctx context.Context, request *pb.CountLikedYouRequest) (*pb.CountLikedYouResponse, error) {
+	if request.RecipientUserId != "1" {
+		return nil, status.Error(codes.PermissionDenied, "not allowed")
+	}
+	return &pb.CountLikedYouResponse{Count: 42}, nil
+}
+
+func (s *fakeExploreServer) PutDecision(ctx context.Context, request *pb.PutDecisionRequest) (*pb.PutDecisionResponse, error) {
+	s.decisions = append(s.decisions, request)
+	return &pb.PutDecisionResponse{MutualLikes: true}, nil
+}
+
+func newGateway(t *testing.T, options ...grpc.ServerOption) (*fakeExploreServer, *httptest.Server) {
+	listener := bufconn.Listen(1 << 20)
+	service := &fakeExploreServer{}
+	server := grpc.NewServer(options...)
+	pb.RegisterExploreServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("passthrough:///gateway",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	handler, err := gateway.NewHandler(conn)
+	assert.NoError(t, err)
+	httpServer := httptest.NewServer(handler)
+	t.Cleanup(httpServer.Close)
+
+	return service, httpServer
+}
+
+func call(t *testing.T, method string, url string, body string) (int, map[string]interface{}) {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer token")
+
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&decoded))
+	return response.StatusCode, decoded
+}
+
+func TestGateway(t *testing.T) {
+	service, server := newGateway(t)
+
+	code, body := call(t, http.MethodGet, server.URL+"/users/1/likes?pagination_token=3", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2", body["next_pagination_token"])
+	likers := body["likers"].([]interface{})
+	assert.Equal(t, 1, len(likers))
+	assert.Equal(t, "2", likers[0].(map[string]interface{})["actor_id"])
+	assert.Equal(t, "1", service.listRequests[0].RecipientUserId)
+	assert.Equal(t, "3", service.listRequests[0].GetPaginationToken())
+	assert.Equal(t, []string{"Bearer token"}, service.authorization)
+
+	code, body = call(t, http.MethodGet, server.URL+"/users/5/likes/new", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(body["likers"].([]interface{})))
+	assert.Equal(t, "5", service.listRequests[1].RecipientUserId)
+	assert.Nil(t, service.listRequests[1].PaginationToken)
+
+	code, body = call(t, http.MethodGet, server.URL+"/users/1/likes/count", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "42", body["count"])
+
+	code, body = call(t, http.MethodPut, server.URL+"/decisions", `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["mutual_likes"])
+	assert.Equal(t, "1", service.decisions[0].ActorUserId)
+	assert.Equal(t, "2", service.decisions[0].RecipientUserId)
+	assert.True(t, service.decisions[0].LikedRecipient)
+}
+
+func TestGatewayErrors(t *testing.T) {
+	_, server := newGateway(t)
+
+	code, body := call(t, http.MethodGet, server.URL+"/users/2/likes/count", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "PermissionDenied", body["code"])
+
+	code, _ = call(t, http.MethodGet, server.URL+"/users/1/likes?unknown=1", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = call(t, http.MethodPut, server.URL+"/decisions", `{"actor_user_id":`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestGatewayRateLimitsEachClient(t *testing.T) {
+	limits := ratelimit.Limits{Methods: map[string]ratelimit.Limit{"PutDecision": {Rate: 0, Burst: 1}}}
+	_, server := newGateway(t, grpc.UnaryInterceptor(ratelimit.UnaryServerInterceptor(ratelimit.NewMemoryStore(), limits)))
+
+	// the clients are not authenticated, the limiter tells them apart by the address forwarded by the gateway
+	putDecision := func(remoteAddr string) int {
+		request := httptest.NewRequest(http.MethodPut, "/decisions", strings.NewReader(`{"actor_user_id":"1","recipient_user_id":"2"}`))
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		server.Config.Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, putDecision("10.0.0.1:4000"))
+	assert.Equal(t, http.StatusTooManyRequests, putDecision("10.0.0.1:4001"))
+	assert.Equal(t, http.StatusOK, putDecision("10.0.0.2:4000"))
+	assert.Equal(t, http.StatusTooManyRequests, putDecision("10.0.0.2:4000"))
+}
+
+func TestOpenAPIDocumentIsUpToDate(t *testing.T) {
+	_, server := newGateway(t)
+
+	generated, err := gateway.OpenAPI()
+	assert.NoError(t, err)
+
+	committed, err := os.ReadFile("openapi.json")
+	assert.NoError(t, err)
+	assert.Equal(t, string(generated)+"\n", string(committed))
+
+	response, err := http.Get(server.URL + "/openapi.json")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	served, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, string(generated), string(served))
+
+	var document struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(served, &document))
+	assert.NotNil(t, document.Paths["/users/{id}/likes"]["get"])
+	assert.NotNil(t, document.Paths["/users/{id}/likes/new"]["get"])
+	assert.NotNil(t, document.Paths["/users/{id}/likes/count"]["get"])
+	assert.NotNil(t, document.Paths["/decisions"]["put"])
+}
@@ -0,0 +1,203 @@
+package gateway
+
+//go:generate go run ../cmd/openapi -out openapi.json
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	pb "app/explore_service_protos"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type object = map[string]interface{}
+
+// OpenAPI builds the OpenAPI 3 document of the gateway from the routes and the ExploreService proto descriptors
+func OpenAPI() ([]byte, error) {
+	file := pb.File_explore_service_proto
+	service := file.Services().ByName(serviceName)
+	if service == nil {
+		return nil, fmt.Errorf("service %s not found", serviceName)
+	}
+
+	schemas := object{
+		"Error": object{
+			"type": "object",
+			"properties": object{
+				"code":    object{"type": "string"},
+				"message": object{"type": "string"},
+			},
+		},
+	}
+	paths := object{}
+
+	for _, route := range Routes {
+		method := service.Methods().ByName(protoreflect.Name(route.RPC))
+		if method == nil {
+			return nil, fmt.Errorf("route %s %s: unknown RPC %s", route.Method, route.Path, route.RPC)
+		}
+		addSchema(schemas, method.Input())
+		addSchema(schemas, method.Output())
+
+		operation := object{
+			"operationId": route.RPC,
+			"summary":     route.Summary,
+			"responses": object{
+				"200": object{
+					"description": "OK",
+					"content":     jsonContent(schemaRef(method.Output())),
+				},
+				"default": object{
+					"description": "Error",
+					"content":     jsonContent(object{"$ref": "#/components/schemas/Error"}),
+				},
+			},
+		}
+
+		parameters := []object{}
+		for _, wildcard := range sortedKeys(route.PathParams) {
+			field := method.Input().Fields().ByName(protoreflect.Name(route.PathParams[wildcard]))
+			if field == nil {
+				return nil, fmt.Errorf("route %s %s: unknown field %s", route.Method, route.Path, route.PathParams[wildcard])
+			}
+			parameters = append(parameters, object{
+				"name":     wildcard,
+				"in":       "path",
+				"required": true,
+				"schema":   fieldSchema(field),
+			})
+		}
+		if route.Body {
+			operation["requestBody"] = object{
+				"required": true,
+				"content":  jsonContent(schemaRef(method.Input())),
+			}
+		} else {
+			fields := method.Input().Fields()
+			for i := 0; i < fields.Len(); i++ {
+				field := fields.Get(i)
+				if isPathField(route, string(field.Name())) {
+					continue
+				}
+				parameters = append(parameters, object{
+					"name":   string(field.Name()),
+					"in":     "query",
+					"schema": fieldSchema(field),
+				})
+			}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		item, ok := paths[route.Path].(object)
+		if !ok {
+			item = object{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	document := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   string(service.FullName()),
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": schemas,
+			"securitySchemes": object{
+				"bearerAuth": object{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+		"security": []object{{"bearerAuth": []string{}}},
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
+
+func schemaName(message protoreflect.MessageDescriptor) string {
+	return strings.TrimPrefix(string(message.FullName()), string(message.ParentFile().Package())+".")
+}
+
+func schemaRef(message protoreflect.MessageDescriptor) object {
+	return object{"$ref": "#/components/schemas/" + schemaName(message)}
+}
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+func addSchema(schemas object, message protoreflect.MessageDescriptor) {
+	name := schemaName(message)
+	if _, ok := schemas[name]; ok {
+		return
+	}
+
+	properties := object{}
+	schemas[name] = object{"type": "object", "properties": properties}
+
+	fields := message.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		properties[string(field.Name())] = fieldSchema(field)
+		if field.Kind() == protoreflect.MessageKind {
+			addSchema(schemas, field.Message())
+		}
+	}
+}
+
+// fieldSchema describes a field the way protojson encodes it, 64-bit integers being strings
+func fieldSchema(field protoreflect.FieldDescriptor) object {
+	var schema object
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		schema = object{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		schema = object{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		schema = object{"type": "integer", "format": "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		schema = object{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		schema = object{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		schema = object{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		schema = object{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		schema = object{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := []string{}
+		for i := 0; i < field.Enum().Values().Len(); i++ {
+			values = append(values, string(field.Enum().Values().Get(i).Name()))
+		}
+		schema = object{"type": "string", "enum": values}
+	case protoreflect.MessageKind:
+		schema = schemaRef(field.Message())
+	default:
+		schema = object{"type": "string"}
+	}
+
+	if field.IsList() {
+		return object{"type": "array", "items": schema}
+	}
+	return schema
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package gateway
+
+import "net/http"
+
+// Route maps an HTTP endpoint to an ExploreService RPC. Path wildcards and, for requests without
+// a body, query parameters are copied into the request message fields of the same name.
+type Route struct {
+	Method  string
+	Path    string
+	RPC     string
+	Summary string
+	// PathParams maps each wildcard of Path to the request field it fills
+	PathParams map[string]string
+	// Body is true when the request message is read from the JSON body
+	Body bool
+}
+
+var Routes = []Route{
+	{
+		Method:     http.MethodGet,
+		Path:       "/users/{id}/likes",
+		RPC:        "ListLikedYou",
+		Summary:    "List all users who liked the user",
+		PathParams: map[string]string{"id": "recipient_user_id"},
+	},
+	{
+		Method:     http.MethodGet,
+		Path:       "/users/{id}/likes/new",
+		RPC:        "ListNewLikedYou",
+		Summary:    "List the users who liked the user and have not been seen yet",
+		PathParams: map[string]string{"id": "recipient_user_id"},
+	},
+	{
+		Method:     http.MethodGet,
+		Path:       "/users/{id}/likes/count",
+		RPC:        "CountLikedYou",
+		Summary:    "Count the number of users who liked the user",
+		PathParams: map[string]string{"id": "recipient_user_id"},
+	},
+	{
+		Method:  http.MethodPut,
+		Path:    "/decisions",
+		RPC:     "PutDecision",
+		Summary: "Record the decision of the actor to like or pass the recipient",
+		Body:    true,
+	},
+}
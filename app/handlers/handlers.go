@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"app/auth"
 	"app/database"
 	"context"
 	"fmt"
@@ -42,6 +43,10 @@ func (s *Server) GetNextPage(currPage int, limit int, pageSize int) *string {
 }
 
 func (s *Server) ListLikedYou(ctx context.Context, request *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	if err := auth.Authorize(ctx, request.RecipientUserId); err != nil {
+		return &pb.ListLikedYouResponse{}, err
+	}
+
 	page := 1
 	if request.PaginationToken != nil {
 		var err error
@@ -75,6 +80,10 @@ func (s *Server) ListLikedYou(ctx context.Context, request *pb.ListLikedYouReque
 }
 
 func (s *Server) ListNewLikedYou(ctx context.Context, request *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	if err := auth.Authorize(ctx, request.RecipientUserId); err != nil {
+		return &pb.ListLikedYouResponse{}, err
+	}
+
 	page := 1
 	if request.PaginationToken != nil {
 		var err error
@@ -107,6 +116,10 @@ func (s *Server) ListNewLikedYou(ctx context.Context, request *pb.ListLikedYouRe
 	}, nil
 }
 func (s *Server) CountLikedYou(ctx context.Context, request *pb.CountLikedYouRequest) (*pb.CountLikedYouResponse, error) {
+	if err := auth.Authorize(ctx, request.RecipientUserId); err != nil {
+		return &pb.CountLikedYouResponse{}, err
+	}
+
 	user, err := s.DatabaseReader.GetUserById(ctx, request.RecipientUserId)
 
 	if err != nil {
@@ -118,6 +131,10 @@ func (s *Server) CountLikedYou(ctx context.Context, request *pb.CountLikedYouReq
 }
 func (s *Server) PutDecision(ctx context.Context, request *pb.PutDecisionRequest) (*pb.PutDecisionResponse, error) {
 	response := &pb.PutDecisionResponse{MutualLikes: false}
+	if err := auth.Authorize(ctx, request.ActorUserId); err != nil {
+		return response, err
+	}
+
 	err := s.DatabaseWriter.InsertOrUpdateDecision(ctx, database.PutDecisionEntry{
 		ActorId:     request.ActorUserId,
 		RecipientId: request.RecipientUserId,
@@ -128,12 +145,6 @@ func (s *Server) PutDecision(ctx context.Context, request *pb.PutDecisionRequest
 		return response, fmt.Errorf("unable to create or update decision")
 	}
 
-	err = s.DatabaseWriter.UpdateUserTotalLikes(ctx, request.RecipientUserId)
-	if err != nil {
-		log.Printf("Error on GetUserById while UpdateUserTotalLikes: %s", err)
-		return response, fmt.Errorf("unable to update user total likes")
-	}
-
 	var isMatch bool
 	isMatch, err = s.DatabaseReader.GetIsMatch(ctx, request.ActorUserId, request.RecipientUserId)
 	if err != nil {
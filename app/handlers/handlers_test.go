@@ -1,21 +1,27 @@
 package handlers_test
 
 import (
+	"app/auth"
 	"app/database"
+	"app/database/cache"
 	"app/database/mocks"
 	pb "app/explore_service_protos"
 	"app/handlers"
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/zeebo/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestListLikedYou(t *testing.T) {
-	ctx := context.Background()
 	page := 1
 	recipientId := "1"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: recipientId})
 
 	dbResponse := []database.DecisionModel{
 		{
@@ -137,10 +143,50 @@ func TestListLikedYou(t *testing.T) {
 	}
 }
 
+// TestListLikedYouRefreshesViewedLikes checks that viewing a cached likes page invalidates it, as viewing refreshes the
+// timestamps of the likes, along with the cached recipient
+func TestListLikedYouRefreshesViewedLikes(t *testing.T) {
+	recipientId := "1"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: recipientId})
+	likes := []database.DecisionModel{{Id: "1", ActorId: 2, RecipientId: 1, Liked: true, Updated_at: 1700000000}}
+	viewed := []database.DecisionModel{{Id: "1", ActorId: 2, RecipientId: 1, Liked: true, Updated_at: 1700003600}}
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("FindLikesByRecipientIdPaginated", mock.Anything, recipientId, 1).Return(likes, nil).Once()
+	mockReader.Mock.On("FindLikesByRecipientIdPaginated", mock.Anything, recipientId, 1).Return(viewed, nil).Once()
+	mockReader.Mock.On("GetUserById", mock.Anything, recipientId).Return(database.UserModel{Id: recipientId, Likes: 1, NewLikes: 1}, nil).Once()
+	mockReader.Mock.On("GetLimit").Return(10)
+	mockWriter := mocks.NewWriter(t)
+	mockWriter.Mock.On("UpdateLikesAsViewed", ctx, recipientId, likes).Return(nil).Once()
+
+	store := cache.NewLRUStore(10)
+	options := cache.Options{TTL: time.Minute, Pages: 1}
+	server := handlers.Server{
+		DatabaseReader: cache.NewReader(mockReader, store, options),
+		DatabaseWriter: cache.NewWriter(mockWriter, store, options),
+	}
+
+	count, err := server.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: recipientId})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count.Count)
+
+	output, err := server.ListLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: recipientId})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(output.Likers))
+	assert.Equal(t, uint64(1700000000), output.Likers[0].UnixTimestamp)
+	assert.Equal(t, 0, store.Len())
+
+	mockWriter.Mock.On("UpdateLikesAsViewed", ctx, recipientId, viewed).Return(nil).Once()
+	output, err = server.ListLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: recipientId})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(output.Likers))
+	assert.Equal(t, uint64(1700003600), output.Likers[0].UnixTimestamp)
+}
+
 func TestListNewLikedYou(t *testing.T) {
-	ctx := context.Background()
 	page := 1
 	recipientId := "1"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: recipientId})
 
 	dbResponse := []database.DecisionModel{
 		{
@@ -263,9 +309,9 @@ func TestListNewLikedYou(t *testing.T) {
 }
 
 func TestCountLikedYou(t *testing.T) {
-	ctx := context.Background()
 	likes := 20
 	recipientId := "1"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: recipientId})
 
 	dbResponse := database.UserModel{
 		Id:        "1",
@@ -337,9 +383,9 @@ func TestCountLikedYou(t *testing.T) {
 }
 
 func TestPutDecision(t *testing.T) {
-	ctx := context.Background()
 	recipientId := "1"
 	actorId := "2"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: actorId})
 
 	tests := []struct {
 		name         string
@@ -363,7 +409,6 @@ func TestPutDecision(t *testing.T) {
 					Like:        true,
 				}
 				mockWriter.Mock.On("InsertOrUpdateDecision", ctx, entry).Return(nil)
-				mockWriter.Mock.On("UpdateUserTotalLikes", ctx, recipientId).Return(nil)
 
 				return mockWriter
 			},
@@ -394,7 +439,6 @@ func TestPutDecision(t *testing.T) {
 					Like:        false,
 				}
 				mockWriter.Mock.On("InsertOrUpdateDecision", ctx, entry).Return(nil)
-				mockWriter.Mock.On("UpdateUserTotalLikes", ctx, recipientId).Return(nil)
 
 				return mockWriter
 			},
@@ -441,36 +485,6 @@ func TestPutDecision(t *testing.T) {
 				assert.Equal(t, expectedResponse, output)
 			},
 		},
-		{
-			name: "error_update_total_like",
-			reader: func(t *testing.T) database.Reader {
-				mockReader := mocks.NewReader(t)
-				return mockReader
-			},
-			writer: func(t *testing.T) database.Writer {
-				mockWriter := mocks.NewWriter(t)
-				entry := database.PutDecisionEntry{
-					ActorId:     actorId,
-					RecipientId: recipientId,
-					Like:        false,
-				}
-				mockWriter.Mock.On("InsertOrUpdateDecision", ctx, entry).Return(nil)
-				mockWriter.Mock.On("UpdateUserTotalLikes", ctx, recipientId).Return(fmt.Errorf("generic error"))
-
-				return mockWriter
-			},
-			request: &pb.PutDecisionRequest{
-				ActorUserId:     actorId,
-				RecipientUserId: recipientId,
-				LikedRecipient:  false,
-			},
-			expectations: func(t *testing.T, output *pb.PutDecisionResponse, err error) {
-				expectedResponse := &pb.PutDecisionResponse{MutualLikes: false}
-
-				assert.Error(t, err)
-				assert.Equal(t, expectedResponse, output)
-			},
-		},
 		{
 			name: "error_check_if_is_match",
 			reader: func(t *testing.T) database.Reader {
@@ -486,7 +500,6 @@ func TestPutDecision(t *testing.T) {
 					Like:        false,
 				}
 				mockWriter.Mock.On("InsertOrUpdateDecision", ctx, entry).Return(nil)
-				mockWriter.Mock.On("UpdateUserTotalLikes", ctx, recipientId).Return(nil)
 
 				return mockWriter
 			},
@@ -515,3 +528,66 @@ func TestPutDecision(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthorization(t *testing.T) {
+	ownerId := "1"
+	otherId := "2"
+	page := "1"
+
+	tests := []struct {
+		name         string
+		identity     *auth.Identity
+		expectedCode codes.Code
+	}{
+		{
+			name:         "missing_identity",
+			identity:     nil,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "caller_is_another_user",
+			identity:     &auth.Identity{UserId: otherId},
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.identity != nil {
+			ctx = auth.NewContext(ctx, *test.identity)
+		}
+		server := handlers.Server{
+			DatabaseReader: mocks.NewReader(t),
+			DatabaseWriter: mocks.NewWriter(t),
+		}
+		t.Run(test.name, func(t *testing.T) {
+			_, err := server.ListLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: ownerId, PaginationToken: &page})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			_, err = server.ListNewLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: ownerId, PaginationToken: &page})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			_, err = server.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: ownerId})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			_, err = server.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: ownerId, RecipientUserId: otherId, LikedRecipient: true})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestAdminActsOnBehalfOfOthers(t *testing.T) {
+	recipientId := "1"
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: "99", Admin: true})
+
+	mockReader := mocks.NewReader(t)
+	mockReader.Mock.On("GetUserById", ctx, recipientId).Return(database.UserModel{Id: recipientId, Likes: 3}, nil)
+	server := handlers.Server{
+		DatabaseReader: mockReader,
+		DatabaseWriter: mocks.NewWriter(t),
+	}
+
+	output, err := server.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: recipientId})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.CountLikedYouResponse{Count: 3}, output)
+}
@@ -0,0 +1,42 @@
+package interceptors
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Timeouts holds the server side deadline of each method, indexed by method name (e.g. "PutDecision").
+// Methods without an entry use Default; a zero Default leaves them without deadline.
+type Timeouts struct {
+	Default time.Duration
+	Methods map[string]time.Duration
+}
+
+func (t Timeouts) forMethod(fullMethod string) time.Duration {
+	if timeout, ok := t.Methods[path.Base(fullMethod)]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+// DeadlineUnaryServerInterceptor applies the method timeout to calls whose client did not send a deadline,
+// so slow queries cannot hold a goroutine forever. Deadlines sent by clients are left untouched.
+func DeadlineUnaryServerInterceptor(timeouts Timeouts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return handler(ctx, req)
+		}
+
+		timeout := timeouts.forMethod(info.FullMethod)
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
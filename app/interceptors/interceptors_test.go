@@ -0,0 +1,93 @@
+package interceptors_test
+
+import (
+	"app/interceptors"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryUnaryServerInterceptor(t *testing.T) {
+	interceptor := interceptors.RecoveryUnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/PutDecision"}
+
+	output, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		var user *struct{ Id string }
+		return user.Id, nil
+	})
+	assert.Nil(t, output)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	output, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", output)
+}
+
+func TestDeadlineUnaryServerInterceptor(t *testing.T) {
+	timeouts := interceptors.Timeouts{
+		Default: time.Second,
+		Methods: map[string]time.Duration{"PutDecision": 100 * time.Millisecond},
+	}
+	interceptor := interceptors.DeadlineUnaryServerInterceptor(timeouts)
+
+	remaining := func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return time.Duration(0), nil
+		}
+		return time.Until(deadline), nil
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		ctx      func() (context.Context, context.CancelFunc)
+		expected time.Duration
+	}{
+		{
+			name:     "method_timeout",
+			method:   "/explore.ExploreService/PutDecision",
+			ctx:      func() (context.Context, context.CancelFunc) { return context.Background(), func() {} },
+			expected: 100 * time.Millisecond,
+		},
+		{
+			name:     "default_timeout",
+			method:   "/explore.ExploreService/ListLikedYou",
+			ctx:      func() (context.Context, context.CancelFunc) { return context.Background(), func() {} },
+			expected: time.Second,
+		},
+		{
+			name:   "client_deadline_is_kept",
+			method: "/explore.ExploreService/PutDecision",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Second)
+			},
+			expected: 10 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := test.ctx()
+			defer cancel()
+
+			output, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, remaining)
+			assert.NoError(t, err)
+			left := output.(time.Duration)
+			assert.True(t, left <= test.expected)
+			assert.True(t, left > test.expected-50*time.Millisecond)
+		})
+	}
+
+	noDefault := interceptors.DeadlineUnaryServerInterceptor(interceptors.Timeouts{})
+	output, err := noDefault(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/ListLikedYou"}, remaining)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), output)
+}
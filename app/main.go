@@ -1,45 +1,103 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"flag"
 	"log"
 	"net"
-	"os"
 
+	"app/config"
 	"app/database"
 	pb "app/explore_service_protos"
 	"app/handlers"
+	"app/reconcile"
+	"app/retention"
+	"app/tlsconfig"
 
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
 	godotenv.Load()
-	lis, err := net.Listen("tcp", ":"+os.Getenv("LISTEN_PORT"))
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("failed to listen %v", err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	connection := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true",
-		os.Getenv("MYSQL_USER"),
-		os.Getenv("MYSQL_PASSWORD"),
-		os.Getenv("MYSQL_HOST"),
-		os.Getenv("MYSQL_PORT"),
-		os.Getenv("MYSQL_DATABASE"),
-	)
 
-	db, err := sql.Open("mysql", connection)
+	flag.StringVar(&cfg.Storage, "storage", cfg.Storage, "-storage=mysql|postgres|sqlite|memory | backend storing users and decisions")
+	flag.BoolVar(&cfg.MigrateOnStart, "migrate-on-start", cfg.MigrateOnStart, "-migrate-on-start | apply the pending MySQL or PostgreSQL migrations before serving")
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", ":"+cfg.ListenPort)
+	if err != nil {
+		log.Fatalf("failed to listen %v", err)
+	}
 
+	reader, writer, reconciler, err := newStorage(cfg)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterExploreServiceServer(grpcServer, &handlers.Server{
-		DatabaseReader: database.NewDatabaseReader(db),
-		DatabaseWriter: database.NewDatabaseWriter(db),
-	})
+	if cfg.Reconcile.Interval > 0 {
+		job := reconcile.Job{Reconciler: reconciler, Interval: cfg.Reconcile.Interval, BatchSize: cfg.Reconcile.BatchSize}
+		go job.Run(context.Background())
+	}
+	if cfg.Retention.Interval > 0 {
+		// the reconciler is the writer of the backend, which archives the decisions too
+		archiver, ok := reconciler.(database.DecisionArchiver)
+		if !ok {
+			log.Fatalf("storage %q cannot archive decisions", cfg.Storage)
+		}
+		job := retention.Job{
+			Archiver:        archiver,
+			Interval:        cfg.Retention.Interval,
+			BatchSize:       cfg.Retention.BatchSize,
+			Pause:           cfg.Retention.Pause,
+			PassAge:         cfg.Retention.PassAge,
+			InactiveLikeAge: cfg.Retention.InactiveLikeAge,
+		}
+		go job.Run(context.Background())
+	}
+
+	chain, err := newInterceptorChain(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure interceptors: %v", err)
+	}
+	serverOptions := []grpc.ServerOption{chain}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled() {
+		tlsConfig, err = tlsconfig.Server(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else {
+		log.Printf("WARNING: TLS is disabled, serving plaintext gRPC")
+	}
+
+	exploreServer := &handlers.Server{
+		DatabaseReader: reader,
+		DatabaseWriter: writer,
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
+	pb.RegisterExploreServiceServer(grpcServer, exploreServer)
+
+	if cfg.HTTPListenPort != "" {
+		gatewayServer, err := newGatewayServer(":"+cfg.HTTPListenPort, exploreServer, tlsConfig, chain)
+		if err != nil {
+			log.Fatalf("failed to configure the REST gateway: %v", err)
+		}
+		go func() {
+			if err := serveGateway(gatewayServer); err != nil {
+				log.Fatalf("Failed to serve REST gateway %s", err)
+			}
+		}()
+	}
 
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("Feiled to serve %s", err)
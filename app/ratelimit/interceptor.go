@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"net"
+	"path"
+	"strconv"
+
+	"app/auth"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// gatewayNetwork is the network of the in-process connection of the REST gateway, the only peer whose
+// x-forwarded-for metadata is trusted
+const gatewayNetwork = "bufconn"
+
+// Limits holds the limit of each method, indexed by method name (e.g. "PutDecision").
+// Methods without an entry use Default; a nil Default leaves them unlimited.
+type Limits struct {
+	Default *Limit
+	Methods map[string]Limit
+}
+
+func (l Limits) forMethod(fullMethod string) (Limit, bool) {
+	if limit, ok := l.Methods[path.Base(fullMethod)]; ok {
+		return limit, true
+	}
+	if l.Default != nil {
+		return *l.Default, true
+	}
+	return Limit{}, false
+}
+
+// UnaryServerInterceptor rejects calls with ResourceExhausted once the caller has used up its tokens for the method.
+// Callers are identified by their authenticated user ID, falling back to their address.
+// When the store fails the call is let through, rate limiting must not take the service down.
+func UnaryServerInterceptor(store Store, limits Limits) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		limit, ok := limits.forMethod(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		key := path.Base(info.FullMethod) + ":" + callerKey(ctx)
+		result, err := store.Take(ctx, key, limit)
+		if err != nil {
+			log.Printf("Error on rate limit store, allowing call to %s: %s", info.FullMethod, err)
+			return handler(ctx, req)
+		}
+
+		if !result.Allowed {
+			return nil, exhausted(result)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// callerKey identifies the caller by its user ID, or else by its address: the address of the HTTP client forwarded
+// by the gateway, trusted from the in-process connection of the gateway only, or the peer address.
+func callerKey(ctx context.Context) string {
+	if identity, ok := auth.FromContext(ctx); ok && identity.UserId != "" {
+		return "user:" + identity.UserId
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	if p.Addr.Network() == gatewayNetwork {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 && forwarded[0] != "" {
+			return "peer:" + forwarded[0]
+		}
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return "peer:" + host
+}
+
+func exhausted(result Result) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded, retry in "+strconv.FormatInt(result.RetryAfter.Milliseconds(), 10)+"ms")
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(result.RetryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
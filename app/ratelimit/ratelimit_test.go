@@ -0,0 +1,150 @@
+package ratelimit_test
+
+import (
+	"app/auth"
+	"app/ratelimit"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	store := ratelimit.NewMemoryStoreWithClock(c.Now)
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take(ctx, "a", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := store.Take(ctx, "a", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	other, err := store.Take(ctx, "b", limit)
+	assert.NoError(t, err)
+	assert.True(t, other.Allowed)
+
+	c.Advance(500 * time.Millisecond)
+	result, err = store.Take(ctx, "a", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	c.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		result, err = store.Take(ctx, "a", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	result, err = store.Take(ctx, "a", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	limits := ratelimit.Limits{
+		Methods: map[string]ratelimit.Limit{
+			"PutDecision": {Rate: 1, Burst: 1},
+		},
+	}
+	interceptor := ratelimit.UnaryServerInterceptor(ratelimit.NewMemoryStoreWithClock(c.Now), limits)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	putDecision := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/PutDecision"}
+	countLikedYou := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+
+	userOne := auth.NewContext(context.Background(), auth.Identity{UserId: "1"})
+	userTwo := auth.NewContext(context.Background(), auth.Identity{UserId: "2"})
+	anonymous := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}})
+	anonymousOtherPort := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4001}})
+
+	_, err := interceptor(userOne, nil, putDecision, handler)
+	assert.NoError(t, err)
+
+	_, err = interceptor(userOne, nil, putDecision, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	details := status.Convert(err).Details()
+	assert.Equal(t, 1, len(details))
+	retryInfo, ok := details[0].(*errdetails.RetryInfo)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, retryInfo.RetryDelay.AsDuration())
+
+	// other users and methods without a limit are not affected
+	_, err = interceptor(userTwo, nil, putDecision, handler)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = interceptor(userOne, nil, countLikedYou, handler)
+		assert.NoError(t, err)
+	}
+
+	// unauthenticated callers share the bucket of their address
+	_, err = interceptor(anonymous, nil, putDecision, handler)
+	assert.NoError(t, err)
+	_, err = interceptor(anonymousOtherPort, nil, putDecision, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the forwarded address is only trusted from the gateway
+	_, err = interceptor(metadata.NewIncomingContext(anonymous, metadata.Pairs("x-forwarded-for", "10.0.0.2")), nil, putDecision, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	c.Advance(time.Second)
+	_, err = interceptor(userOne, nil, putDecision, handler)
+	assert.NoError(t, err)
+}
+
+func TestUnaryServerInterceptorDefaultLimitAndFailOpen(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), auth.Identity{UserId: "1"})
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/ListLikedYou"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	limits := ratelimit.Limits{Default: &ratelimit.Limit{Rate: 0, Burst: 2}}
+	interceptor := ratelimit.UnaryServerInterceptor(ratelimit.NewMemoryStore(), limits)
+	for i := 0; i < 2; i++ {
+		_, err := interceptor(ctx, nil, info, handler)
+		assert.NoError(t, err)
+	}
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	failOpen := ratelimit.UnaryServerInterceptor(failingStore{}, limits)
+	output, err := failOpen(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", output)
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: Rate tokens are added every second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long the caller has to wait before a token is available when the call is not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets. MemoryStore is local to the process; implement Store on top of a shared
+// store (e.g. Redis) to enforce limits across several server replicas.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore is a thread-safe in-process Store
+type MemoryStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+// sweepEvery is the number of takes between two evictions of the buckets that are full again
+const sweepEvery = 10000
+
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(time.Now)
+}
+
+// NewMemoryStoreWithClock creates a MemoryStore reading the time from now, for tests
+func NewMemoryStoreWithClock(now func() time.Time) *MemoryStore {
+	return &MemoryStore{
+		now:     now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Take removes one token from the bucket identified by key
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true}, nil
+	}
+
+	if limit.Rate <= 0 {
+		return Result{Allowed: false}, nil
+	}
+
+	missing := 1 - b.tokens
+	return Result{
+		Allowed:    false,
+		RetryAfter: time.Duration(missing / limit.Rate * float64(time.Second)),
+	}, nil
+}
+
+// sweep drops the buckets that have been idle long enough to be full again, they are recreated on demand
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.limit.Rate <= 0 {
+			continue
+		}
+		refill := time.Duration(float64(b.limit.Burst) / b.limit.Rate * float64(time.Second))
+		if now.Sub(b.last) > refill {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Interface guards
+var (
+	_ Store = (*MemoryStore)(nil)
+)
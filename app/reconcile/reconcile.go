@@ -0,0 +1,58 @@
+// Package reconcile repairs the denormalised user counters when they drift from the decisions,
+// e.g. after a manual data fix or a write that bypassed the database package.
+package reconcile
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"app/database"
+)
+
+// Job walks every user in batches of BatchSize and repairs their counters, every Interval when run in the background
+type Job struct {
+	Reconciler database.CounterReconciler
+	Interval   time.Duration
+	BatchSize  int
+}
+
+// RunOnce reconciles every user once and returns the number of users repaired
+func (j Job) RunOnce(ctx context.Context) (int, error) {
+	var afterUserId uint
+	total := 0
+	for {
+		lastUserId, repaired, err := j.Reconciler.ReconcileCounters(ctx, afterUserId, j.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += repaired
+		if lastUserId == 0 {
+			return total, nil
+		}
+		afterUserId = lastUserId
+	}
+}
+
+// Run reconciles every user every Interval until ctx is done
+func (j Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		repaired, err := j.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Error reconciling user counters: %s", err)
+			continue
+		}
+		if repaired > 0 {
+			log.Printf("Repaired the counters of %d users", repaired)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package reconcile_test
+
+import (
+	"app/database"
+	"app/database/memory"
+	"app/reconcile"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRunOnceRepairsEveryBatch(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+
+	for i := 0; i < 7; i++ {
+		_, err := store.AddUser(database.UserModel{Name: "user", Gender: "f", IsAactive: true, Likes: uint(i % 2)})
+		assert.NoError(t, err)
+	}
+	_, err := store.AddDecision("2", "1", true, true)
+	assert.NoError(t, err)
+	_, err = store.AddDecision("1", "2", true, false)
+	assert.NoError(t, err)
+
+	job := reconcile.Job{Reconciler: store, BatchSize: 3}
+	repaired, err := job.RunOnce(ctx)
+	assert.NoError(t, err)
+	// users 1 and 2 miss their decisions, users 4 and 6 have a like too many
+	assert.Equal(t, 4, repaired)
+
+	user, err := store.GetUserById(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, [3]uint{1, 1, 1}, [3]uint{user.Likes, user.NewLikes, user.Matches})
+	user, err = store.GetUserById(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, [3]uint{1, 0, 1}, [3]uint{user.Likes, user.NewLikes, user.Matches})
+
+	repaired, err = job.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, repaired)
+}
+
+func TestRunStopsWithTheContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := memory.NewStore()
+	_, err := store.AddUser(database.UserModel{Name: "user", Gender: "f", IsAactive: true, Likes: 3})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		reconcile.Job{Reconciler: store, Interval: time.Millisecond, BatchSize: 10}.Run(ctx)
+		close(done)
+	}()
+
+	assert.NoError(t, waitFor(func() bool {
+		user, _ := store.GetUserById(context.Background(), "1")
+		return user.Likes == 0
+	}))
+	cancel()
+	<-done
+}
+
+func waitFor(condition func() bool) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
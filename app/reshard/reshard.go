@@ -0,0 +1,437 @@
+// Package reshard moves a range of buckets from a MySQL shard to another, with the decisions of their recipients
+// and the likes their users sent to other shards. It runs while the servers and jobs writing to the shards are
+// stopped, and can be run again after a failure: every batch is copied to the new shard before being deleted from
+// the old one, and the counters are recomputed last.
+package reshard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"app/database"
+	"app/reconcile"
+)
+
+// Resharder moves Range from the shard it is on to the shard To
+type Resharder struct {
+	// Shards are the databases of the shards, in the order of their index
+	Shards []*sql.DB
+	// Buckets is the shard map of the servers before the move
+	Buckets   database.ShardMap
+	Range     database.BucketRange
+	To        int
+	BatchSize int
+	Options   database.QueryOptions
+}
+
+type Summary struct {
+	From int
+	// Decisions is the number of decisions moved, archived ones included
+	Decisions int
+	// SentLikes is the number of sent likes moved, added or deleted
+	SentLikes int
+	// Repaired is the number of counters recomputed on the two shards
+	Repaired int
+	// Buckets is the shard map to serve with once moved
+	Buckets database.ShardMap
+}
+
+// decisionTable is a table of decisions and the timestamps copied with its rows
+type decisionTable struct {
+	name       string
+	timestamps []string
+}
+
+var decisionTables = []decisionTable{
+	{name: "decisions", timestamps: []string{"created_at", "updated_at"}},
+	{name: "decisions_archive", timestamps: []string{"created_at", "updated_at", "archived_at"}},
+}
+
+// decision is a row of a decisionTable, its timestamps read as text
+type decision struct {
+	id          uint
+	actorId     string
+	recipientId string
+	liked       bool
+	isNew       bool
+	timestamps  []sql.NullString
+}
+
+// sentLike is a row of sent_likes
+type sentLike struct {
+	actorId     string
+	recipientId string
+}
+
+// Run moves the range and returns the shard map to serve with
+func (r Resharder) Run(ctx context.Context) (Summary, error) {
+	from, err := r.from()
+	if err != nil {
+		return Summary{}, err
+	}
+	if r.BatchSize < 1 || r.BatchSize > database.MaxBulkRows {
+		return Summary{}, fmt.Errorf("invalid batch size %d, it must be between 1 and %d", r.BatchSize, database.MaxBulkRows)
+	}
+
+	m := mover{Resharder: r, from: from, moved: r.Buckets.Move(r.Range, r.To)}
+	summary := Summary{From: from, Buckets: m.moved}
+	if err := m.raiseIds(ctx); err != nil {
+		return summary, fmt.Errorf("unable to raise the decision IDs: %w", err)
+	}
+	for _, table := range decisionTables {
+		if err := m.moveDecisions(ctx, table, &summary); err != nil {
+			return summary, fmt.Errorf("unable to move %s: %w", table.name, err)
+		}
+	}
+	if err := m.moveSentLikes(ctx, &summary); err != nil {
+		return summary, fmt.Errorf("unable to move sent_likes: %w", err)
+	}
+
+	for _, index := range []int{from, r.To} {
+		writer, err := database.NewDatabaseWriter(r.Shards[index], r.Options)
+		if err != nil {
+			return summary, err
+		}
+		repaired, err := reconcile.Job{Reconciler: writer, BatchSize: r.BatchSize}.RunOnce(ctx)
+		summary.Repaired += repaired
+		if err != nil {
+			return summary, fmt.Errorf("unable to reconcile the counters of shard %d: %w", index, err)
+		}
+	}
+	return summary, nil
+}
+
+// from returns the shard of the range, which must be on a single shard other than To
+func (r Resharder) from() (int, error) {
+	if r.To < 0 || r.To >= len(r.Shards) {
+		return 0, fmt.Errorf("invalid shard %d, there are %d shards", r.To, len(r.Shards))
+	}
+	from := r.Buckets[r.Range.First]
+	for bucket := r.Range.First; bucket <= r.Range.Last; bucket++ {
+		if r.Buckets[bucket] != from {
+			return 0, fmt.Errorf("buckets %s are on shards %d and %d, move the range of each shard on its own", r.Range, from, r.Buckets[bucket])
+		}
+	}
+	if from == r.To {
+		return 0, fmt.Errorf("buckets %s are already on shard %d", r.Range, r.To)
+	}
+	return from, nil
+}
+
+// maxDecisionIdQuery reads the highest decision ID of a shard, archived decisions included
+const maxDecisionIdQuery = `
+SELECT GREATEST(
+	COALESCE((SELECT MAX(id) FROM decisions), 0),
+	COALESCE((SELECT MAX(id) FROM decisions_archive), 0)
+);
+`
+
+// raiseIds moves the next decision ID of every shard above the highest decision ID of all of them. The shards
+// allocate their IDs in steps of their number, so once a shard is added the new steps cannot reach an ID
+// allocated with the old ones.
+func (r Resharder) raiseIds(ctx context.Context) error {
+	var highest uint
+	for _, db := range r.Shards {
+		var id uint
+		if err := db.QueryRowContext(ctx, maxDecisionIdQuery).Scan(&id); err != nil {
+			return err
+		}
+		highest = max(highest, id)
+	}
+	for index, db := range r.Shards {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE decisions AUTO_INCREMENT = %d", highest+1)); err != nil {
+			return fmt.Errorf("shard %d: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// mover is the state of a run
+type mover struct {
+	Resharder
+	from  int
+	moved database.ShardMap
+}
+
+// inRange reports whether a user is in the buckets moved
+func (m mover) inRange(userId string) bool {
+	return m.Range.Contains(database.Bucket(userId))
+}
+
+// moveDecisions walks the rows of table on the old shard involving a user of the range. The rows of the
+// recipients of the range are copied to the new shard with their ID, then deleted. The likes of the range left
+// on the old shard, and those a user of the old shard gave to the range, are now between two shards and are
+// added to sent_likes; the likes a user of the new shard gave to the range are no longer.
+func (m mover) moveDecisions(ctx context.Context, table decisionTable, summary *Summary) error {
+	columns := "id, actor_id, recipient_id, liked, is_new"
+	for _, timestamp := range table.timestamps {
+		columns += ", " + timestamp
+	}
+	selectQuery := fmt.Sprintf(`
+SELECT %s
+FROM %s
+WHERE id > ?
+AND (CRC32(recipient_id) %% %d BETWEEN ? AND ? OR CRC32(actor_id) %% %d BETWEEN ? AND ?)
+ORDER BY id
+LIMIT ?;
+`, columns, table.name, database.Buckets, database.Buckets)
+
+	var afterId uint
+	for {
+		rows, err := m.Shards[m.from].QueryContext(ctx, selectQuery,
+			afterId, m.Range.First, m.Range.Last, m.Range.First, m.Range.Last, m.BatchSize)
+		decisions, err := scanDecisions(rows, err, len(table.timestamps))
+		if err != nil {
+			return err
+		}
+		if len(decisions) == 0 {
+			return nil
+		}
+		afterId = decisions[len(decisions)-1].id
+
+		var moving []decision
+		var fromLikes, addedLikes, droppedLikes []sentLike
+		for _, d := range decisions {
+			like := sentLike{actorId: d.actorId, recipientId: d.recipientId}
+			switch {
+			case !m.inRange(d.recipientId):
+				// the actor is in the range and leaves the recipient behind
+				if d.liked {
+					addedLikes = append(addedLikes, like)
+				}
+			case d.liked && m.moved.Shard(d.actorId) == m.from:
+				moving = append(moving, d)
+				fromLikes = append(fromLikes, like)
+			case d.liked && m.Buckets.Shard(d.actorId) == m.To:
+				moving = append(moving, d)
+				droppedLikes = append(droppedLikes, like)
+			default:
+				moving = append(moving, d)
+			}
+		}
+
+		if err := m.copyDecisions(ctx, table, columns, moving, addedLikes, droppedLikes); err != nil {
+			return err
+		}
+		if err := m.deleteDecisions(ctx, table, moving, fromLikes); err != nil {
+			return err
+		}
+		summary.Decisions += len(moving)
+		summary.SentLikes += len(fromLikes) + len(addedLikes) + len(droppedLikes)
+	}
+}
+
+// copyDecisions upserts the decisions on the new shard and updates its sent likes in one transaction. An ID
+// already used by a decision of another pair of users stops the move, as the shards must allocate disjoint IDs.
+func (m mover) copyDecisions(ctx context.Context, table decisionTable, columns string, decisions []decision, added []sentLike, dropped []sentLike) error {
+	tx, err := m.Shards[m.To].BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(decisions) > 0 {
+		if err := checkIds(ctx, tx, table, decisions); err != nil {
+			return err
+		}
+		var args []any
+		for _, d := range decisions {
+			args = append(args, d.id, d.actorId, d.recipientId, d.liked, d.isNew)
+			for _, timestamp := range d.timestamps {
+				args = append(args, timestamp)
+			}
+		}
+		updates := "liked = VALUES(liked), is_new = VALUES(is_new)"
+		for _, timestamp := range table.timestamps {
+			updates += fmt.Sprintf(", %s = VALUES(%s)", timestamp, timestamp)
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE %s;",
+			table.name, columns, database.BulkValues(len(decisions), 5+len(table.timestamps)), updates), args...)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertSentLikes(ctx, tx, added); err != nil {
+		return err
+	}
+	if err := deleteSentLikes(ctx, tx, dropped); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkIds fails when an ID of decisions is used on the new shard by another pair of users
+func checkIds(ctx context.Context, tx *sql.Tx, table decisionTable, decisions []decision) error {
+	pairs := make(map[uint]sentLike, len(decisions))
+	var args []any
+	for _, d := range decisions {
+		pairs[d.id] = sentLike{actorId: d.actorId, recipientId: d.recipientId}
+		args = append(args, d.id)
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT id, actor_id, recipient_id FROM %s WHERE id IN %s;",
+		table.name, database.BulkValues(1, len(args))), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var pair sentLike
+		if err := rows.Scan(&id, &pair.actorId, &pair.recipientId); err != nil {
+			return err
+		}
+		if pairs[id] != pair {
+			return fmt.Errorf("decision %d of %s is used by users %s and %s on the new shard, the shards must allocate disjoint IDs",
+				id, table.name, pair.actorId, pair.recipientId)
+		}
+	}
+	return rows.Err()
+}
+
+// deleteDecisions deletes the decisions copied from the old shard and adds the sent likes it now keeps in one
+// transaction
+func (m mover) deleteDecisions(ctx context.Context, table decisionTable, decisions []decision, added []sentLike) error {
+	tx, err := m.Shards[m.from].BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(decisions) > 0 {
+		args := make([]any, len(decisions))
+		for i, d := range decisions {
+			args[i] = d.id
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id IN %s;",
+			table.name, database.BulkValues(1, len(args))), args...)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertSentLikes(ctx, tx, added); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// moveSentLikes moves the likes the users of the range sent to other shards to the new shard, dropping those
+// whose recipient lives on the new shard as they are kept by its decisions
+func (m mover) moveSentLikes(ctx context.Context, summary *Summary) error {
+	selectQuery := fmt.Sprintf(`
+SELECT actor_id, recipient_id
+FROM sent_likes
+WHERE (actor_id > ? OR (actor_id = ? AND recipient_id > ?))
+AND CRC32(actor_id) %% %d BETWEEN ? AND ?
+ORDER BY actor_id, recipient_id
+LIMIT ?;
+`, database.Buckets)
+
+	after := sentLike{actorId: "0", recipientId: "0"}
+	for {
+		rows, err := m.Shards[m.from].QueryContext(ctx, selectQuery,
+			after.actorId, after.actorId, after.recipientId, m.Range.First, m.Range.Last, m.BatchSize)
+		likes, err := scanSentLikes(rows, err)
+		if err != nil {
+			return err
+		}
+		if len(likes) == 0 {
+			return nil
+		}
+		after = likes[len(likes)-1]
+
+		var kept []sentLike
+		for _, like := range likes {
+			if m.moved.Shard(like.recipientId) != m.To {
+				kept = append(kept, like)
+			}
+		}
+
+		tx, err := m.Shards[m.To].BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := insertSentLikes(ctx, tx, kept); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		tx, err = m.Shards[m.from].BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := deleteSentLikes(ctx, tx, likes); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		summary.SentLikes += len(likes)
+	}
+}
+
+func insertSentLikes(ctx context.Context, tx *sql.Tx, likes []sentLike) error {
+	if len(likes) == 0 {
+		return nil
+	}
+	var args []any
+	for _, like := range likes {
+		args = append(args, like.actorId, like.recipientId)
+	}
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO sent_likes (actor_id, recipient_id) VALUES "+
+		database.BulkValues(len(likes), 2)+";", args...)
+	return err
+}
+
+func deleteSentLikes(ctx context.Context, tx *sql.Tx, likes []sentLike) error {
+	for _, like := range likes {
+		_, err := tx.ExecContext(ctx, "DELETE FROM sent_likes WHERE actor_id = ? AND recipient_id = ?;", like.actorId, like.recipientId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanDecisions(rows *sql.Rows, err error, timestamps int) ([]decision, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []decision
+	for rows.Next() {
+		d := decision{timestamps: make([]sql.NullString, timestamps)}
+		dest := []any{&d.id, &d.actorId, &d.recipientId, &d.liked, &d.isNew}
+		for i := range d.timestamps {
+			dest = append(dest, &d.timestamps[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
+
+func scanSentLikes(rows *sql.Rows, err error) ([]sentLike, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var likes []sentLike
+	for rows.Next() {
+		var like sentLike
+		if err := rows.Scan(&like.actorId, &like.recipientId); err != nil {
+			return nil, err
+		}
+		likes = append(likes, like)
+	}
+	return likes, rows.Err()
+}
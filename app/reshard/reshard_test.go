@@ -0,0 +1,183 @@
+package reshard_test
+
+import (
+	"app/database"
+	"app/reshard"
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestRangeOnTwoShards(t *testing.T) {
+	buckets, err := database.ParseShardMap("0-511:0,512-1023:1", 2)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		r    string
+		to   int
+	}{
+		{name: "range_on_two_shards", r: "500-600", to: 1},
+		{name: "range_on_the_new_shard", r: "512-600", to: 1},
+		{name: "unknown_shard", r: "0-100", to: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := database.ParseBucketRange(test.r)
+			assert.NoError(t, err)
+			resharder := reshard.Resharder{Shards: make([]*sql.DB, 2), Buckets: buckets, Range: r, To: test.to, BatchSize: 10}
+			_, err = resharder.Run(context.Background())
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestRun moves the users of a shard to another one by one when MYSQL_TEST_DSN and MYSQL_SHARD_TEST_DSN are set,
+// and checks the likes, matches and counters read through the new shard map after every move. The tables of both
+// databases are emptied first.
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	dbs := openShards(t)
+	for _, db := range dbs {
+		truncate(t, db)
+	}
+
+	buckets, err := database.ParseShardMap("0-1023:0", 2)
+	assert.NoError(t, err)
+	for id := 1; id <= 4; id++ {
+		for _, db := range dbs {
+			_, err := db.ExecContext(ctx, "INSERT INTO users (id, name, gender, is_active) VALUES (?, ?, 'f', 1)", id, "user"+strconv.Itoa(id))
+			assert.NoError(t, err)
+		}
+	}
+
+	router, err := database.NewShardRouter(dbs, buckets, database.QueryOptions{})
+	assert.NoError(t, err)
+	for _, decision := range []database.PutDecisionEntry{
+		{ActorId: "1", RecipientId: "2", Like: true},
+		{ActorId: "2", RecipientId: "1", Like: true},
+		{ActorId: "3", RecipientId: "1", Like: true},
+		{ActorId: "1", RecipientId: "3", Like: true},
+		{ActorId: "1", RecipientId: "4", Like: false},
+		{ActorId: "4", RecipientId: "2", Like: true},
+		{ActorId: "2", RecipientId: "3", Like: true},
+		{ActorId: "3", RecipientId: "4", Like: false},
+	} {
+		assert.NoError(t, router.InsertOrUpdateDecision(ctx, decision))
+	}
+	// the archived likes move too
+	_, err = dbs[0].ExecContext(ctx, `INSERT INTO decisions_archive (id, actor_id, recipient_id, liked, is_new, created_at, updated_at)
+		SELECT id, actor_id, recipient_id, liked, is_new, created_at, updated_at FROM decisions WHERE actor_id = 4`)
+	assert.NoError(t, err)
+	_, err = dbs[0].ExecContext(ctx, "DELETE FROM decisions WHERE actor_id = 4")
+	assert.NoError(t, err)
+
+	for _, userId := range []string{"1", "2", "3"} {
+		bucket := database.Bucket(userId)
+		resharder := reshard.Resharder{
+			Shards:    dbs,
+			Buckets:   buckets,
+			Range:     database.BucketRange{First: bucket, Last: bucket},
+			To:        1,
+			BatchSize: 2,
+		}
+		summary, err := resharder.Run(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, summary.From)
+		assert.Equal(t, 1, summary.Buckets.Shard(userId))
+		buckets = summary.Buckets
+
+		router, err := database.NewShardRouter(dbs, buckets, database.QueryOptions{})
+		assert.NoError(t, err)
+		for _, pair := range []struct {
+			actorId, recipientId string
+			match                bool
+		}{
+			{"1", "2", true},
+			{"2", "1", true},
+			{"1", "3", true},
+			{"2", "3", false},
+			{"1", "4", false},
+			{"4", "2", false},
+		} {
+			match, err := router.GetIsMatch(ctx, pair.actorId, pair.recipientId)
+			assert.NoError(t, err)
+			assert.Equal(t, pair.match, match)
+		}
+
+		for recipientId, likes := range map[string]int{"1": 2, "2": 2, "3": 2, "4": 0} {
+			decisions, err := router.FindLikesByRecipientIdPaginated(ctx, recipientId, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, likes, len(decisions))
+		}
+		users := readUsers(t, router)
+		assert.Equal(t, []uint{2, 2, 2, 0}, []uint{users[0].Likes, users[1].Likes, users[2].Likes, users[3].Likes})
+		assert.Equal(t, []uint{2, 1, 1, 0}, []uint{users[0].Matches, users[1].Matches, users[2].Matches, users[3].Matches})
+
+		// the counters moved are those the writers would keep
+		_, _, err = router.ReconcileCounters(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.DeepEqual(t, users, readUsers(t, router))
+	}
+
+	// the new shard allocates its decision IDs above those left on the old one
+	var highest int64
+	assert.NoError(t, dbs[0].QueryRowContext(ctx, "SELECT MAX(id) FROM decisions").Scan(&highest))
+	result, err := dbs[1].ExecContext(ctx, "INSERT INTO decisions (actor_id, recipient_id, liked) VALUES (4, 1, 0)")
+	assert.NoError(t, err)
+	id, err := result.LastInsertId()
+	assert.NoError(t, err)
+	assert.That(t, id > highest)
+}
+
+// readUsers reads users 1 to 4 from the shards of their buckets
+func readUsers(t *testing.T, router database.ShardRouter) []database.UserModel {
+	users, err := router.FindUsersByIds(context.Background(), []string{"1", "2", "3", "4"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(users))
+	return users
+}
+
+// openShards opens the databases of MYSQL_TEST_DSN and MYSQL_SHARD_TEST_DSN, the test is skipped without
+// them, or fails when CI is set
+func openShards(t *testing.T) []*sql.DB {
+	t.Helper()
+	var dbs []*sql.DB
+	for _, key := range []string{"MYSQL_TEST_DSN", "MYSQL_SHARD_TEST_DSN"} {
+		dsn := os.Getenv(key)
+		if dsn == "" && os.Getenv("CI") != "" {
+			t.Fatalf("%s is not set, the MySQL tests must run on CI", key)
+		}
+		if dsn == "" {
+			t.Skipf("%s is not set", key)
+		}
+		db, err := sql.Open("mysql", dsn)
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+func truncate(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	for _, statement := range []string{
+		"SET FOREIGN_KEY_CHECKS = 0",
+		"TRUNCATE TABLE decisions",
+		"TRUNCATE TABLE decisions_archive",
+		"TRUNCATE TABLE sent_likes",
+		"TRUNCATE TABLE users",
+		"SET FOREIGN_KEY_CHECKS = 1",
+	} {
+		_, err := conn.ExecContext(ctx, statement)
+		assert.NoError(t, err)
+	}
+}
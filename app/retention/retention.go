@@ -0,0 +1,97 @@
+// Package retention moves the decisions the likes RPCs no longer need to the archive: old passes, and the viewed
+// likes of users deactivated for long, which keeps the decisions table to the decisions still in use.
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"app/database"
+)
+
+// Job walks every decision in batches of BatchSize and archives those selected by its ages, every Interval when run
+// in the background
+type Job struct {
+	Archiver  database.DecisionArchiver
+	Interval  time.Duration
+	BatchSize int
+	// Pause between two batches, so the archival does not compete with the servers for the database
+	Pause time.Duration
+	// PassAge is the age of the passes archived, 0 keeps them
+	PassAge time.Duration
+	// InactiveLikeAge is how long an inactive user has not been active before their viewed likes are archived,
+	// 0 keeps them
+	InactiveLikeAge time.Duration
+	// Now reads the time, time.Now when nil
+	Now func() time.Time
+}
+
+// Policy returns the timestamps of the decisions archived by a run starting now
+func (j Job) Policy(now time.Time) database.ArchivePolicy {
+	var policy database.ArchivePolicy
+	if j.PassAge > 0 {
+		policy.PassesBefore = uint64(now.Add(-j.PassAge).Unix())
+	}
+	if j.InactiveLikeAge > 0 {
+		policy.InactiveLikesBefore = uint64(now.Add(-j.InactiveLikeAge).Unix())
+	}
+	return policy
+}
+
+// RunOnce archives the decisions selected when it starts and returns the number of decisions archived
+func (j Job) RunOnce(ctx context.Context) (int, error) {
+	now := time.Now
+	if j.Now != nil {
+		now = j.Now
+	}
+	policy := j.Policy(now())
+	if policy == (database.ArchivePolicy{}) {
+		return 0, nil
+	}
+
+	var afterId uint
+	total := 0
+	for {
+		lastId, archived, err := j.Archiver.ArchiveDecisions(ctx, policy, afterId, j.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += archived
+		if lastId == 0 {
+			return total, nil
+		}
+		afterId = lastId
+
+		if j.Pause > 0 {
+			select {
+			case <-ctx.Done():
+				return total, ctx.Err()
+			case <-time.After(j.Pause):
+			}
+		}
+	}
+}
+
+// Run archives the decisions every Interval until ctx is done
+func (j Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		archived, err := j.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Error archiving decisions: %s", err)
+			continue
+		}
+		if archived > 0 {
+			log.Printf("Archived %d decisions", archived)
+		}
+	}
+}
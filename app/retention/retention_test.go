@@ -0,0 +1,106 @@
+package retention_test
+
+import (
+	"app/database"
+	"app/database/memory"
+	"app/retention"
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	job := retention.Job{PassAge: time.Hour}
+	assert.Equal(t, database.ArchivePolicy{PassesBefore: 1699996400}, job.Policy(now))
+
+	job = retention.Job{PassAge: time.Hour, InactiveLikeAge: 24 * time.Hour}
+	assert.Equal(t, database.ArchivePolicy{PassesBefore: 1699996400, InactiveLikesBefore: 1699913600}, job.Policy(now))
+
+	assert.Equal(t, database.ArchivePolicy{}, retention.Job{}.Policy(now))
+}
+
+func TestRunOnceArchivesEveryBatch(t *testing.T) {
+	ctx := context.Background()
+	decidedAt := time.Unix(1700000000, 0)
+	store := memory.NewStoreWithClock(func() time.Time { return decidedAt })
+
+	for range 7 {
+		_, err := store.AddUser(database.UserModel{Name: "user", Gender: "f", IsAactive: true})
+		assert.NoError(t, err)
+	}
+	// the users 2, 4 and 6 pass on the first one, the others like them
+	for actor := 2; actor <= 7; actor++ {
+		_, err := store.AddDecision(strconv.Itoa(actor), "1", actor%2 == 1, false)
+		assert.NoError(t, err)
+	}
+
+	job := retention.Job{Archiver: store, BatchSize: 2, PassAge: time.Hour}
+	job.Now = func() time.Time { return decidedAt.Add(30 * time.Minute) }
+	archived, err := job.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, archived)
+
+	job.Now = func() time.Time { return decidedAt.Add(2 * time.Hour) }
+	archived, err = job.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, archived)
+
+	archived, err = job.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, archived)
+
+	// the likes are still listed
+	likes, err := store.FindLikesByRecipientIdPaginated(ctx, "1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(likes))
+}
+
+// countingArchiver archives nothing and counts the batches
+type countingArchiver struct {
+	batches atomic.Int32
+}
+
+func (a *countingArchiver) ArchiveDecisions(ctx context.Context, policy database.ArchivePolicy, afterId uint, limit int) (uint, int, error) {
+	a.batches.Add(1)
+	return 0, 0, nil
+}
+
+func TestRunStopsWithTheContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	archiver := &countingArchiver{}
+
+	done := make(chan struct{})
+	go func() {
+		retention.Job{Archiver: archiver, Interval: time.Millisecond, BatchSize: 10, PassAge: time.Hour}.Run(ctx)
+		close(done)
+	}()
+
+	assert.NoError(t, waitFor(func() bool { return archiver.batches.Load() >= 2 }))
+	cancel()
+	<-done
+}
+
+func TestRunOnceWithoutAgesArchivesNothing(t *testing.T) {
+	archiver := &countingArchiver{}
+	archived, err := retention.Job{Archiver: archiver, BatchSize: 10}.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, archived)
+	assert.Equal(t, int32(0), archiver.batches.Load())
+}
+
+func waitFor(condition func() bool) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return context.DeadlineExceeded
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
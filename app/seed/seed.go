@@ -0,0 +1,285 @@
+// Package seed generates synthetic users and decisions, to try the service or load test it on realistic data.
+// The popularity and the activity of the users follow power laws, so a few users receive and make most of the
+// decisions, and the data only depends on the options: a seed value always generates the same users and decisions.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+
+	"app/database"
+	"app/reconcile"
+)
+
+// reciprocity is the least probability of liking back a user who liked you, which makes the matches
+const reciprocity = 0.5
+
+// maxDraws bounds the recipients drawn for a decision before giving up on users who already decided on everyone drawn
+const maxDraws = 20
+
+type Options struct {
+	// Users is the number of users generated
+	Users int
+	// FemaleRatio is the share of users with the f gender, the others are m
+	FemaleRatio float64
+	// ActiveRatio is the share of active users, only they make and receive decisions
+	ActiveRatio float64
+	// Decisions is the mean number of decisions made by an active user
+	Decisions int
+	// Exponent is the shape of the power laws of the popularity and of the activity, greater than 1. The lower it is,
+	// the more the decisions are concentrated on a few users.
+	Exponent float64
+	// LikeRatio is the probability of liking the least popular users, more popular ones are liked more often
+	LikeRatio float64
+	// NewRatio is the share of likes not viewed yet
+	NewRatio float64
+	// Seed makes the generated data reproducible
+	Seed uint64
+	// BatchSize is the rows written per insert, at most database.MaxBulkRows
+	BatchSize int
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.Users < 1:
+		return errors.New("at least one user must be generated")
+	case o.FemaleRatio < 0 || o.FemaleRatio > 1:
+		return fmt.Errorf("invalid female ratio %v, it must be between 0 and 1", o.FemaleRatio)
+	case o.ActiveRatio < 0 || o.ActiveRatio > 1:
+		return fmt.Errorf("invalid active ratio %v, it must be between 0 and 1", o.ActiveRatio)
+	case o.Decisions < 0:
+		return fmt.Errorf("invalid decisions per user %d", o.Decisions)
+	case o.Exponent <= 1:
+		return fmt.Errorf("invalid exponent %v, it must be greater than 1", o.Exponent)
+	case o.LikeRatio < 0 || o.LikeRatio > 1:
+		return fmt.Errorf("invalid like ratio %v, it must be between 0 and 1", o.LikeRatio)
+	case o.NewRatio < 0 || o.NewRatio > 1:
+		return fmt.Errorf("invalid new ratio %v, it must be between 0 and 1", o.NewRatio)
+	case o.BatchSize < 1 || o.BatchSize > database.MaxBulkRows:
+		return fmt.Errorf("invalid batch size %d, it must be between 1 and %d", o.BatchSize, database.MaxBulkRows)
+	}
+	return nil
+}
+
+// Summary describes the generated data
+type Summary struct {
+	FirstUserId uint
+	LastUserId  uint
+	Users       int
+	Female      int
+	Active      int
+	Decisions   int
+	Likes       int
+	// Matches counts the pairs of users liking each other
+	Matches int
+	// Repaired is the number of users whose counters were recomputed
+	Repaired int
+}
+
+// Seeder adds the generated users after the last user of the database, then their decisions, and finally
+// recomputes the counters of every user
+type Seeder struct {
+	Writer     database.BulkWriter
+	Reconciler database.CounterReconciler
+	Options
+}
+
+type user struct {
+	female     bool
+	active     bool
+	popularity float64
+	activity   float64
+}
+
+// pool holds the active users of a gender, drawn in proportion to their popularity
+type pool struct {
+	users      []int
+	cumulative []float64
+}
+
+func (p *pool) add(index int, popularity float64) {
+	total := popularity
+	if len(p.cumulative) > 0 {
+		total += p.cumulative[len(p.cumulative)-1]
+	}
+	p.users = append(p.users, index)
+	p.cumulative = append(p.cumulative, total)
+}
+
+func (p *pool) draw(rng *rand.Rand) int {
+	x := rng.Float64() * p.cumulative[len(p.cumulative)-1]
+	return p.users[sort.SearchFloat64s(p.cumulative, x)]
+}
+
+// Run generates and writes the data. It keeps every decision in memory, about 50 bytes each.
+func (s Seeder) Run(ctx context.Context) (Summary, error) {
+	if err := s.validate(); err != nil {
+		return Summary{}, err
+	}
+
+	last, err := s.Writer.LastUserId(ctx)
+	if err != nil {
+		return Summary{}, err
+	}
+	rng := rand.New(rand.NewPCG(s.Seed, s.Seed))
+	summary := Summary{FirstUserId: last + 1, LastUserId: last + uint(s.Users), Users: s.Users}
+
+	users := make([]user, s.Users)
+	batch := make([]database.UserModel, 0, s.BatchSize)
+	for i := range users {
+		u := user{
+			female:     rng.Float64() < s.FemaleRatio,
+			active:     rng.Float64() < s.ActiveRatio,
+			popularity: s.powerLaw(rng),
+			activity:   s.powerLaw(rng),
+		}
+		users[i] = u
+		if u.female {
+			summary.Female++
+		}
+		if u.active {
+			summary.Active++
+		}
+
+		batch = append(batch, database.UserModel{
+			Id:        strconv.FormatUint(uint64(summary.FirstUserId)+uint64(i), 10),
+			Name:      name(rng, u.female),
+			Gender:    gender(u.female),
+			IsAactive: u.active,
+		})
+		if len(batch) == s.BatchSize || i == len(users)-1 {
+			if err := s.Writer.InsertUsers(ctx, batch); err != nil {
+				return summary, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := s.decide(ctx, rng, users, &summary); err != nil {
+		return summary, err
+	}
+
+	job := reconcile.Job{Reconciler: s.Reconciler, BatchSize: s.BatchSize}
+	summary.Repaired, err = job.RunOnce(ctx)
+	return summary, err
+}
+
+// decide makes the active users decide on active users of the other gender, or of their own when there is no
+// other, each making a share of the decisions proportional to their activity
+func (s Seeder) decide(ctx context.Context, rng *rand.Rand, users []user, summary *Summary) error {
+	var pools [2]pool
+	var totalActivity float64
+	for i, u := range users {
+		if u.active {
+			pools[genderIndex(u.female)].add(i, u.popularity)
+			totalActivity += u.activity
+		}
+	}
+
+	// every decision is a slot of its actor, shuffled so the users decide in turn and can like back
+	var slots []int
+	for i, u := range users {
+		if !u.active {
+			continue
+		}
+		candidates := s.candidates(pools, u)
+		budget := int(math.Round(float64(s.Decisions*summary.Active) * u.activity / totalActivity))
+		budget = min(budget, len(candidates.users)/2)
+		for range budget {
+			slots = append(slots, i)
+		}
+	}
+	rng.Shuffle(len(slots), func(i, j int) { slots[i], slots[j] = slots[j], slots[i] })
+
+	liked := map[[2]int]bool{}
+	batch := make([]database.BulkDecision, 0, s.BatchSize)
+	for n, actor := range slots {
+		candidates := s.candidates(pools, users[actor])
+		recipient := -1
+		for range maxDraws {
+			drawn := candidates.draw(rng)
+			if _, decided := liked[[2]int{actor, drawn}]; drawn != actor && !decided {
+				recipient = drawn
+				break
+			}
+		}
+
+		if recipient >= 0 {
+			probability := min(s.LikeRatio*math.Sqrt(users[recipient].popularity), 0.95)
+			likedBack := liked[[2]int{recipient, actor}]
+			if likedBack {
+				probability = max(probability, reciprocity)
+			}
+			like := rng.Float64() < probability
+			liked[[2]int{actor, recipient}] = like
+
+			summary.Decisions++
+			if like {
+				summary.Likes++
+				if likedBack {
+					summary.Matches++
+				}
+			}
+			batch = append(batch, database.BulkDecision{
+				ActorId:     summary.FirstUserId + uint(actor),
+				RecipientId: summary.FirstUserId + uint(recipient),
+				Liked:       like,
+				IsNew:       like && rng.Float64() < s.NewRatio,
+			})
+		}
+
+		if len(batch) == s.BatchSize || len(batch) > 0 && n == len(slots)-1 {
+			if err := s.Writer.InsertDecisions(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return nil
+}
+
+func (s Seeder) candidates(pools [2]pool, u user) *pool {
+	other := &pools[genderIndex(!u.female)]
+	if len(other.users) == 0 {
+		return &pools[genderIndex(u.female)]
+	}
+	return other
+}
+
+// powerLaw draws from a Pareto distribution of minimum 1 and shape Exponent
+func (s Seeder) powerLaw(rng *rand.Rand) float64 {
+	return math.Pow(1-rng.Float64(), -1/s.Exponent)
+}
+
+func genderIndex(female bool) int {
+	if female {
+		return 1
+	}
+	return 0
+}
+
+func gender(female bool) string {
+	if female {
+		return "f"
+	}
+	return "m"
+}
+
+var (
+	femaleNames = []string{"Emily", "Olivia", "Ava", "Isabella", "Charlotte", "Amelia", "Mia", "Evelyn", "Abigail", "Harper", "Sofia", "Grace", "Chloe", "Lily", "Zoe", "Nora"}
+	maleNames   = []string{"John", "Liam", "Ethan", "Mason", "Lucas", "Elijah", "Benjamin", "Jacob", "Daniel", "Noah", "James", "Henry", "Owen", "Leo", "Samuel", "Jack"}
+	lastNames   = []string{"Doe", "Thompson", "Walker", "Clark", "Mitchell", "Baker", "Scott", "Lewis", "Adams", "Morgan", "Carter", "Martinez", "Harris", "Robinson", "Wright", "Perez", "Green", "Turner", "Foster", "Hughes"}
+)
+
+func name(rng *rand.Rand, female bool) string {
+	first := maleNames
+	if female {
+		first = femaleNames
+	}
+	return first[rng.IntN(len(first))] + " " + lastNames[rng.IntN(len(lastNames))]
+}
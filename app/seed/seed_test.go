@@ -0,0 +1,163 @@
+package seed_test
+
+import (
+	"app/consistency"
+	"app/database"
+	"app/database/sqlite"
+	"app/seed"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+var options = seed.Options{
+	Users:       1000,
+	FemaleRatio: 0.3,
+	ActiveRatio: 0.8,
+	Decisions:   20,
+	Exponent:    1.5,
+	LikeRatio:   0.3,
+	NewRatio:    0.4,
+	Seed:        42,
+	BatchSize:   300,
+}
+
+func newSeeder(t *testing.T, options seed.Options) (seed.Seeder, *sql.DB) {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "muzzapp.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	writer := sqlite.NewDatabaseWriter(db)
+	return seed.Seeder{Writer: writer, Reconciler: writer, Options: options}, db
+}
+
+// dump lists every user and decision, with their counters and flags
+func dump(t *testing.T, db *sql.DB) []string {
+	var lines []string
+	for _, query := range []string{
+		"SELECT id, name, gender, is_active, likes, new_likes, matches FROM users ORDER BY id",
+		"SELECT actor_id, recipient_id, liked, is_new FROM decisions ORDER BY actor_id, recipient_id",
+	} {
+		rows, err := db.Query(query)
+		assert.NoError(t, err)
+		columns, err := rows.Columns()
+		assert.NoError(t, err)
+		for rows.Next() {
+			values := make([]any, len(columns))
+			pointers := make([]any, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			assert.NoError(t, rows.Scan(pointers...))
+			lines = append(lines, fmt.Sprint(values...))
+		}
+		assert.NoError(t, rows.Err())
+		rows.Close()
+	}
+	return lines
+}
+
+func count(t *testing.T, db *sql.DB, query string) int {
+	var n int
+	assert.NoError(t, db.QueryRow(query).Scan(&n))
+	return n
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	small := options
+	small.Users = 200
+
+	first, firstDB := newSeeder(t, small)
+	firstSummary, err := first.Run(ctx)
+	assert.NoError(t, err)
+	second, secondDB := newSeeder(t, small)
+	secondSummary, err := second.Run(ctx)
+	assert.NoError(t, err)
+
+	assert.Equal(t, firstSummary, secondSummary)
+	assert.Equal(t, dump(t, firstDB), dump(t, secondDB))
+
+	small.Seed++
+	other, otherDB := newSeeder(t, small)
+	_, err = other.Run(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, dump(t, firstDB), dump(t, otherDB))
+}
+
+func TestRunGeneratesRealisticData(t *testing.T) {
+	ctx := context.Background()
+	seeder, db := newSeeder(t, options)
+
+	// the generated users follow the ones already stored
+	_, err := sqlite.AddUser(ctx, db, database.UserModel{Name: "existing", Gender: "f", IsAactive: true})
+	assert.NoError(t, err)
+
+	summary, err := seeder.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), summary.FirstUserId)
+	assert.Equal(t, uint(1001), summary.LastUserId)
+	assert.Equal(t, 1001, count(t, db, "SELECT count(*) FROM users"))
+
+	assert.Equal(t, summary.Female, count(t, db, "SELECT count(*) FROM users WHERE id > 1 AND gender = 'f'"))
+	assert.Equal(t, summary.Active, count(t, db, "SELECT count(*) FROM users WHERE id > 1 AND is_active"))
+	assert.That(t, summary.Female > 250 && summary.Female < 350)
+	assert.That(t, summary.Active > 750 && summary.Active < 850)
+
+	assert.Equal(t, summary.Decisions, count(t, db, "SELECT count(*) FROM decisions"))
+	assert.Equal(t, summary.Likes, count(t, db, "SELECT count(*) FROM decisions WHERE liked"))
+	assert.Equal(t, summary.Likes, count(t, db, "SELECT sum(likes) FROM users"))
+	assert.Equal(t, 2*summary.Matches, count(t, db, "SELECT sum(matches) FROM users"))
+	assert.That(t, summary.Decisions > 10*summary.Active && summary.Decisions <= 20*summary.Active)
+	assert.That(t, summary.Matches > 0)
+
+	// decisions are made between active users of different genders
+	assert.Equal(t, 0, count(t, db, `
+		SELECT count(*)
+		FROM decisions d
+		JOIN users a ON a.id = d.actor_id
+		JOIN users r ON r.id = d.recipient_id
+		WHERE a.gender = r.gender OR NOT a.is_active OR NOT r.is_active`))
+
+	// the tenth of the users receiving the most likes receives far more than a tenth of them
+	top := count(t, db, "SELECT sum(likes) FROM (SELECT likes FROM users ORDER BY likes DESC LIMIT 100)")
+	assert.That(t, top*3 > summary.Likes)
+
+	// the counters were recomputed, and no invariant is broken
+	report := consistency.NewReport(true)
+	checker := consistency.Checker{DB: db, BatchSize: 200, DryRun: true}
+	assert.NoError(t, checker.Run(ctx, report, nil))
+	for _, found := range report.Checks {
+		assert.Equal(t, consistency.Summary{}, found)
+	}
+}
+
+func TestRunValidatesTheOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(o *seed.Options)
+	}{
+		{name: "no_users", change: func(o *seed.Options) { o.Users = 0 }},
+		{name: "female_ratio", change: func(o *seed.Options) { o.FemaleRatio = 1.5 }},
+		{name: "active_ratio", change: func(o *seed.Options) { o.ActiveRatio = -0.1 }},
+		{name: "decisions", change: func(o *seed.Options) { o.Decisions = -1 }},
+		{name: "exponent", change: func(o *seed.Options) { o.Exponent = 1 }},
+		{name: "like_ratio", change: func(o *seed.Options) { o.LikeRatio = 2 }},
+		{name: "new_ratio", change: func(o *seed.Options) { o.NewRatio = -1 }},
+		{name: "batch_size", change: func(o *seed.Options) { o.BatchSize = database.MaxBulkRows + 1 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invalid := options
+			test.change(&invalid)
+			seeder, db := newSeeder(t, invalid)
+			_, err := seeder.Run(context.Background())
+			assert.Error(t, err)
+			assert.Equal(t, 0, count(t, db, "SELECT count(*) FROM users"))
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/auth"
+	"app/config"
+	"app/database"
+	"app/database/cache"
+	"app/database/memory"
+	"app/database/migrations"
+	"app/database/postgres"
+	"app/database/sqlite"
+	pb "app/explore_service_protos"
+	"app/gateway"
+	"app/interceptors"
+	"app/ratelimit"
+	"app/reconcile"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newStorage opens the backend selected by cfg.Storage, behind the cache when one is configured.
+// The reconciler repairs the counters of the backend directly.
+func newStorage(cfg config.Config) (database.Reader, database.Writer, database.CounterReconciler, error) {
+	reader, writer, err := newBackend(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reconciler, ok := writer.(database.CounterReconciler)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("storage %q cannot reconcile counters", cfg.Storage)
+	}
+
+	var store cache.Store
+	switch cfg.Cache.Store {
+	case "":
+		return reader, writer, reconciler, nil
+	case config.CacheMemory:
+		store = cache.NewLRUStore(cfg.Cache.Size)
+	case config.CacheRedis:
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Cache.Redis.Addr,
+			Password: cfg.Cache.Redis.Password,
+			DB:       cfg.Cache.Redis.DB,
+		})
+		store = cache.NewRedisStore(client, "explore:")
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported cache store %q", cfg.Cache.Store)
+	}
+
+	options := cache.Options{TTL: cfg.Cache.TTL, Pages: cfg.Cache.Pages, LoadTimeout: cfg.Cache.LoadTimeout}
+	return cache.NewReader(reader, store, options), cache.NewWriter(writer, store, options), reconciler, nil
+}
+
+// newBackend opens the backend selected by cfg.Storage
+func newBackend(cfg config.Config) (database.Reader, database.Writer, error) {
+	switch cfg.Storage {
+	case config.StorageMySQL:
+		if len(cfg.MySQL.Shards) > 0 {
+			router, err := newShardRouter(cfg)
+			return router, router, err
+		}
+		if err := prepareSchema(migrations.MySQL, cfg.MySQL.DSN(), cfg); err != nil {
+			return nil, nil, err
+		}
+		db, err := sql.Open("mysql", cfg.MySQL.DSN())
+		if err != nil {
+			return nil, nil, err
+		}
+		pool, err := newReplicaPool(db, cfg.MySQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		options := cfg.MySQL.QueryOptions()
+		reader, err := database.NewDatabaseReaderWithReplicas(pool, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer, err := database.NewDatabaseWriter(db, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, writer, nil
+	case config.StoragePostgres:
+		if err := prepareSchema(migrations.Postgres, cfg.Postgres.DSN(), cfg); err != nil {
+			return nil, nil, err
+		}
+		db, err := sql.Open("pgx", cfg.Postgres.DSN())
+		if err != nil {
+			return nil, nil, err
+		}
+		return postgres.NewDatabaseReader(db), postgres.NewDatabaseWriter(db, cfg.Postgres.RetryPolicy()), nil
+	case config.StorageSQLite:
+		db, err := sqlite.Open(cfg.SQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer := sqlite.NewDatabaseWriter(db)
+		if err := seedSQLite(db, writer); err != nil {
+			return nil, nil, err
+		}
+		return sqlite.NewDatabaseReader(db), writer, nil
+	case config.StorageMemory:
+		log.Printf("WARNING: using the in-memory storage, data is lost on restart")
+		store := memory.NewStore()
+		err := seed(
+			func(user database.UserModel) (string, error) { return store.AddUser(user) },
+			store.AddDecision,
+			store,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported storage %q", cfg.Storage)
+	}
+}
+
+// prepareSchema migrates the database when configured to, and refuses to serve a schema older than the server
+func prepareSchema(driverName string, dsn string, cfg config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MigrateLockTimeout+time.Minute)
+	defer cancel()
+
+	if err := migrations.Prepare(ctx, driverName, dsn, cfg.MigrateOnStart, cfg.MigrateLockTimeout); err != nil {
+		return fmt.Errorf("%w, run the migrations or start with --migrate-on-start", err)
+	}
+	return nil
+}
+
+// newShardRouter opens and prepares the schema of every shard
+func newShardRouter(cfg config.Config) (database.ShardRouter, error) {
+	dsns := cfg.MySQL.ShardDSNs()
+	buckets, err := database.ParseShardMap(cfg.MySQL.ShardMap, len(dsns))
+	if err != nil {
+		return database.ShardRouter{}, err
+	}
+	var dbs []*sql.DB
+	for index, dsn := range dsns {
+		if err := prepareSchema(migrations.MySQL, dsn, cfg); err != nil {
+			return database.ShardRouter{}, fmt.Errorf("shard %d: %w", index, err)
+		}
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return database.ShardRouter{}, err
+		}
+		dbs = append(dbs, db)
+	}
+	return database.NewShardRouter(dbs, buckets, cfg.MySQL.QueryOptions())
+}
+
+// newReplicaPool opens the configured replicas and checks their health in the background
+func newReplicaPool(primary *sql.DB, cfg config.MySQLConfig) (*database.ReplicaPool, error) {
+	var replicas []*sql.DB
+	for _, dsn := range cfg.ReplicaDSNs() {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, db)
+	}
+
+	pool := database.NewReplicaPool(primary, replicas, database.ReplicaOptions{
+		MaxLag:        cfg.ReplicaMaxLag,
+		CheckInterval: cfg.ReplicaCheckInterval,
+	})
+	go pool.Run(context.Background())
+	return pool, nil
+}
+
+// seedSQLite seeds a newly created sqlite database, existing data is left untouched
+func seedSQLite(db *sql.DB, reconciler database.CounterReconciler) error {
+	ctx := context.Background()
+	var users int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return nil
+	}
+
+	return seed(
+		func(user database.UserModel) (string, error) { return sqlite.AddUser(ctx, db, user) },
+		func(actorId string, recipientId string, liked bool, isNew bool) (string, error) {
+			return sqlite.AddDecision(ctx, db, actorId, recipientId, liked, isNew)
+		},
+		reconciler,
+	)
+}
+
+// seed loads the same users and decisions as database/query.sql, then computes the counters
+func seed(
+	addUser func(user database.UserModel) (string, error),
+	addDecision func(actorId string, recipientId string, liked bool, isNew bool) (string, error),
+	reconciler database.CounterReconciler,
+) error {
+	users := []struct {
+		name   string
+		gender string
+	}{
+		{"john doe", "m"}, {"Liam Thompson", "m"}, {"Ethan Walker", "m"}, {"Mason Clark", "m"}, {"Lucas Mitchell", "m"},
+		{"Elijah Baker", "m"}, {"Benjamin Scott", "m"}, {"Harper Lewis", "m"}, {"Jacob Adams", "m"}, {"Daniel Morgan", "m"},
+		{"Emily Carter", "f"}, {"Olivia Martinez", "f"}, {"Ava Harris", "f"}, {"Isabella Robinson", "f"}, {"Charlotte Wright", "f"},
+		{"Amelia Perez", "f"}, {"Mia Green", "f"}, {"Evelyn Turner", "f"}, {"Abigail Foster", "f"}, {"Chloe Ward", "f"},
+	}
+	for _, user := range users {
+		if _, err := addUser(database.UserModel{Name: user.name, Gender: user.gender, IsAactive: true}); err != nil {
+			return err
+		}
+	}
+
+	for actor := 2; actor <= 20; actor++ {
+		liked := actor != 3 && actor != 5
+		isNew := actor >= 19
+		if _, err := addDecision(strconv.Itoa(actor), "1", liked, isNew); err != nil {
+			return err
+		}
+	}
+
+	_, err := reconcile.Job{Reconciler: reconciler, BatchSize: len(users)}.RunOnce(context.Background())
+	return err
+}
+
+// newInterceptorChain builds the interceptors shared by the gRPC server and the REST gateway
+func newInterceptorChain(cfg config.Config) (grpc.ServerOption, error) {
+	authInterceptor, err := newAuthInterceptor(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+
+	return grpc.ChainUnaryInterceptor(
+		interceptors.RecoveryUnaryServerInterceptor(),
+		authInterceptor,
+		ratelimit.UnaryServerInterceptor(ratelimit.NewMemoryStore(), cfg.RateLimits),
+		interceptors.DeadlineUnaryServerInterceptor(cfg.Timeouts),
+	), nil
+}
+
+// newAuthInterceptor builds the authentication interceptor described by the auth configuration
+func newAuthInterceptor(cfg config.AuthConfig) (grpc.UnaryServerInterceptor, error) {
+	if cfg.Disabled {
+		log.Printf("WARNING: authentication is disabled, every caller is treated as an admin")
+		return auth.InsecureUnaryServerInterceptor(), nil
+	}
+
+	opts := auth.VerifierOptions{
+		Issuer:    cfg.Issuer,
+		Audience:  cfg.Audience,
+		AdminRole: cfg.AdminRole,
+	}
+
+	var verifier auth.Verifier
+	var err error
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "HS256":
+		verifier, err = auth.NewHS256Verifier([]byte(cfg.Secret), opts)
+	case "RS256":
+		keys := map[string]*rsa.PublicKey{}
+		if cfg.JWKSFile != "" {
+			if keys, err = auth.LoadJWKSFile(cfg.JWKSFile); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.PublicKeyFile != "" {
+			key, err := auth.LoadRSAPublicKeyFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			keys[""] = key
+		}
+		verifier, err = auth.NewRS256Verifier(keys, opts)
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", cfg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.UnaryServerInterceptor(verifier), nil
+}
+
+// newGatewayServer builds the HTTP server of the REST gateway. The gateway reaches the service through an
+// in-process gRPC server sharing the interceptors of the public one, so HTTP calls are authenticated and rate
+// limited the same way. tlsConfig, when set, is used for the HTTP listener.
+func newGatewayServer(address string, service pb.ExploreServiceServer, tlsConfig *tls.Config, opts ...grpc.ServerOption) (*http.Server, error) {
+	listener := bufconn.Listen(1 << 20)
+	internalServer := grpc.NewServer(opts...)
+	pb.RegisterExploreServiceServer(internalServer, service)
+	go func() {
+		if err := internalServer.Serve(listener); err != nil {
+			log.Printf("Internal gateway server stopped: %s", err)
+		}
+	}()
+
+	conn, err := grpc.Dial("passthrough:///gateway",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := gateway.NewHandler(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
+
+func serveGateway(server *http.Server) error {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package tlsconfig_test
+
+import (
+	"app/tlsconfig"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newAuthority(t *testing.T, dir string, name string) (authority, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, name+".pem")
+	writePEM(t, path, "CERTIFICATE", der)
+	return authority{cert: cert, key: key}, path
+}
+
+// issue writes a leaf certificate and its key signed by the authority and returns their paths
+func (a authority) issue(t *testing.T, dir string, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func serve(t *testing.T, config *tls.Config) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+func check(t *testing.T, address string, config *tls.Config) error {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile := newAuthority(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	serverConfig, err := tlsconfig.Server(certFile, keyFile, "")
+	assert.NoError(t, err)
+	address := serve(t, serverConfig)
+
+	clientConfig, err := tlsconfig.Client(caFile, "", "", "localhost")
+	assert.NoError(t, err)
+	assert.NoError(t, check(t, address, clientConfig))
+
+	_, otherCAFile := newAuthority(t, t.TempDir(), "other-ca")
+	untrusted, err := tlsconfig.Client(otherCAFile, "", "", "localhost")
+	assert.NoError(t, err)
+	assert.Error(t, check(t, address, untrusted))
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile := newAuthority(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "client", 3, x509.ExtKeyUsageClientAuth)
+
+	otherCA, _ := newAuthority(t, dir, "other-ca")
+	strangerCertFile, strangerKeyFile := otherCA.issue(t, dir, "stranger", 4, x509.ExtKeyUsageClientAuth)
+
+	serverConfig, err := tlsconfig.Server(certFile, keyFile, caFile)
+	assert.NoError(t, err)
+	address := serve(t, serverConfig)
+
+	withClientCert, err := tlsconfig.Client(caFile, clientCertFile, clientKeyFile, "localhost")
+	assert.NoError(t, err)
+	assert.NoError(t, check(t, address, withClientCert))
+
+	withoutClientCert, err := tlsconfig.Client(caFile, "", "", "localhost")
+	assert.NoError(t, err)
+	assert.Error(t, check(t, address, withoutClientCert))
+
+	withStrangerCert, err := tlsconfig.Client(caFile, strangerCertFile, strangerKeyFile, "localhost")
+	assert.NoError(t, err)
+	assert.Error(t, check(t, address, withStrangerCert))
+}
+
+func TestCertReloaderPicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, _ := newAuthority(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	reloader, err := tlsconfig.NewCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	first, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	rotatedCert, rotatedKey := ca.issue(t, t.TempDir(), "server", 5, x509.ExtKeyUsageServerAuth)
+	for from, to := range map[string]string{rotatedCert: certFile, rotatedKey: keyFile} {
+		data, err := os.ReadFile(from)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(to, data, 0o600))
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(to, later, later))
+	}
+
+	second, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+
+	leaf, err := x509.ParseCertificate(second.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), leaf.SerialNumber.Int64())
+}
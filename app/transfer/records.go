@@ -0,0 +1,479 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"app/database"
+)
+
+// Format is the encoding of a file of users or decisions
+type Format string
+
+const (
+	// FormatCSV is a header row naming the columns, in any order, then a row per record
+	FormatCSV Format = "csv"
+	// FormatJSONL is a JSON object per line
+	FormatJSONL Format = "jsonl"
+)
+
+// FormatOf returns the format of a file from its extension, .csv or .jsonl
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format of %q, expected a .csv or .jsonl file", path)
+	}
+}
+
+// maxId is the largest ID of the INT columns
+const maxId = math.MaxInt32
+
+// UserRecord is a user of an import or export file. The counters are not part of it, they are recomputed once
+// the decisions are imported.
+type UserRecord struct {
+	Id        uint     `json:"id"`
+	Name      string   `json:"name"`
+	Gender    string   `json:"gender"`
+	IsActive  bool     `json:"is_active"`
+	BirthDate string   `json:"birth_date,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Bio       string   `json:"bio,omitempty"`
+	// PhotoCount is signed so a negative count is reported by the validation
+	PhotoCount   int    `json:"photo_count,omitempty"`
+	LastActiveAt uint64 `json:"last_active_at,omitempty"`
+}
+
+// userColumns are the CSV columns of a user, id and name being required
+var userColumns = []string{"id", "name", "gender", "is_active", "birth_date", "latitude", "longitude", "bio", "photo_count", "last_active_at"}
+
+func (r *UserRecord) set(column string, value string) error {
+	var err error
+	switch column {
+	case "id":
+		r.Id, err = parseUint[uint](value)
+	case "name":
+		r.Name = value
+	case "gender":
+		r.Gender = value
+	case "is_active":
+		r.IsActive, err = parseBool(value)
+	case "birth_date":
+		r.BirthDate = value
+	case "latitude":
+		r.Latitude, err = parseFloat(value)
+	case "longitude":
+		r.Longitude, err = parseFloat(value)
+	case "bio":
+		r.Bio = value
+	case "photo_count":
+		if value != "" {
+			r.PhotoCount, err = strconv.Atoi(value)
+		}
+	case "last_active_at":
+		r.LastActiveAt, err = parseUint[uint64](value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", column, value)
+	}
+	return nil
+}
+
+func (r UserRecord) fields() []string {
+	return []string{
+		formatUint(r.Id),
+		r.Name,
+		r.Gender,
+		strconv.FormatBool(r.IsActive),
+		r.BirthDate,
+		formatFloat(r.Latitude),
+		formatFloat(r.Longitude),
+		r.Bio,
+		formatUint(uint(r.PhotoCount)),
+		formatUint(r.LastActiveAt),
+	}
+}
+
+// validate checks the record against the columns of users
+func (r UserRecord) validate() error {
+	switch {
+	case r.Id == 0 || r.Id > maxId:
+		return fmt.Errorf("id must be between 1 and %d", maxId)
+	case r.Name == "":
+		return errors.New("name is required")
+	case utf8.RuneCountInString(r.Name) > 255:
+		return errors.New("name is longer than 255 characters")
+	case utf8.RuneCountInString(r.Gender) > 1:
+		return fmt.Errorf("gender %q is longer than a character", r.Gender)
+	case (r.Latitude == nil) != (r.Longitude == nil):
+		return errors.New("latitude and longitude must be set together")
+	case r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90):
+		return fmt.Errorf("latitude %v is not between -90 and 90", *r.Latitude)
+	case r.Longitude != nil && (*r.Longitude < -180 || *r.Longitude > 180):
+		return fmt.Errorf("longitude %v is not between -180 and 180", *r.Longitude)
+	case utf8.RuneCountInString(r.Bio) > 500:
+		return errors.New("bio is longer than 500 characters")
+	case r.PhotoCount < 0 || r.PhotoCount > maxId:
+		return fmt.Errorf("photo_count must be between 0 and %d", maxId)
+	case r.LastActiveAt > math.MaxUint32:
+		return fmt.Errorf("last_active_at %d is not a unix timestamp", r.LastActiveAt)
+	}
+	if r.BirthDate != "" {
+		if _, err := time.Parse(time.DateOnly, r.BirthDate); err != nil {
+			return fmt.Errorf("birth_date %q is not a YYYY-MM-DD date", r.BirthDate)
+		}
+	}
+	return nil
+}
+
+func (r UserRecord) user() database.UserModel {
+	user := database.UserModel{
+		Id:           formatUint(r.Id),
+		Name:         r.Name,
+		Gender:       r.Gender,
+		IsAactive:    r.IsActive,
+		BirthDate:    r.BirthDate,
+		Bio:          r.Bio,
+		PhotoCount:   uint(r.PhotoCount),
+		LastActiveAt: r.LastActiveAt,
+	}
+	if r.Latitude != nil {
+		user.Location = &database.Location{Latitude: *r.Latitude, Longitude: *r.Longitude}
+	}
+	return user
+}
+
+func userRecord(user database.UserModel) (UserRecord, error) {
+	id, err := parseUint[uint](user.Id)
+	if err != nil {
+		return UserRecord{}, fmt.Errorf("invalid user id %q", user.Id)
+	}
+	r := UserRecord{
+		Id:           id,
+		Name:         user.Name,
+		Gender:       user.Gender,
+		IsActive:     user.IsAactive,
+		BirthDate:    user.BirthDate,
+		Bio:          user.Bio,
+		PhotoCount:   int(user.PhotoCount),
+		LastActiveAt: user.LastActiveAt,
+	}
+	if user.Location != nil {
+		r.Latitude, r.Longitude = &user.Location.Latitude, &user.Location.Longitude
+	}
+	return r, nil
+}
+
+// DecisionRecord is a decision of an import or export file. A decision is identified by its pair of users, the
+// IDs being allocated by the database it is imported into.
+type DecisionRecord struct {
+	ActorId     uint  `json:"actor_id"`
+	RecipientId uint  `json:"recipient_id"`
+	Liked       *bool `json:"liked"`
+	IsNew       bool  `json:"is_new,omitempty"`
+	// CreatedAt and UpdatedAt are unix timestamps, the time of the import when 0
+	CreatedAt uint64 `json:"created_at,omitempty"`
+	UpdatedAt uint64 `json:"updated_at,omitempty"`
+}
+
+// decisionColumns are the CSV columns of a decision, actor_id, recipient_id and liked being required
+var decisionColumns = []string{"actor_id", "recipient_id", "liked", "is_new", "created_at", "updated_at"}
+
+func (r *DecisionRecord) set(column string, value string) error {
+	var err error
+	switch column {
+	case "actor_id":
+		r.ActorId, err = parseUint[uint](value)
+	case "recipient_id":
+		r.RecipientId, err = parseUint[uint](value)
+	case "liked":
+		if value != "" {
+			var liked bool
+			liked, err = parseBool(value)
+			r.Liked = &liked
+		}
+	case "is_new":
+		r.IsNew, err = parseBool(value)
+	case "created_at":
+		r.CreatedAt, err = parseUint[uint64](value)
+	case "updated_at":
+		r.UpdatedAt, err = parseUint[uint64](value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", column, value)
+	}
+	return nil
+}
+
+func (r DecisionRecord) fields() []string {
+	return []string{
+		formatUint(r.ActorId),
+		formatUint(r.RecipientId),
+		strconv.FormatBool(r.Liked != nil && *r.Liked),
+		strconv.FormatBool(r.IsNew),
+		formatUint(r.CreatedAt),
+		formatUint(r.UpdatedAt),
+	}
+}
+
+// validate checks the record against the columns of decisions and the invariants of the writers, the users
+// being checked by the Importer
+func (r DecisionRecord) validate() error {
+	switch {
+	case r.ActorId == 0 || r.ActorId > maxId:
+		return fmt.Errorf("actor_id must be between 1 and %d", maxId)
+	case r.RecipientId == 0 || r.RecipientId > maxId:
+		return fmt.Errorf("recipient_id must be between 1 and %d", maxId)
+	case r.ActorId == r.RecipientId:
+		return errors.New("a user cannot decide on themselves")
+	case r.Liked == nil:
+		return errors.New("liked is required")
+	case r.IsNew && !*r.Liked:
+		return errors.New("a pass cannot be new")
+	case r.CreatedAt > math.MaxUint32 || r.UpdatedAt > math.MaxUint32:
+		return errors.New("created_at and updated_at must be unix timestamps")
+	case r.CreatedAt > 0 && r.UpdatedAt > 0 && r.UpdatedAt < r.CreatedAt:
+		return errors.New("updated_at is before created_at")
+	}
+	return nil
+}
+
+func (r DecisionRecord) decision() database.BulkDecision {
+	return database.BulkDecision{
+		ActorId:     r.ActorId,
+		RecipientId: r.RecipientId,
+		Liked:       *r.Liked,
+		IsNew:       r.IsNew,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+}
+
+func decisionRecord(decision database.BulkDecision) DecisionRecord {
+	liked := decision.Liked
+	return DecisionRecord{
+		ActorId:     decision.ActorId,
+		RecipientId: decision.RecipientId,
+		Liked:       &liked,
+		IsNew:       decision.IsNew,
+		CreatedAt:   decision.CreatedAt,
+		UpdatedAt:   decision.UpdatedAt,
+	}
+}
+
+// parseUint parses an unsigned integer, 0 when empty
+func parseUint[T uint | uint64](value string) (T, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	return T(parsed), err
+}
+
+// parseBool parses a boolean, false when empty
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// parseFloat parses a float, nil when empty
+func parseFloat(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		return nil, errors.New("invalid float")
+	}
+	return &parsed, nil
+}
+
+// formatUint formats an unsigned integer, empty when 0
+func formatUint[T uint | uint64](value T) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+func formatFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// record is a row of a file, set column by column when read from a CSV file
+type record interface {
+	set(column string, value string) error
+	fields() []string
+}
+
+// row is a line of an import file, which decode reads into a record
+type row struct {
+	line   int
+	text   string
+	decode func(r record) error
+}
+
+// rowReader reads the rows of an import file
+type rowReader struct {
+	csv     *csv.Reader
+	header  []string
+	scanner *bufio.Scanner
+	line    int
+}
+
+// maxLineSize bounds a line of a JSONL file
+const maxLineSize = 1 << 20
+
+// newRowReader reads the rows of a file, after checking the header of a CSV file has the required columns and
+// only known ones
+func newRowReader(r io.Reader, format Format, columns []string, required int) (*rowReader, error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the header: %w", err)
+		}
+		for i := range header {
+			header[i] = strings.TrimSpace(header[i])
+		}
+		seen := make(map[string]bool, len(header))
+		for _, column := range header {
+			if !slices.Contains(columns, column) {
+				return nil, fmt.Errorf("unknown column %q, expected some of %s", column, strings.Join(columns, ", "))
+			}
+			if seen[column] {
+				return nil, fmt.Errorf("column %q appears twice", column)
+			}
+			seen[column] = true
+		}
+		for _, column := range columns[:required] {
+			if !seen[column] {
+				return nil, fmt.Errorf("missing column %q", column)
+			}
+		}
+		return &rowReader{csv: reader, header: header}, nil
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		return &rowReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// next returns the next row, io.EOF after the last one. A line that cannot be split into fields is returned as a
+// row failing to decode.
+func (r *rowReader) next() (row, error) {
+	if r.csv != nil {
+		return r.nextCSV()
+	}
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		text := string(line)
+		return row{line: r.line, text: text, decode: func(dst record) error {
+			decoder := json.NewDecoder(strings.NewReader(text))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(dst); err != nil {
+				return fmt.Errorf("invalid JSON: %w", err)
+			}
+			if decoder.More() {
+				return errors.New("invalid JSON: more than an object on the line")
+			}
+			return nil
+		}}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return row{}, err
+	}
+	return row{}, io.EOF
+}
+
+func (r *rowReader) nextCSV() (row, error) {
+	fields, err := r.csv.Read()
+	line, _ := r.csv.FieldPos(0)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return row{line: parseErr.StartLine, decode: func(record) error { return parseErr.Err }}, nil
+	}
+	if err != nil {
+		return row{}, err
+	}
+
+	var text strings.Builder
+	writer := csv.NewWriter(&text)
+	writer.Write(fields)
+	writer.Flush()
+	return row{line: line, text: strings.TrimSuffix(text.String(), "\n"), decode: func(dst record) error {
+		if len(fields) != len(r.header) {
+			return fmt.Errorf("%d fields, the header has %d", len(fields), len(r.header))
+		}
+		for i, column := range r.header {
+			if err := dst.set(column, strings.TrimSpace(fields[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}, nil
+}
+
+// rowWriter writes the rows of an export file
+type rowWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// newRowWriter writes the header of a CSV file
+func newRowWriter(w io.Writer, format Format, columns []string) (*rowWriter, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		return &rowWriter{csv: writer}, writer.Write(columns)
+	case FormatJSONL:
+		return &rowWriter{json: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func (w *rowWriter) write(r record) error {
+	if w.csv != nil {
+		return w.csv.Write(r.fields())
+	}
+	return w.json.Encode(r)
+}
+
+// flush writes the buffered CSV rows
+func (w *rowWriter) flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
@@ -0,0 +1,318 @@
+// Package transfer imports and exports users and decisions as CSV or JSONL files, e.g. to move data between
+// environments or to load the data of a legacy system. Imported rows are validated first, those failing are
+// reported and skipped, and the others are upserted in batches, so an interrupted import can be run again.
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"app/database"
+	"app/reconcile"
+)
+
+// Source is a file to import, skipped when Reader is nil
+type Source struct {
+	// Name identifies the file in the rejections
+	Name   string
+	Reader io.Reader
+	Format Format
+}
+
+// Destination is a file to export to, skipped when Writer is nil
+type Destination struct {
+	Writer io.Writer
+	Format Format
+}
+
+// Rejection is a row left out of an import
+type Rejection struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+	Row    string `json:"row,omitempty"`
+}
+
+type ImportSummary struct {
+	Users     int `json:"users"`
+	Decisions int `json:"decisions"`
+	Rejected  int `json:"rejected"`
+	// Repaired is the number of counters recomputed once the decisions are imported
+	Repaired int `json:"repaired"`
+}
+
+type ExportSummary struct {
+	Users     int `json:"users"`
+	Decisions int `json:"decisions"`
+}
+
+// Importer upserts the users, then the decisions, in batches of BatchSize, and recomputes the counters of every
+// user at the end. A decision is rejected when one of its users is neither imported nor in the database.
+type Importer struct {
+	Writer     database.BulkImporter
+	Reconciler database.CounterReconciler
+	BatchSize  int
+	// Rejected receives a JSON Rejection per line, the rejections are only counted when nil
+	Rejected io.Writer
+}
+
+// pendingDecision is a valid decision waiting for the check of its users
+type pendingDecision struct {
+	row    row
+	record DecisionRecord
+}
+
+// importer is the state of an import
+type importer struct {
+	Importer
+	rejected *json.Encoder
+	// users tells whether a user checked for decisions exists
+	users   map[uint]bool
+	summary ImportSummary
+}
+
+func (i Importer) Import(ctx context.Context, users Source, decisions Source) (ImportSummary, error) {
+	if i.BatchSize < 1 || i.BatchSize > database.MaxBulkRows {
+		return ImportSummary{}, fmt.Errorf("invalid batch size %d, it must be between 1 and %d", i.BatchSize, database.MaxBulkRows)
+	}
+	im := importer{Importer: i, users: map[uint]bool{}}
+	if i.Rejected != nil {
+		im.rejected = json.NewEncoder(i.Rejected)
+	}
+
+	if users.Reader != nil {
+		if err := im.importUsers(ctx, users); err != nil {
+			return im.summary, fmt.Errorf("unable to import %s: %w", users.Name, err)
+		}
+	}
+	if decisions.Reader != nil {
+		if err := im.importDecisions(ctx, decisions); err != nil {
+			return im.summary, fmt.Errorf("unable to import %s: %w", decisions.Name, err)
+		}
+	}
+
+	repaired, err := reconcile.Job{Reconciler: i.Reconciler, BatchSize: i.BatchSize}.RunOnce(ctx)
+	im.summary.Repaired = repaired
+	if err != nil {
+		return im.summary, fmt.Errorf("unable to recompute the counters: %w", err)
+	}
+	return im.summary, nil
+}
+
+func (im *importer) importUsers(ctx context.Context, source Source) error {
+	reader, err := newRowReader(source.Reader, source.Format, userColumns, 2)
+	if err != nil {
+		return err
+	}
+
+	var batch []database.UserModel
+	for {
+		row, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var record UserRecord
+		if err := decodeRecord(row, &record); err != nil {
+			if err := im.reject(source, row, err); err != nil {
+				return err
+			}
+			continue
+		}
+		im.users[record.Id] = true
+		batch = append(batch, record.user())
+		if len(batch) == im.BatchSize {
+			if err := im.Writer.UpsertUsers(ctx, batch); err != nil {
+				return err
+			}
+			im.summary.Users += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if err := im.Writer.UpsertUsers(ctx, batch); err != nil {
+		return err
+	}
+	im.summary.Users += len(batch)
+	return nil
+}
+
+func (im *importer) importDecisions(ctx context.Context, source Source) error {
+	reader, err := newRowReader(source.Reader, source.Format, decisionColumns, 3)
+	if err != nil {
+		return err
+	}
+
+	var batch []pendingDecision
+	for {
+		row, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var record DecisionRecord
+		if err := decodeRecord(row, &record); err != nil {
+			if err := im.reject(source, row, err); err != nil {
+				return err
+			}
+			continue
+		}
+		batch = append(batch, pendingDecision{row: row, record: record})
+		if len(batch) == im.BatchSize {
+			if err := im.upsertDecisions(ctx, source, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return im.upsertDecisions(ctx, source, batch)
+}
+
+// upsertDecisions rejects the decisions of unknown users and upserts the others
+func (im *importer) upsertDecisions(ctx context.Context, source Source, batch []pendingDecision) error {
+	var unchecked []uint
+	for _, pending := range batch {
+		for _, id := range []uint{pending.record.ActorId, pending.record.RecipientId} {
+			if _, checked := im.users[id]; !checked {
+				im.users[id] = false
+				unchecked = append(unchecked, id)
+			}
+		}
+	}
+	for len(unchecked) > 0 {
+		chunk := unchecked[:min(len(unchecked), database.MaxBulkRows)]
+		unchecked = unchecked[len(chunk):]
+		found, err := im.Writer.FindUserIds(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		for _, id := range found {
+			im.users[id] = true
+		}
+	}
+
+	decisions := make([]database.BulkDecision, 0, len(batch))
+	for _, pending := range batch {
+		var err error
+		switch {
+		case !im.users[pending.record.ActorId]:
+			err = fmt.Errorf("unknown actor_id %d", pending.record.ActorId)
+		case !im.users[pending.record.RecipientId]:
+			err = fmt.Errorf("unknown recipient_id %d", pending.record.RecipientId)
+		}
+		if err != nil {
+			if err := im.reject(source, pending.row, err); err != nil {
+				return err
+			}
+			continue
+		}
+		decisions = append(decisions, pending.record.decision())
+	}
+
+	if err := im.Writer.UpsertDecisions(ctx, decisions); err != nil {
+		return err
+	}
+	im.summary.Decisions += len(decisions)
+	return nil
+}
+
+// decodeRecord decodes and validates a row
+func decodeRecord[R interface {
+	record
+	validate() error
+}](row row, record R) error {
+	if err := row.decode(record); err != nil {
+		return err
+	}
+	return record.validate()
+}
+
+// reject counts a row left out and reports it
+func (im *importer) reject(source Source, row row, reason error) error {
+	im.summary.Rejected++
+	if im.rejected == nil {
+		return nil
+	}
+	return im.rejected.Encode(Rejection{File: source.Name, Line: row.line, Reason: reason.Error(), Row: row.text})
+}
+
+// Exporter writes every user, then every decision, archived ones included, in ID order, reading them in batches
+// of BatchSize
+type Exporter struct {
+	Reader    database.BulkExporter
+	BatchSize int
+}
+
+func (e Exporter) Export(ctx context.Context, users Destination, decisions Destination) (ExportSummary, error) {
+	if e.BatchSize < 1 {
+		return ExportSummary{}, errors.New("the batch size must be positive")
+	}
+	var summary ExportSummary
+
+	if users.Writer != nil {
+		writer, err := newRowWriter(users.Writer, users.Format, userColumns)
+		if err != nil {
+			return summary, err
+		}
+		for afterId := uint(0); ; {
+			batch, err := e.Reader.ExportUsers(ctx, afterId, e.BatchSize)
+			if err != nil {
+				return summary, err
+			}
+			if len(batch) == 0 {
+				break
+			}
+			for _, user := range batch {
+				record, err := userRecord(user)
+				if err != nil {
+					return summary, err
+				}
+				if err := writer.write(&record); err != nil {
+					return summary, err
+				}
+				afterId = record.Id
+			}
+			summary.Users += len(batch)
+		}
+		if err := writer.flush(); err != nil {
+			return summary, err
+		}
+	}
+
+	if decisions.Writer != nil {
+		writer, err := newRowWriter(decisions.Writer, decisions.Format, decisionColumns)
+		if err != nil {
+			return summary, err
+		}
+		for afterId := uint(0); ; {
+			batch, err := e.Reader.ExportDecisions(ctx, afterId, e.BatchSize)
+			if err != nil {
+				return summary, err
+			}
+			if len(batch) == 0 {
+				break
+			}
+			for _, decision := range batch {
+				record := decisionRecord(decision)
+				if err := writer.write(&record); err != nil {
+					return summary, err
+				}
+				afterId = decision.Id
+			}
+			summary.Decisions += len(batch)
+		}
+		if err := writer.flush(); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
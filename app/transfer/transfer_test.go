@@ -0,0 +1,197 @@
+package transfer_test
+
+import (
+	"app/database"
+	"app/database/sqlite"
+	"app/transfer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func newDatabase(t *testing.T) (sqlite.DatabaseWriter, sqlite.DatabaseReader) {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "muzzapp.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return sqlite.NewDatabaseWriter(db), sqlite.NewDatabaseReader(db)
+}
+
+const usersCSV = `id,name,gender,is_active,birth_date,latitude,longitude,bio,photo_count,last_active_at
+1,ana,f,true,1990-05-17,51.507351,-0.127758,"Coffee, climbing",3,1700000000
+2,bruno,m,1,,,,,,
+3,carla,f,true,17/05/1990,,,,,
+4,,m,true,,,,,,
+5,dora,f,true,,48.8,,,,
+6,eva,f,false,,,,,,
+`
+
+const decisionsJSONL = `{"actor_id": 1, "recipient_id": 2, "liked": true, "is_new": true, "created_at": 1600000000, "updated_at": 1600000100}
+{"actor_id": 2, "recipient_id": 1, "liked": true}
+
+{"actor_id": 6, "recipient_id": 1, "liked": true}
+{"actor_id": 1, "recipient_id": 3, "liked": true}
+{"actor_id": 2, "recipient_id": 2, "liked": true}
+{"actor_id": 2, "recipient_id": 6, "liked": false, "is_new": true}
+{"actor_id": 2, "recipient_id": 6}
+{"actor_id": 2, "recipient_id": 6, "liked": true, "super": true}
+{"actor_id": 2,
+`
+
+func TestImport(t *testing.T) {
+	writer, reader := newDatabase(t)
+	var rejected bytes.Buffer
+	importer := transfer.Importer{Writer: writer, Reconciler: writer, BatchSize: 2, Rejected: &rejected}
+
+	summary, err := importer.Import(context.Background(),
+		transfer.Source{Name: "users.csv", Reader: strings.NewReader(usersCSV), Format: transfer.FormatCSV},
+		transfer.Source{Name: "decisions.jsonl", Reader: strings.NewReader(decisionsJSONL), Format: transfer.FormatJSONL},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.ImportSummary{Users: 3, Decisions: 3, Rejected: 9, Repaired: summary.Repaired}, summary)
+
+	var rejections []transfer.Rejection
+	decoder := json.NewDecoder(&rejected)
+	for decoder.More() {
+		var rejection transfer.Rejection
+		assert.NoError(t, decoder.Decode(&rejection))
+		rejections = append(rejections, rejection)
+	}
+	assert.Equal(t, 9, len(rejections))
+	for i, want := range []struct {
+		file   string
+		line   int
+		reason string
+	}{
+		{"users.csv", 4, "birth_date"},
+		{"users.csv", 5, "name is required"},
+		{"users.csv", 6, "latitude and longitude"},
+		{"decisions.jsonl", 5, "unknown recipient_id 3"},
+		{"decisions.jsonl", 6, "themselves"},
+		{"decisions.jsonl", 7, "a pass cannot be new"},
+		{"decisions.jsonl", 8, "liked is required"},
+		{"decisions.jsonl", 9, "unknown field"},
+		{"decisions.jsonl", 10, "invalid JSON"},
+	} {
+		assert.Equal(t, want.file, rejections[i].File)
+		assert.Equal(t, want.line, rejections[i].Line)
+		assert.That(t, strings.Contains(rejections[i].Reason, want.reason))
+		assert.That(t, rejections[i].Row != "")
+	}
+
+	ana, err := reader.GetUserById(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ana", ana.Name)
+	assert.Equal(t, "Coffee, climbing", ana.Bio)
+	assert.DeepEqual(t, &database.Location{Latitude: 51.507351, Longitude: -0.127758}, ana.Location)
+	// the counters are recomputed, the like of the inactive user included
+	assert.Equal(t, [3]uint{2, 0, 1}, [3]uint{ana.Likes, ana.NewLikes, ana.Matches})
+	bruno, err := reader.GetUserById(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, [3]uint{1, 1, 1}, [3]uint{bruno.Likes, bruno.NewLikes, bruno.Matches})
+
+	likes, err := reader.FindNewLikesByRecipientIdPaginated(context.Background(), "2", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(likes))
+	assert.Equal(t, uint64(1600000000), likes[0].Created_at)
+}
+
+func TestImportIsIdempotent(t *testing.T) {
+	writer, _ := newDatabase(t)
+	importer := transfer.Importer{Writer: writer, Reconciler: writer, BatchSize: 10}
+	for range 2 {
+		summary, err := importer.Import(context.Background(),
+			transfer.Source{Name: "users.csv", Reader: strings.NewReader(usersCSV), Format: transfer.FormatCSV},
+			transfer.Source{Name: "decisions.jsonl", Reader: strings.NewReader(decisionsJSONL), Format: transfer.FormatJSONL},
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, summary.Decisions)
+	}
+
+	var exported bytes.Buffer
+	summary, err := transfer.Exporter{Reader: writer, BatchSize: 10}.Export(context.Background(),
+		transfer.Destination{}, transfer.Destination{Writer: &exported, Format: transfer.FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.ExportSummary{Decisions: 3}, summary)
+}
+
+func TestInvalidHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "missing_required_column", header: "id,gender"},
+		{name: "unknown_column", header: "id,name,likes"},
+		{name: "duplicate_column", header: "id,name,name"},
+		{name: "empty_file", header: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer, _ := newDatabase(t)
+			importer := transfer.Importer{Writer: writer, Reconciler: writer, BatchSize: 10}
+			_, err := importer.Import(context.Background(),
+				transfer.Source{Name: "users.csv", Reader: strings.NewReader(test.header), Format: transfer.FormatCSV},
+				transfer.Source{},
+			)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestRoundTrip exports a database in both formats and imports every export into an empty database, which
+// exports the same files
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	writer, _ := newDatabase(t)
+	_, err := transfer.Importer{Writer: writer, Reconciler: writer, BatchSize: 2}.Import(ctx,
+		transfer.Source{Name: "users.csv", Reader: strings.NewReader(usersCSV), Format: transfer.FormatCSV},
+		transfer.Source{Name: "decisions.jsonl", Reader: strings.NewReader(decisionsJSONL), Format: transfer.FormatJSONL},
+	)
+	assert.NoError(t, err)
+
+	for _, format := range []transfer.Format{transfer.FormatCSV, transfer.FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			users, decisions := export(t, writer, format)
+			assert.That(t, strings.Contains(users, "Coffee, climbing"))
+
+			imported, _ := newDatabase(t)
+			summary, err := transfer.Importer{Writer: imported, Reconciler: imported, BatchSize: 2}.Import(ctx,
+				transfer.Source{Name: "users", Reader: strings.NewReader(users), Format: format},
+				transfer.Source{Name: "decisions", Reader: strings.NewReader(decisions), Format: format},
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, summary.Rejected)
+			assert.Equal(t, transfer.ImportSummary{Users: 3, Decisions: 3, Repaired: summary.Repaired}, summary)
+
+			importedUsers, importedDecisions := export(t, imported, format)
+			assert.Equal(t, users, importedUsers)
+			assert.Equal(t, decisions, importedDecisions)
+		})
+	}
+}
+
+func export(t *testing.T, writer sqlite.DatabaseWriter, format transfer.Format) (string, string) {
+	var users, decisions bytes.Buffer
+	summary, err := transfer.Exporter{Reader: writer, BatchSize: 2}.Export(context.Background(),
+		transfer.Destination{Writer: &users, Format: format},
+		transfer.Destination{Writer: &decisions, Format: format},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.ExportSummary{Users: 3, Decisions: 3}, summary)
+	return users.String(), decisions.String()
+}
+
+func TestFormatOf(t *testing.T) {
+	format, err := transfer.FormatOf("users.CSV")
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.FormatCSV, format)
+	format, err = transfer.FormatOf("dump/decisions.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, transfer.FormatJSONL, format)
+	_, err = transfer.FormatOf("users.json")
+	assert.Error(t, err)
+}